@@ -0,0 +1,72 @@
+package goexws
+
+import (
+	"context"
+
+	"github.com/goex-top/goexws/common"
+	"github.com/nntaoli-project/goex"
+)
+
+type FuturesWsApi interface {
+	DepthCallback(func(depth *goex.Depth))
+	TickerCallback(func(ticker *goex.FutureTicker))
+	TradeCallback(func(trade *goex.Trade, contract string))
+	KlineCallback(func(kline *goex.FutureKline, period int, contract string))
+	SubscribeDepth(pair goex.CurrencyPair, size int, contractType string) error
+	SubscribeTicker(pair goex.CurrencyPair, contractType string) error
+	SubscribeTrade(pair goex.CurrencyPair, contractType string) error
+	SubscribeKline(pair goex.CurrencyPair, period int, contractType string) error
+	UnsubscribeDepth(pair goex.CurrencyPair, contractType string) error
+	UnsubscribeTicker(pair goex.CurrencyPair, contractType string) error
+	UnsubscribeTrade(pair goex.CurrencyPair, contractType string) error
+	UnsubscribeKline(pair goex.CurrencyPair, period int, contractType string) error
+	SubscribeDepthChan(ctx context.Context, pair goex.CurrencyPair, size int, contractType string, opts ...common.StreamOption) (<-chan *goex.Depth, error)
+	SubscribeTickerChan(ctx context.Context, pair goex.CurrencyPair, contractType string, opts ...common.StreamOption) (<-chan *goex.FutureTicker, error)
+	SubscribeTradeChan(ctx context.Context, pair goex.CurrencyPair, contractType string, opts ...common.StreamOption) (<-chan *goex.Trade, error)
+	SubscribeKlineChan(ctx context.Context, pair goex.CurrencyPair, period int, contractType string, opts ...common.StreamOption) (<-chan *goex.FutureKline, error)
+	SubscribeDepthFunc(pair goex.CurrencyPair, size int, contractType string, call func(depth *goex.Depth)) error
+	SubscribeTickerFunc(pair goex.CurrencyPair, contractType string, call func(ticker *goex.FutureTicker)) error
+	SubscribeTradeFunc(pair goex.CurrencyPair, contractType string, call func(trade *goex.Trade, contract string)) error
+	SubscribeKlineFunc(pair goex.CurrencyPair, period int, contractType string, call func(kline *goex.FutureKline, period int, contract string)) error
+	// SetDispatcher sets how the data callbacks are run, it has to be called
+	// before subscribing.
+	SetDispatcher(dispatcher common.Dispatcher)
+	// Close tears down the connections, no callback is called once it
+	// returns.
+	Close() error
+}
+
+// SwapWsApi is the perpetual swap flavour of FuturesWsApi, contractType is
+// always goex.SWAP_CONTRACT.
+type SwapWsApi interface {
+	FuturesWsApi
+}
+
+type SpotWsApi interface {
+	DepthCallback(func(depth *goex.Depth))
+	TickerCallback(func(ticker *goex.Ticker))
+	TradeCallback(func(trade *goex.Trade))
+	KlineCallback(func(*goex.Kline, int))
+	SubscribeDepth(pair goex.CurrencyPair, size int) error
+	SubscribeTicker(pair goex.CurrencyPair) error
+	SubscribeTrade(pair goex.CurrencyPair) error
+	SubscribeKline(pair goex.CurrencyPair, period int) error
+	UnsubscribeDepth(pair goex.CurrencyPair) error
+	UnsubscribeTicker(pair goex.CurrencyPair) error
+	UnsubscribeTrade(pair goex.CurrencyPair) error
+	UnsubscribeKline(pair goex.CurrencyPair, period int) error
+	SubscribeDepthChan(ctx context.Context, pair goex.CurrencyPair, size int, opts ...common.StreamOption) (<-chan *goex.Depth, error)
+	SubscribeTickerChan(ctx context.Context, pair goex.CurrencyPair, opts ...common.StreamOption) (<-chan *goex.Ticker, error)
+	SubscribeTradeChan(ctx context.Context, pair goex.CurrencyPair, opts ...common.StreamOption) (<-chan *goex.Trade, error)
+	SubscribeKlineChan(ctx context.Context, pair goex.CurrencyPair, period int, opts ...common.StreamOption) (<-chan *goex.Kline, error)
+	SubscribeDepthFunc(pair goex.CurrencyPair, size int, call func(depth *goex.Depth)) error
+	SubscribeTickerFunc(pair goex.CurrencyPair, call func(ticker *goex.Ticker)) error
+	SubscribeTradeFunc(pair goex.CurrencyPair, call func(trade *goex.Trade)) error
+	SubscribeKlineFunc(pair goex.CurrencyPair, period int, call func(kline *goex.Kline)) error
+	// SetDispatcher sets how the data callbacks are run, it has to be called
+	// before subscribing.
+	SetDispatcher(dispatcher common.Dispatcher)
+	// Close tears down the connections, no callback is called once it
+	// returns.
+	Close() error
+}
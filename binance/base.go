@@ -0,0 +1,561 @@
+package binance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/goex-top/goexws/common"
+	. "github.com/nntaoli-project/goex"
+	"time"
+)
+
+var futuresDepthSizes = []int{5, 10, 20}
+
+// baseWs is the stream plumbing shared by the USDⓈ-M perpetual swap and the
+// COIN-M delivery futures websockets. Both speak the same payloads and only
+// differ in endpoint, symbol naming and kline volume units.
+type baseWs struct {
+	baseURL         string
+	combinedBaseURL string
+	proxyUrl        string
+	tickerCallback  common.Callback[func(*FutureTicker)]
+	depthCallback   common.Callback[func(*Depth)]
+	tradeCallback   common.Callback[func(*Trade, string)]
+	klineCallback   common.Callback[func(*FutureKline, int, string)]
+	dispatcher      common.Dispatcher
+	depthRoutes     common.Fanout[*Depth]
+	tickerRoutes    common.Fanout[*FutureTicker]
+	tradeRoutes     common.Fanout[*Trade]
+	klineRoutes     common.Fanout[*FutureKline]
+	tradeFuncs      common.Fanout[common.ContractTrade]
+	klineFuncs      common.Fanout[common.ContractKline]
+	events          common.Events
+	errs            common.Errors
+	log             common.Log
+	panics          common.Panics
+	clock           common.Clock
+	streams         *streamMux
+
+	// resolveContract turns a pair and goex contract type into the lower case
+	// stream symbol and the contract name reported back to the callbacks.
+	resolveContract func(pair CurrencyPair, contractType string) (symbol string, contract string, err error)
+	// coinMargined marks COIN-M streams, whose kline "v" is counted in
+	// contracts and "q" in the base asset.
+	coinMargined bool
+}
+
+func (bnWs *baseWs) ProxyUrl(proxyUrl string) {
+	bnWs.proxyUrl = proxyUrl
+}
+
+func (bnWs *baseWs) SetBaseUrl(baseURL string) {
+	bnWs.baseURL = baseURL
+}
+
+func (bnWs *baseWs) SetCombinedBaseURL(combinedBaseURL string) {
+	bnWs.combinedBaseURL = combinedBaseURL
+}
+
+func (bnWs *baseWs) SetCallbacks(
+	tickerCallback func(*FutureTicker),
+	depthCallback func(*Depth),
+	tradeCallback func(*Trade, string),
+	klineCallback func(*FutureKline, int, string),
+) {
+	bnWs.tickerCallback.Set(tickerCallback)
+	bnWs.depthCallback.Set(depthCallback)
+	bnWs.tradeCallback.Set(tradeCallback)
+	bnWs.klineCallback.Set(klineCallback)
+}
+
+func (bnWs *baseWs) DepthCallback(depthCallback func(*Depth)) {
+	bnWs.depthCallback.Set(depthCallback)
+}
+
+func (bnWs *baseWs) TickerCallback(tickerCallback func(*FutureTicker)) {
+	bnWs.tickerCallback.Set(tickerCallback)
+}
+
+func (bnWs *baseWs) TradeCallback(tradeCallback func(*Trade, string)) {
+	bnWs.tradeCallback.Set(tradeCallback)
+}
+
+func (bnWs *baseWs) KlineCallback(klineCallback func(*FutureKline, int, string)) {
+	bnWs.klineCallback.Set(klineCallback)
+}
+
+// SetMaxStreamsPerConn sets how many streams are packed into one combined
+// stream connection before another one is opened.
+func (bnWs *baseWs) SetMaxStreamsPerConn(maxStreams int) {
+	bnWs.streams.setMaxStreams(maxStreams)
+}
+
+// ErrorCallback gets the messages that could not be parsed or handled, the
+// error replies of the server and the rejected streams.
+func (bnWs *baseWs) ErrorCallback(call func(err error)) {
+	bnWs.errs.SetCallback(call)
+}
+
+// SetLogger sets where the connection lifecycle and the message errors are
+// logged, nothing is logged by default.
+func (bnWs *baseWs) SetLogger(logger common.Logger) {
+	bnWs.log.SetLogger(logger)
+}
+
+// LogFrames turns debug logging of every raw frame sent and received on or
+// off, it takes effect right away.
+func (bnWs *baseWs) LogFrames(on bool) {
+	bnWs.log.SetFrames(on)
+}
+
+// RecoverPanics turns the recovery of panics in the callbacks and message
+// handling on or off, it is on by default. A recovered panic is handed to the
+// error callback as a *common.PanicError and the connection stays up.
+func (bnWs *baseWs) RecoverPanics(on bool) {
+	bnWs.panics.SetRecover(on)
+}
+
+// LifecycleCallback is told whenever a connection comes up, drops, is
+// redialed and has its streams restored.
+func (bnWs *baseWs) LifecycleCallback(call func(event *common.Event)) {
+	bnWs.events.SetCallback(call)
+}
+
+// SetMaxSilence sets how long streams of channelType may go without data
+// before their connection is dropped and redialed, 0 turns the check off. It
+// applies to the streams subscribed afterwards.
+func (bnWs *baseWs) SetMaxSilence(channelType common.ChannelType, maxSilence time.Duration) {
+	bnWs.streams.maxSilence.Set(channelType, maxSilence)
+}
+
+// dialStreams opens a combined stream connection. Binance pings every few
+// minutes and the pongs are answered by the connection itself, so there is
+// no heartbeat of our own.
+func (bnWs *baseWs) dialStreams(stream string, handle func(msg []byte) error, connected func()) streamSender {
+	url := bnWs.combinedBaseURL + stream
+	conn := common.NewConn(common.ConnConfig{
+		URL:               url,
+		ProxyURL:          bnWs.proxyUrl,
+		ReconnectInterval: time.Second,
+		Handle:            handle,
+		OnConnected: func() {
+			connected()
+			bnWs.events.Emit(common.Event{Type: common.Resubscribed, URL: url})
+		},
+		OnEvent: bnWs.events.Emit,
+		OnError: bnWs.errs.Report,
+		Log:     &bnWs.log,
+		Panics:  &bnWs.panics,
+		Clock:   bnWs.clock,
+	})
+	conn.Start()
+	return conn
+}
+
+// SetDispatcher sets how the data callbacks are run, they are serial per
+// symbol by default. It has to be called before subscribing.
+func (bnWs *baseWs) SetDispatcher(dispatcher common.Dispatcher) {
+	bnWs.dispatcher = dispatcher
+}
+
+// Close closes every connection and the subscription channels, no callback
+// is called once it returns. It must not be called from a callback.
+func (bnWs *baseWs) Close() error {
+	err := bnWs.streams.close()
+	bnWs.dispatcher.Close()
+	bnWs.depthRoutes.Close()
+	bnWs.tickerRoutes.Close()
+	bnWs.tradeRoutes.Close()
+	bnWs.klineRoutes.Close()
+	bnWs.tradeFuncs.Close()
+	bnWs.klineFuncs.Close()
+	return err
+}
+
+// SubscribeDepthChan subscribes to the depth of the contract and delivers it
+// on the returned channel, alongside the depth callback if one is set. The
+// channel is closed once ctx is done, the stream stays subscribed until
+// UnsubscribeDepth.
+func (bnWs *baseWs) SubscribeDepthChan(ctx context.Context, pair CurrencyPair, size int, contractType string, opts ...common.StreamOption) (<-chan *Depth, error) {
+	return common.SubscribeChan(ctx, &bnWs.depthRoutes, common.RouteKey(pair, contractType), func() error {
+		return bnWs.subscribeDepth(pair, size, contractType)
+	}, opts...)
+}
+
+func (bnWs *baseWs) SubscribeTickerChan(ctx context.Context, pair CurrencyPair, contractType string, opts ...common.StreamOption) (<-chan *FutureTicker, error) {
+	return common.SubscribeChan(ctx, &bnWs.tickerRoutes, common.RouteKey(pair, contractType), func() error {
+		return bnWs.subscribeTicker(pair, contractType)
+	}, opts...)
+}
+
+func (bnWs *baseWs) SubscribeTradeChan(ctx context.Context, pair CurrencyPair, contractType string, opts ...common.StreamOption) (<-chan *Trade, error) {
+	return common.SubscribeChan(ctx, &bnWs.tradeRoutes, common.RouteKey(pair, contractType), func() error {
+		return bnWs.subscribeTrade(pair, contractType)
+	}, opts...)
+}
+
+func (bnWs *baseWs) SubscribeKlineChan(ctx context.Context, pair CurrencyPair, period int, contractType string, opts ...common.StreamOption) (<-chan *FutureKline, error) {
+	return common.SubscribeChan(ctx, &bnWs.klineRoutes, common.RouteKey(pair, contractType, period), func() error {
+		return bnWs.subscribeKline(pair, period, contractType)
+	}, opts...)
+}
+
+// SubscribeDepthFunc subscribes to the depth of the contract and hands it to
+// call, besides the depth callback if one is set. call replaces the one it
+// was subscribed with before and is dropped by UnsubscribeDepth.
+func (bnWs *baseWs) SubscribeDepthFunc(pair CurrencyPair, size int, contractType string, call func(depth *Depth)) error {
+	if call == nil {
+		return errors.New("please set depth callback func")
+	}
+	return common.SubscribeFunc(&bnWs.depthRoutes, common.RouteKey(pair, contractType), call, func() error {
+		return bnWs.subscribeDepth(pair, size, contractType)
+	})
+}
+
+func (bnWs *baseWs) SubscribeTickerFunc(pair CurrencyPair, contractType string, call func(ticker *FutureTicker)) error {
+	if call == nil {
+		return errors.New("please set ticker callback func")
+	}
+	return common.SubscribeFunc(&bnWs.tickerRoutes, common.RouteKey(pair, contractType), call, func() error {
+		return bnWs.subscribeTicker(pair, contractType)
+	})
+}
+
+func (bnWs *baseWs) SubscribeTradeFunc(pair CurrencyPair, contractType string, call func(trade *Trade, contract string)) error {
+	if call == nil {
+		return errors.New("please set trade callback func")
+	}
+	return common.SubscribeFunc(&bnWs.tradeFuncs, common.RouteKey(pair, contractType), func(t common.ContractTrade) {
+		call(t.Trade, t.Contract)
+	}, func() error {
+		return bnWs.subscribeTrade(pair, contractType)
+	})
+}
+
+func (bnWs *baseWs) SubscribeKlineFunc(pair CurrencyPair, period int, contractType string, call func(kline *FutureKline, period int, contract string)) error {
+	if call == nil {
+		return errors.New("please set kline callback func")
+	}
+	return common.SubscribeFunc(&bnWs.klineFuncs, common.RouteKey(pair, contractType, period), func(k common.ContractKline) {
+		call(k.Kline, k.Period, k.Contract)
+	}, func() error {
+		return bnWs.subscribeKline(pair, period, contractType)
+	})
+}
+
+// dispatch runs call through the dispatcher, a panic is recovered and
+// reported with the frame raw and data.
+func (bnWs *baseWs) dispatch(key string, raw []byte, data interface{}, call func()) {
+	bnWs.dispatcher.Dispatch(key, func() {
+		bnWs.panics.Run(bnWs.errs.Report, raw, data, call)
+	})
+}
+
+// The on funcs take the key the data was subscribed under, the contract
+// type asked for rather than the contract it resolved to.
+func (bnWs *baseWs) onDepth(key string, raw []byte, depth *Depth) {
+	bnWs.dispatch(key, raw, depth, func() {
+		if call := bnWs.depthCallback.Get(); call != nil {
+			call(depth)
+		}
+		bnWs.depthRoutes.Emit(key, depth)
+	})
+}
+
+func (bnWs *baseWs) onTicker(key string, raw []byte, ticker *FutureTicker) {
+	bnWs.dispatch(key, raw, ticker, func() {
+		if call := bnWs.tickerCallback.Get(); call != nil {
+			call(ticker)
+		}
+		bnWs.tickerRoutes.Emit(key, ticker)
+	})
+}
+
+func (bnWs *baseWs) onTrade(key string, raw []byte, trade *Trade, contract string) {
+	bnWs.dispatch(key, raw, trade, func() {
+		if call := bnWs.tradeCallback.Get(); call != nil {
+			call(trade, contract)
+		}
+		bnWs.tradeRoutes.Emit(key, trade)
+		bnWs.tradeFuncs.Emit(key, common.ContractTrade{Trade: trade, Contract: contract})
+	})
+}
+
+func (bnWs *baseWs) onKline(key string, raw []byte, kline *FutureKline, period int, contract string) {
+	bnWs.dispatch(key, raw, kline, func() {
+		if call := bnWs.klineCallback.Get(); call != nil {
+			call(kline, period, contract)
+		}
+		bnWs.klineRoutes.Emit(key, kline)
+		bnWs.klineFuncs.Emit(key, common.ContractKline{Kline: kline, Period: period, Contract: contract})
+	})
+}
+
+func (bnWs *baseWs) SubscribeDepth(pair CurrencyPair, size int, contractType string) error {
+	if bnWs.depthCallback.Get() == nil {
+		return errors.New("please set depth callback func")
+	}
+	return bnWs.subscribeDepth(pair, size, contractType)
+}
+
+func (bnWs *baseWs) subscribeDepth(pair CurrencyPair, size int, contractType string) error {
+	channelSize, err := common.DepthChannelSize("binance", size, futuresDepthSizes)
+	if err != nil {
+		return err
+	}
+	symbol, contract, err := bnWs.resolveContract(pair, contractType)
+	if err != nil {
+		return err
+	}
+	stream := fmt.Sprintf("%s@depth%d@100ms", symbol, channelSize)
+	key := common.RouteKey(pair, contractType)
+
+	handle := func(msg []byte) error {
+		rawDepth := struct {
+			Time int64           `json:"E"`
+			Bids [][]interface{} `json:"b"`
+			Asks [][]interface{} `json:"a"`
+		}{}
+		err := json.Unmarshal(msg, &rawDepth)
+		if err != nil {
+			return &common.ParseError{Raw: msg, Err: err}
+		}
+		depth := bnWs.parseDepthData(rawDepth.Bids, rawDepth.Asks)
+		depth.Pair = pair
+		depth.ContractType = contract
+		depth.UTime = time.Unix(0, rawDepth.Time*int64(time.Millisecond))
+		common.TruncateDepth(depth, size)
+		bnWs.onDepth(key, msg, depth)
+		return nil
+	}
+	return bnWs.streams.subscribe(stream, handle)
+}
+
+func (bnWs *baseWs) SubscribeTicker(pair CurrencyPair, contractType string) error {
+	if bnWs.tickerCallback.Get() == nil {
+		return errors.New("please set ticker callback func")
+	}
+	return bnWs.subscribeTicker(pair, contractType)
+}
+
+func (bnWs *baseWs) subscribeTicker(pair CurrencyPair, contractType string) error {
+	symbol, contract, err := bnWs.resolveContract(pair, contractType)
+	if err != nil {
+		return err
+	}
+	stream := fmt.Sprintf("%s@ticker", symbol)
+	key := common.RouteKey(pair, contractType)
+
+	handle := func(msg []byte) error {
+		datamap := make(map[string]interface{})
+		err := json.Unmarshal(msg, &datamap)
+		if err != nil {
+			return &common.ParseError{Raw: msg, Err: err}
+		}
+
+		msgType, isOk := datamap["e"].(string)
+		if !isOk {
+			return &common.ParseError{Raw: msg, Err: errors.New("no message type")}
+		}
+
+		switch msgType {
+		case "24hrTicker":
+			tick := bnWs.parseTickerData(datamap)
+			tick.Pair = pair
+			bnWs.onTicker(key, msg, &FutureTicker{Ticker: tick, ContractType: contract})
+			return nil
+		default:
+			return &common.UnknownChannelError{Channel: stream, Raw: msg}
+		}
+	}
+	return bnWs.streams.subscribe(stream, handle)
+}
+
+// SubscribeTrade listens on the aggTrade stream, the only public trade feed
+// of the derivatives endpoints.
+func (bnWs *baseWs) SubscribeTrade(pair CurrencyPair, contractType string) error {
+	if bnWs.tradeCallback.Get() == nil {
+		return errors.New("please set trade callback func")
+	}
+	return bnWs.subscribeTrade(pair, contractType)
+}
+
+func (bnWs *baseWs) subscribeTrade(pair CurrencyPair, contractType string) error {
+	symbol, contract, err := bnWs.resolveContract(pair, contractType)
+	if err != nil {
+		return err
+	}
+	stream := fmt.Sprintf("%s@aggTrade", symbol)
+	key := common.RouteKey(pair, contractType)
+
+	handle := func(msg []byte) error {
+		datamap := make(map[string]interface{})
+		err := json.Unmarshal(msg, &datamap)
+		if err != nil {
+			return &common.ParseError{Raw: msg, Err: err}
+		}
+
+		msgType, isOk := datamap["e"].(string)
+		if !isOk {
+			return &common.ParseError{Raw: msg, Err: errors.New("no message type")}
+		}
+
+		switch msgType {
+		case "aggTrade":
+			side := BUY
+			if isMaker, _ := datamap["m"].(bool); isMaker {
+				side = SELL
+			}
+			aggTrade := &AggTrade{
+				Trade: Trade{
+					Tid:    int64(ToUint64(datamap["a"])),
+					Type:   side,
+					Amount: ToFloat64(datamap["q"]),
+					Price:  ToFloat64(datamap["p"]),
+					Date:   int64(ToUint64(datamap["E"])),
+				},
+				FirstBreakdownTradeID: int64(ToUint64(datamap["f"])),
+				LastBreakdownTradeID:  int64(ToUint64(datamap["l"])),
+				TradeTime:             int64(ToUint64(datamap["T"])),
+			}
+			aggTrade.Pair = pair
+			bnWs.onTrade(key, msg, &aggTrade.Trade, contract)
+			return nil
+		default:
+			return &common.UnknownChannelError{Channel: stream, Raw: msg}
+		}
+	}
+	return bnWs.streams.subscribe(stream, handle)
+}
+
+func (bnWs *baseWs) SubscribeKline(pair CurrencyPair, period int, contractType string) error {
+	if bnWs.klineCallback.Get() == nil {
+		return errors.New("place set kline callback func")
+	}
+	return bnWs.subscribeKline(pair, period, contractType)
+}
+
+func (bnWs *baseWs) subscribeKline(pair CurrencyPair, period int, contractType string) error {
+	periodS, isOk := _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	if !isOk {
+		return fmt.Errorf("unsupported kline period %d in binance", period)
+	}
+	symbol, contract, err := bnWs.resolveContract(pair, contractType)
+	if err != nil {
+		return err
+	}
+	stream := fmt.Sprintf("%s@kline_%s", symbol, periodS)
+	key := common.RouteKey(pair, contractType, period)
+
+	handle := func(msg []byte) error {
+		datamap := make(map[string]interface{})
+		err := json.Unmarshal(msg, &datamap)
+		if err != nil {
+			return &common.ParseError{Raw: msg, Err: err}
+		}
+
+		msgType, isOk := datamap["e"].(string)
+		if !isOk {
+			return &common.ParseError{Raw: msg, Err: errors.New("no message type")}
+		}
+
+		switch msgType {
+		case "kline":
+			k := datamap["k"].(map[string]interface{})
+			period := _INERNAL_KLINE_PERIOD_REVERTER[k["i"].(string)]
+			kline := bnWs.parseKlineData(k)
+			kline.Pair = pair
+			bnWs.onKline(key, msg, kline, period, contract)
+			return nil
+		default:
+			return &common.UnknownChannelError{Channel: stream, Raw: msg}
+		}
+	}
+	return bnWs.streams.subscribe(stream, handle)
+}
+
+// UnsubscribeDepth stops the depth of the contract, whichever size it was
+// subscribed with.
+func (bnWs *baseWs) UnsubscribeDepth(pair CurrencyPair, contractType string) error {
+	bnWs.depthRoutes.Unhandle(common.RouteKey(pair, contractType))
+	symbol, _, err := bnWs.resolveContract(pair, contractType)
+	if err != nil {
+		return err
+	}
+	var streams []string
+	for _, size := range futuresDepthSizes {
+		streams = append(streams, fmt.Sprintf("%s@depth%d@100ms", symbol, size))
+	}
+	return bnWs.streams.unsubscribe(streams...)
+}
+
+func (bnWs *baseWs) UnsubscribeTicker(pair CurrencyPair, contractType string) error {
+	bnWs.tickerRoutes.Unhandle(common.RouteKey(pair, contractType))
+	symbol, _, err := bnWs.resolveContract(pair, contractType)
+	if err != nil {
+		return err
+	}
+	return bnWs.streams.unsubscribe(fmt.Sprintf("%s@ticker", symbol))
+}
+
+func (bnWs *baseWs) UnsubscribeTrade(pair CurrencyPair, contractType string) error {
+	bnWs.tradeFuncs.Unhandle(common.RouteKey(pair, contractType))
+	symbol, _, err := bnWs.resolveContract(pair, contractType)
+	if err != nil {
+		return err
+	}
+	return bnWs.streams.unsubscribe(fmt.Sprintf("%s@aggTrade", symbol))
+}
+
+func (bnWs *baseWs) UnsubscribeKline(pair CurrencyPair, period int, contractType string) error {
+	bnWs.klineFuncs.Unhandle(common.RouteKey(pair, contractType, period))
+	periodS, isOk := _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	if !isOk {
+		return fmt.Errorf("unsupported kline period %d in binance", period)
+	}
+	symbol, _, err := bnWs.resolveContract(pair, contractType)
+	if err != nil {
+		return err
+	}
+	return bnWs.streams.unsubscribe(fmt.Sprintf("%s@kline_%s", symbol, periodS))
+}
+
+func (bnWs *baseWs) parseTickerData(tickmap map[string]interface{}) *Ticker {
+	t := new(Ticker)
+	t.Date = ToUint64(tickmap["E"])
+	t.Last = ToFloat64(tickmap["c"])
+	t.Vol = ToFloat64(tickmap["v"])
+	t.Low = ToFloat64(tickmap["l"])
+	t.High = ToFloat64(tickmap["h"])
+	return t
+}
+
+func (bnWs *baseWs) parseDepthData(bids, asks [][]interface{}) *Depth {
+	depth := new(Depth)
+	for _, v := range bids {
+		depth.BidList = append(depth.BidList, DepthRecord{Price: ToFloat64(v[0]), Amount: ToFloat64(v[1])})
+	}
+
+	for _, v := range asks {
+		depth.AskList = append(depth.AskList, DepthRecord{Price: ToFloat64(v[0]), Amount: ToFloat64(v[1])})
+	}
+	return depth
+}
+
+func (bnWs *baseWs) parseKlineData(k map[string]interface{}) *FutureKline {
+	kline := &FutureKline{
+		Kline: &Kline{
+			Timestamp: int64(ToInt(k["t"])) / 1000,
+			Open:      ToFloat64(k["o"]),
+			Close:     ToFloat64(k["c"]),
+			High:      ToFloat64(k["h"]),
+			Low:       ToFloat64(k["l"]),
+			Vol:       ToFloat64(k["v"]),
+		},
+		Vol2: ToFloat64(k["v"]),
+	}
+	if bnWs.coinMargined {
+		kline.Vol2 = ToFloat64(k["q"])
+	}
+	return kline
+}
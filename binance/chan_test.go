@@ -0,0 +1,195 @@
+package binance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/common"
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/nntaoli-project/goex"
+)
+
+func TestSpotWs_TickerChan(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	bnWs := NewSpotWs()
+	bnWs.SetCombinedBaseURL(srv.URL + "/stream?streams=")
+	bnWs.streams.interval = 0
+	defer bnWs.Close()
+
+	// no ticker callback is needed to read the channel
+	ctx, cancel := context.WithCancel(context.Background())
+	btc, err := bnWs.SubscribeTickerChan(ctx, goex.BTC_USDT, common.WithOverflow(common.CoalesceLatest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eth, err := bnWs.SubscribeTickerChan(context.Background(), goex.ETH_USDT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wstest.Eventually(time.Second, func() bool { return srv.Connected() == 1 }) {
+		t.Fatal("not connected")
+	}
+
+	srv.Broadcast(`{"stream":"btcusdt@ticker","data":{"e":"24hrTicker","c":"1"}}`)
+	srv.Broadcast(`{"stream":"btcusdt@ticker","data":{"e":"24hrTicker","c":"2"}}`)
+	srv.Broadcast(`{"stream":"ethusdt@ticker","data":{"e":"24hrTicker","c":"100"}}`)
+
+	select {
+	case ticker := <-eth:
+		if ticker.Pair != goex.ETH_USDT || ticker.Last != 100 {
+			t.Fatalf("eth got %+v", ticker)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no eth ticker")
+	}
+	// the btc tickers came first and the channel coalesces, only the latest
+	// is left
+	if len(btc) != 1 {
+		t.Fatalf("%d btc tickers buffered, want 1", len(btc))
+	}
+	if ticker := <-btc; ticker.Pair != goex.BTC_USDT || ticker.Last != 2 {
+		t.Fatalf("btc got %+v, want the last one", ticker)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-btc:
+		if ok {
+			t.Fatal("btc channel open after the cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("btc channel not closed")
+	}
+}
+
+func TestSpotWs_TickerFunc(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	bnWs := NewSpotWs()
+	bnWs.SetCombinedBaseURL(srv.URL + "/stream?streams=")
+	bnWs.streams.interval = 0
+	defer bnWs.Close()
+
+	if err := bnWs.SubscribeTickerFunc(goex.BTC_USDT, nil); err == nil {
+		t.Fatal("subscribed without a func")
+	}
+	btc := make(chan *goex.Ticker, 16)
+	eth := make(chan *goex.Ticker, 16)
+	if err := bnWs.SubscribeTickerFunc(goex.BTC_USDT, func(ticker *goex.Ticker) { btc <- ticker }); err != nil {
+		t.Fatal(err)
+	}
+	if err := bnWs.SubscribeTickerFunc(goex.ETH_USDT, func(ticker *goex.Ticker) { eth <- ticker }); err != nil {
+		t.Fatal(err)
+	}
+	if !wstest.Eventually(time.Second, func() bool { return srv.Connected() == 1 }) {
+		t.Fatal("not connected")
+	}
+
+	// each func only gets the pair it was subscribed with
+	srv.Broadcast(`{"stream":"btcusdt@ticker","data":{"e":"24hrTicker","c":"1"}}`)
+	srv.Broadcast(`{"stream":"ethusdt@ticker","data":{"e":"24hrTicker","c":"100"}}`)
+	select {
+	case ticker := <-eth:
+		if ticker.Pair != goex.ETH_USDT || ticker.Last != 100 {
+			t.Fatalf("eth got %+v", ticker)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no eth ticker")
+	}
+	if len(btc) != 1 || len(eth) != 0 {
+		t.Fatalf("got %d btc and %d more eth tickers, want 1 btc", len(btc), len(eth))
+	}
+	if ticker := <-btc; ticker.Pair != goex.BTC_USDT || ticker.Last != 1 {
+		t.Fatalf("btc got %+v", ticker)
+	}
+
+	// the func is dropped with the subscription
+	if err := bnWs.UnsubscribeTicker(goex.BTC_USDT); err != nil {
+		t.Fatal(err)
+	}
+	srv.Broadcast(`{"stream":"btcusdt@ticker","data":{"e":"24hrTicker","c":"2"}}`)
+	srv.Broadcast(`{"stream":"ethusdt@ticker","data":{"e":"24hrTicker","c":"101"}}`)
+	select {
+	case <-eth:
+	case <-time.After(time.Second):
+		t.Fatal("no eth ticker")
+	}
+	if len(btc) != 0 {
+		t.Fatal("btc ticker after the unsubscribe")
+	}
+}
+
+// TestSpotWs_TradeSide checks that spot reports a buyer maker trade as a
+// sell like the derivatives do, and takes a trade without the flag for a buy.
+func TestSpotWs_TradeSide(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	bnWs := NewSpotWs()
+	bnWs.SetCombinedBaseURL(srv.URL + "/stream?streams=")
+	bnWs.streams.interval = 0
+	defer bnWs.Close()
+
+	trades := make(chan *goex.Trade, 4)
+	if err := bnWs.SubscribeTradeFunc(goex.BTC_USDT, func(trade *goex.Trade) { trades <- trade }); err != nil {
+		t.Fatal(err)
+	}
+	if !wstest.Eventually(time.Second, func() bool { return srv.Connected() == 1 }) {
+		t.Fatal("not connected")
+	}
+
+	srv.Broadcast(`{"stream":"btcusdt@trade","data":{"e":"trade","t":1,"m":true}}`)
+	srv.Broadcast(`{"stream":"btcusdt@trade","data":{"e":"trade","t":2,"m":false}}`)
+	srv.Broadcast(`{"stream":"btcusdt@trade","data":{"e":"trade","t":3}}`)
+	for _, want := range []goex.TradeSide{goex.SELL, goex.BUY, goex.BUY} {
+		select {
+		case trade := <-trades:
+			if trade.Type != want {
+				t.Errorf("trade %d got %v, want %v", trade.Tid, trade.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no trade")
+		}
+	}
+}
+
+// TestFuturesWs_TradeFunc checks that the trade func is told the dated
+// contract the quarter resolved to, like the trade callback.
+func TestFuturesWs_TradeFunc(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	bnWs := NewFuturesWs()
+	bnWs.now = func() time.Time { return time.Date(2021, 12, 29, 10, 0, 0, 0, time.UTC) }
+	bnWs.SetCombinedBaseURL(srv.URL + "/stream?streams=")
+	defer bnWs.Close()
+
+	type trade struct {
+		price    float64
+		contract string
+	}
+	trades := make(chan trade, 1)
+	err := bnWs.SubscribeTradeFunc(goex.BTC_USD, goex.QUARTER_CONTRACT, func(t *goex.Trade, contract string) {
+		trades <- trade{t.Price, contract}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wstest.Eventually(time.Second, func() bool { return srv.Connected() == 1 }) {
+		t.Fatal("not connected")
+	}
+
+	srv.Broadcast(`{"stream":"btcusd_211231@aggTrade","data":{"e":"aggTrade","p":"50000","q":"1"}}`)
+	select {
+	case got := <-trades:
+		if got != (trade{50000, "BTCUSD_211231"}) {
+			t.Fatalf("got %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no trade")
+	}
+}
@@ -0,0 +1,79 @@
+package binance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goex-top/goexws/common"
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/nntaoli-project/goex"
+)
+
+func TestSpotWs_Close(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	bnWs := NewSpotWs()
+	bnWs.SetCombinedBaseURL(srv.URL + "/stream?streams=")
+	bnWs.streams.interval = 0
+	calls := make(chan struct{}, 16)
+	bnWs.TickerCallback(func(ticker *goex.Ticker) { calls <- struct{}{} })
+	var trades <-chan *goex.Trade
+	wstest.CheckTeardown(t, srv, wstest.Teardown{
+		Subscribe: func() (err error) {
+			if err = bnWs.SubscribeTicker(goex.BTC_USDT); err != nil {
+				return err
+			}
+			trades, err = bnWs.SubscribeTradeChan(context.Background(), goex.BTC_USDT)
+			return err
+		},
+		Conns:  1,
+		Frames: []string{`{"stream":"btcusdt@ticker","data":{"e":"24hrTicker","c":"1"}}`},
+		Calls:  calls,
+		Close:  bnWs.Close,
+		Closed: func() error {
+			return wstest.Closed(trades, bnWs.SubscribeTicker(goex.ETH_USDT), common.ErrClosed)
+		},
+	})
+}
+
+// TestFuturesWs_Close runs the futures and swap adapters with a stream per
+// connection.
+func TestFuturesWs_Close(t *testing.T) {
+	for name, test := range map[string]struct {
+		ws     *baseWs
+		stream string
+		pair   goex.CurrencyPair
+	}{
+		"Futures": {NewFuturesWs().baseWs, "btcusd_perp@ticker", goex.BTC_USD},
+		"Swap":    {NewSwapWs().baseWs, "btcusdt@ticker", goex.BTC_USDT},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+			defer srv.Close()
+
+			bnWs := test.ws
+			bnWs.SetCombinedBaseURL(srv.URL + "/stream?streams=")
+			bnWs.SetMaxStreamsPerConn(1)
+			calls := make(chan struct{}, 16)
+			bnWs.TickerCallback(func(ticker *goex.FutureTicker) { calls <- struct{}{} })
+			var trades <-chan *goex.Trade
+			wstest.CheckTeardown(t, srv, wstest.Teardown{
+				Subscribe: func() (err error) {
+					if err = bnWs.SubscribeTicker(test.pair, goex.SWAP_CONTRACT); err != nil {
+						return err
+					}
+					trades, err = bnWs.SubscribeTradeChan(context.Background(), test.pair, goex.SWAP_CONTRACT)
+					return err
+				},
+				Conns:  2,
+				Frames: []string{`{"stream":"` + test.stream + `","data":{"e":"24hrTicker","c":"1"}}`},
+				Calls:  calls,
+				Close:  bnWs.Close,
+				Closed: func() error {
+					return wstest.Closed(trades, bnWs.SubscribeTicker(goex.ETH_USDT, goex.SWAP_CONTRACT), common.ErrClosed)
+				},
+			})
+		})
+	}
+}
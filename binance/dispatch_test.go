@@ -0,0 +1,117 @@
+package binance
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/common"
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/nntaoli-project/goex"
+)
+
+// TestSpotWs_Dispatcher runs two streams on their own connection and checks
+// how much the ticker callback overlaps under each dispatcher, while the
+// callback is set over and over from another goroutine.
+func TestSpotWs_Dispatcher(t *testing.T) {
+	tests := []struct {
+		name       string
+		dispatcher common.Dispatcher
+		// maxPair and maxAll are the most calls allowed at once for a pair
+		// and overall
+		maxPair, maxAll int32
+	}{
+		{"SerialGlobal", common.SerialGlobal(), 1, 1},
+		{"SerialPerSymbol", common.SerialPerSymbol(4), 1, 2},
+		{"WorkerPool", common.WorkerPool(4), 4, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+			defer srv.Close()
+
+			bnWs := NewSpotWs()
+			bnWs.SetCombinedBaseURL(srv.URL + "/stream?streams=")
+			bnWs.SetDispatcher(test.dispatcher)
+			bnWs.streams.interval = 0
+			bnWs.streams.maxStreams = 1
+			defer bnWs.Close()
+
+			var (
+				lock         sync.Mutex
+				inPair       = map[string]int32{}
+				maxPair      int32
+				inAll        int32
+				maxAll       int32
+				calls        int32
+				overlapCheck = func(ticker *goex.Ticker) {
+					pair := ticker.Pair.String()
+					lock.Lock()
+					inPair[pair]++
+					if inPair[pair] > maxPair {
+						maxPair = inPair[pair]
+					}
+					lock.Unlock()
+					all := atomic.AddInt32(&inAll, 1)
+					for {
+						max := atomic.LoadInt32(&maxAll)
+						if all <= max || atomic.CompareAndSwapInt32(&maxAll, max, all) {
+							break
+						}
+					}
+
+					time.Sleep(time.Millisecond)
+
+					atomic.AddInt32(&inAll, -1)
+					lock.Lock()
+					inPair[pair]--
+					lock.Unlock()
+					atomic.AddInt32(&calls, 1)
+				}
+			)
+			bnWs.TickerCallback(overlapCheck)
+			if err := bnWs.SubscribeTicker(goex.BTC_USDT); err != nil {
+				t.Fatal(err)
+			}
+			if err := bnWs.SubscribeTicker(goex.ETH_USDT); err != nil {
+				t.Fatal(err)
+			}
+			if !wstest.Eventually(time.Second, func() bool { return srv.Connected() == 2 }) {
+				t.Fatalf("%d connections, want one per stream", srv.Connected())
+			}
+
+			done := make(chan struct{})
+			go func() {
+				for {
+					select {
+					case <-done:
+						return
+					case <-time.After(100 * time.Microsecond):
+						bnWs.TickerCallback(overlapCheck)
+					}
+				}
+			}()
+
+			// every frame reaches both connections
+			const n = 20
+			for i := 0; i < n; i++ {
+				srv.Broadcast(fmt.Sprintf(`{"stream":"btcusdt@ticker","data":{"e":"24hrTicker","c":"%d"}}`, i))
+				srv.Broadcast(fmt.Sprintf(`{"stream":"ethusdt@ticker","data":{"e":"24hrTicker","c":"%d"}}`, i))
+			}
+			ok := wstest.Eventually(5*time.Second, func() bool { return atomic.LoadInt32(&calls) == 4*n })
+			close(done)
+			if !ok {
+				t.Fatalf("%d calls, want %d", atomic.LoadInt32(&calls), 4*n)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if maxPair > test.maxPair || maxAll > test.maxAll {
+				t.Errorf("up to %d calls at once for a pair and %d overall, want at most %d and %d",
+					maxPair, maxAll, test.maxPair, test.maxAll)
+			}
+		})
+	}
+}
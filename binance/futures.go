@@ -1 +1,77 @@
 package binance
+
+import (
+	"fmt"
+	"github.com/goex-top/goexws/common"
+	. "github.com/nntaoli-project/goex"
+	"strings"
+	"time"
+)
+
+// FuturesWs streams the COIN-M delivery futures markets from
+// dstream.binance.com. goex contract types are resolved to Binance's dated
+// symbols (e.g. BTCUSD_211231) at subscribe time and that symbol is what the
+// callbacks report as the contract. COIN-M lists no weekly contracts, so
+// THIS_WEEK and NEXT_WEEK are rejected.
+type FuturesWs struct {
+	*baseWs
+	now func() time.Time
+}
+
+func NewFuturesWs() *FuturesWs {
+	futuresWs := &FuturesWs{baseWs: &baseWs{coinMargined: true}, now: time.Now}
+	futuresWs.baseURL = "wss://dstream.binance.com/ws"
+	futuresWs.combinedBaseURL = "wss://dstream.binance.com/stream?streams="
+	futuresWs.resolveContract = futuresWs.adaptContract
+	futuresWs.streams = newStreamMux(futuresMaxStreamsPerConn, futuresWs.dialStreams)
+	futuresWs.streams.report = futuresWs.errs.Report
+	futuresWs.dispatcher = common.SerialPerSymbol(0)
+	return futuresWs
+}
+
+func (futuresWs *FuturesWs) adaptContract(pair CurrencyPair, contractType string) (string, string, error) {
+	var (
+		now    = futuresWs.now().UTC()
+		suffix string
+	)
+
+	switch contractType {
+	case SWAP_CONTRACT:
+		suffix = "PERP"
+	case QUARTER_CONTRACT:
+		suffix = quarterlyDelivery(now).Format("060102")
+	case BI_QUARTER_CONTRACT:
+		suffix = quarterlyDelivery(quarterlyDelivery(now)).Format("060102")
+	default:
+		return "", "", fmt.Errorf("unsupported contract type %s in binance futures", contractType)
+	}
+
+	contract := fmt.Sprintf("%sUSD_%s", strings.ToUpper(pair.CurrencyA.Symbol), suffix)
+	return strings.ToLower(contract), contract, nil
+}
+
+// deliveryHour is the UTC hour at which Binance settles delivery contracts.
+const deliveryHour = 8
+
+// quarterlyDelivery returns the first quarterly settlement (last Friday of
+// March, June, September or December) strictly after t.
+func quarterlyDelivery(t time.Time) time.Time {
+	month := t.Month() + (3-t.Month()%3)%3
+	year := t.Year()
+	for {
+		d := lastFriday(year, month)
+		if d.After(t) {
+			return d
+		}
+		month += 3
+		if month > 12 {
+			month -= 12
+			year++
+		}
+	}
+}
+
+func lastFriday(year int, month time.Month) time.Time {
+	d := time.Date(year, month+1, 1, deliveryHour, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	return d.AddDate(0, 0, -((int(d.Weekday()) - int(time.Friday) + 7) % 7))
+}
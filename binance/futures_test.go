@@ -1 +1,62 @@
 package binance
+
+import (
+	"github.com/nntaoli-project/goex"
+	"strings"
+	"testing"
+	"time"
+)
+
+var futuresWs = NewFuturesWs()
+
+func init() {
+	futuresWs.ProxyUrl("socks5://127.0.0.1:1080")
+	futuresWs.SetCallbacks(printfFutureTicker, printfDepth, printfFutureTrade, printfFutureKline)
+}
+
+func TestFuturesWs_adaptContract(t *testing.T) {
+	ws := NewFuturesWs()
+	ws.now = func() time.Time { return time.Date(2021, 12, 29, 10, 0, 0, 0, time.UTC) }
+
+	for contractType, want := range map[string]string{
+		goex.SWAP_CONTRACT:       "BTCUSD_PERP",
+		goex.QUARTER_CONTRACT:    "BTCUSD_211231",
+		goex.BI_QUARTER_CONTRACT: "BTCUSD_220325",
+	} {
+		symbol, contract, err := ws.adaptContract(goex.BTC_USD, contractType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contract != want || symbol != strings.ToLower(want) {
+			t.Errorf("%s: got %s %s, want %s", contractType, symbol, contract, want)
+		}
+	}
+
+	// COIN-M has no weekly contracts
+	for _, contractType := range []string{goex.THIS_WEEK_CONTRACT, goex.NEXT_WEEK_CONTRACT} {
+		if _, _, err := ws.adaptContract(goex.BTC_USD, contractType); err == nil {
+			t.Errorf("%s: resolved a contract binance does not list", contractType)
+		}
+	}
+
+	// after the December settlement the quarter rolls over to March
+	ws.now = func() time.Time { return time.Date(2021, 12, 31, 8, 0, 0, 0, time.UTC) }
+	if _, contract, _ := ws.adaptContract(goex.BTC_USD, goex.QUARTER_CONTRACT); contract != "BTCUSD_220325" {
+		t.Errorf("quarter after settlement: got %s", contract)
+	}
+}
+
+func TestFuturesWs_SubscribeDepth(t *testing.T) {
+	futuresWs.SubscribeDepth(goex.BTC_USD, 5, goex.QUARTER_CONTRACT)
+	time.Sleep(time.Second * 5)
+}
+
+func TestFuturesWs_SubscribeTrade(t *testing.T) {
+	futuresWs.SubscribeTrade(goex.BTC_USD, goex.QUARTER_CONTRACT)
+	time.Sleep(time.Second * 5)
+}
+
+func TestFuturesWs_SubscribeKline(t *testing.T) {
+	futuresWs.SubscribeKline(goex.BTC_USD, goex.KLINE_PERIOD_1MIN, goex.QUARTER_CONTRACT)
+	time.Sleep(time.Second * 5)
+}
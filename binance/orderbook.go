@@ -0,0 +1,243 @@
+package binance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	. "github.com/nntaoli-project/goex"
+)
+
+// maxBufferedDiffs bounds the events kept while waiting for a snapshot, the
+// oldest ones are useless once a newer snapshot arrives anyway.
+const maxBufferedDiffs = 1000
+
+// snapshotBackoff is how long a failed snapshot is left alone before the
+// next try, it doubles with every failure in a row up to maxSnapshotBackoff.
+// The depth endpoint is heavy on the request weight, hammering it gets the
+// IP banned.
+const (
+	snapshotBackoff    = time.Second
+	maxSnapshotBackoff = time.Minute
+)
+
+// snapshotTimeout bounds a snapshot request of the default client.
+const snapshotTimeout = 10 * time.Second
+
+type depthSnapshot struct {
+	LastUpdateID int64           `json:"lastUpdateId"`
+	Bids         [][]interface{} `json:"bids"`
+	Asks         [][]interface{} `json:"asks"`
+}
+
+type snapshotResult struct {
+	snapshot *depthSnapshot
+	err      error
+}
+
+// orderBook keeps a local book from the @depth diff stream and a REST
+// snapshot, following Binance's "how to manage a local order book" rules:
+// diffs are buffered until a snapshot arrives, diffs older than its
+// lastUpdateId are dropped, the first applied diff must straddle
+// lastUpdateId+1 and every following one must start right after the previous.
+// Any gap throws the book away and starts over with a fresh snapshot.
+//
+// Update is meant to be called from a single goroutine, the snapshot is
+// fetched on another one so the stream keeps being read meanwhile. A failed
+// fetch is retried with the next diff once its backoff ran out.
+type orderBook struct {
+	pair  CurrencyPair
+	size  int
+	fetch func() (*depthSnapshot, error)
+	spawn func(func())
+	now   func() time.Time
+
+	snapshots    chan snapshotResult
+	fetching     bool
+	failures     int
+	retryAt      time.Time
+	buffer       []*DiffDepth
+	synced       bool
+	first        bool
+	lastUpdateID int64
+	bids         map[float64]float64
+	asks         map[float64]float64
+}
+
+func newOrderBook(pair CurrencyPair, size int, fetch func() (*depthSnapshot, error)) *orderBook {
+	return &orderBook{
+		pair:      pair,
+		size:      size,
+		fetch:     fetch,
+		spawn:     func(f func()) { go f() },
+		now:       time.Now,
+		snapshots: make(chan snapshotResult, 1),
+	}
+}
+
+// Update feeds one diff into the book and returns the full book once it is in
+// sync, or nil while a snapshot is still pending. A sequence gap is returned
+// as an error, the book then resyncs by itself.
+func (ob *orderBook) Update(diff *DiffDepth) (*Depth, error) {
+	if ob.synced {
+		if err := ob.apply(diff); err != nil {
+			ob.resync(diff)
+			return nil, err
+		}
+		return ob.depth(diff.UTime), nil
+	}
+
+	ob.buffer = append(ob.buffer, diff)
+	if len(ob.buffer) > maxBufferedDiffs {
+		ob.buffer = ob.buffer[len(ob.buffer)-maxBufferedDiffs:]
+	}
+	ob.requestSnapshot()
+
+	select {
+	case res := <-ob.snapshots:
+		ob.fetching = false
+		if res.err != nil {
+			ob.backoff()
+			return nil, res.err
+		}
+		ob.failures = 0
+		return ob.load(res.snapshot)
+	default:
+		return nil, nil
+	}
+}
+
+func (ob *orderBook) requestSnapshot() {
+	if ob.fetching || ob.now().Before(ob.retryAt) {
+		return
+	}
+	ob.fetching = true
+	ob.spawn(func() {
+		snapshot, err := ob.fetch()
+		ob.snapshots <- snapshotResult{snapshot: snapshot, err: err}
+	})
+}
+
+// backoff holds the next snapshot back after a failed one.
+func (ob *orderBook) backoff() {
+	wait := maxSnapshotBackoff
+	if ob.failures < 6 {
+		wait = snapshotBackoff << ob.failures
+	}
+	ob.failures++
+	ob.retryAt = ob.now().Add(wait)
+}
+
+func (ob *orderBook) load(snapshot *depthSnapshot) (*Depth, error) {
+	ob.bids = make(map[float64]float64, len(snapshot.Bids))
+	ob.asks = make(map[float64]float64, len(snapshot.Asks))
+	for _, v := range snapshot.Bids {
+		ob.bids[ToFloat64(v[0])] = ToFloat64(v[1])
+	}
+	for _, v := range snapshot.Asks {
+		ob.asks[ToFloat64(v[0])] = ToFloat64(v[1])
+	}
+	ob.lastUpdateID = snapshot.LastUpdateID
+	ob.synced = true
+	ob.first = true
+
+	buffer := ob.buffer
+	ob.buffer = nil
+	utime := time.Now()
+	for _, diff := range buffer {
+		if err := ob.apply(diff); err != nil {
+			ob.resync(buffer[len(buffer)-1])
+			return nil, err
+		}
+		utime = diff.UTime
+	}
+	return ob.depth(utime), nil
+}
+
+func (ob *orderBook) apply(diff *DiffDepth) error {
+	if diff.UpdateID <= ob.lastUpdateID {
+		return nil
+	}
+	if ob.first && diff.FirstUpdateID > ob.lastUpdateID+1 ||
+		!ob.first && diff.FirstUpdateID != ob.lastUpdateID+1 {
+		return fmt.Errorf("binance %s depth out of sync: expected update %d, got %d-%d",
+			ob.pair.ToSymbol(""), ob.lastUpdateID+1, diff.FirstUpdateID, diff.UpdateID)
+	}
+
+	for _, r := range diff.BidList {
+		ob.set(ob.bids, r)
+	}
+	for _, r := range diff.AskList {
+		ob.set(ob.asks, r)
+	}
+	ob.lastUpdateID = diff.UpdateID
+	ob.first = false
+	return nil
+}
+
+func (ob *orderBook) set(side map[float64]float64, r DepthRecord) {
+	if r.Amount == 0 {
+		delete(side, r.Price)
+		return
+	}
+	side[r.Price] = r.Amount
+}
+
+// resync drops the book and starts over from a new snapshot, keeping diff as
+// the first buffered event.
+func (ob *orderBook) resync(diff *DiffDepth) {
+	ob.synced = false
+	ob.bids, ob.asks = nil, nil
+	ob.buffer = append(ob.buffer[:0], diff)
+	ob.requestSnapshot()
+}
+
+// depth returns the book with bids from the best (highest) price down and
+// asks from the best (lowest) price up, like the partial depth streams,
+// truncated to size levels unless size is 0.
+func (ob *orderBook) depth(utime time.Time) *Depth {
+	depth := &Depth{Pair: ob.pair, UTime: utime}
+	for price, amount := range ob.bids {
+		depth.BidList = append(depth.BidList, DepthRecord{Price: price, Amount: amount})
+	}
+	for price, amount := range ob.asks {
+		depth.AskList = append(depth.AskList, DepthRecord{Price: price, Amount: amount})
+	}
+	sort.Sort(sort.Reverse(depth.BidList))
+	sort.Sort(depth.AskList)
+
+	if ob.size > 0 && len(depth.BidList) > ob.size {
+		depth.BidList = depth.BidList[:ob.size]
+	}
+	if ob.size > 0 && len(depth.AskList) > ob.size {
+		depth.AskList = depth.AskList[:ob.size]
+	}
+	return depth
+}
+
+func (bnWs *SpotWs) fetchDepthSnapshot(pair CurrencyPair) (*depthSnapshot, error) {
+	url := fmt.Sprintf("%s/api/v3/depth?symbol=%s&limit=1000", bnWs.restBaseURL, pair.ToSymbol(""))
+	req, err := http.NewRequestWithContext(bnWs.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := bnWs.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get depth snapshot: %s %s", resp.Status, string(body))
+	}
+
+	snapshot := new(depthSnapshot)
+	err = json.Unmarshal(body, snapshot)
+	return snapshot, err
+}
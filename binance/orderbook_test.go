@@ -0,0 +1,174 @@
+package binance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nntaoli-project/goex"
+)
+
+func diff(first, last int64, bids, asks []goex.DepthRecord) *DiffDepth {
+	d := &DiffDepth{UpdateID: last, FirstUpdateID: first}
+	d.BidList = bids
+	d.AskList = asks
+	return d
+}
+
+func TestOrderBook_Update(t *testing.T) {
+	var (
+		snapshots = []string{
+			`{"lastUpdateId":100,"bids":[["10.0","1"],["9.0","2"],["8.0","3"]],"asks":[["11.0","1"],["12.0","2"]]}`,
+			`{"lastUpdateId":200,"bids":[["10.0","5"]],"asks":[["11.0","5"]]}`,
+		}
+		fetches int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/depth" || r.URL.Query().Get("symbol") != "BTCUSDT" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(snapshots[fetches]))
+		fetches++
+	}))
+	defer srv.Close()
+
+	ws := NewSpotWs()
+	ws.SetRestBaseURL(srv.URL)
+	ws.SetHttpClient(srv.Client())
+
+	book := newOrderBook(goex.BTC_USDT, 2, func() (*depthSnapshot, error) {
+		return ws.fetchDepthSnapshot(goex.BTC_USDT)
+	})
+	var pending func()
+	book.spawn = func(f func()) { pending = f }
+
+	// buffered while the snapshot is in flight, 95-99 is older than it
+	dep, err := book.Update(diff(95, 99, []goex.DepthRecord{{Price: 10, Amount: 9}}, nil))
+	if dep != nil || err != nil {
+		t.Fatalf("expected no book before the snapshot, got %v %v", dep, err)
+	}
+	pending()
+
+	dep, err = book.Update(diff(100, 102, []goex.DepthRecord{{Price: 9, Amount: 0}}, []goex.DepthRecord{{Price: 10.5, Amount: 4}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dep.BidList) != 2 || dep.BidList[0] != (goex.DepthRecord{Price: 10, Amount: 1}) || dep.BidList[1] != (goex.DepthRecord{Price: 8, Amount: 3}) {
+		t.Errorf("bids %v", dep.BidList)
+	}
+	if len(dep.AskList) != 2 || dep.AskList[0] != (goex.DepthRecord{Price: 10.5, Amount: 4}) || dep.AskList[1] != (goex.DepthRecord{Price: 11, Amount: 1}) {
+		t.Errorf("asks %v", dep.AskList)
+	}
+
+	dep, err = book.Update(diff(103, 103, nil, []goex.DepthRecord{{Price: 10.5, Amount: 0}}))
+	if err != nil || dep.AskList[0].Price != 11 {
+		t.Errorf("sequential update: %v %v", dep, err)
+	}
+
+	// 104 went missing
+	dep, err = book.Update(diff(105, 201, nil, nil))
+	if err == nil || dep != nil {
+		t.Fatalf("expected a gap error, got %v %v", dep, err)
+	}
+	if pending == nil {
+		t.Fatal("expected a new snapshot request")
+	}
+	pending()
+
+	dep, err = book.Update(diff(202, 202, []goex.DepthRecord{{Price: 9.5, Amount: 1}}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 || len(dep.BidList) != 2 || dep.BidList[0].Amount != 5 || dep.BidList[1].Price != 9.5 {
+		t.Errorf("after resync: fetches=%d %v", fetches, dep.BidList)
+	}
+}
+
+func TestOrderBook_StaleSnapshot(t *testing.T) {
+	lastUpdateID := int64(50)
+	book := newOrderBook(goex.BTC_USDT, 0, func() (*depthSnapshot, error) {
+		snapshot := &depthSnapshot{LastUpdateID: lastUpdateID}
+		lastUpdateID = 150
+		return snapshot, nil
+	})
+	book.spawn = func(f func()) { f() }
+
+	// the first snapshot is older than the stream and gets thrown away
+	if dep, err := book.Update(diff(100, 120, nil, nil)); err == nil || dep != nil {
+		t.Fatalf("expected a stale snapshot error, got %v %v", dep, err)
+	}
+	dep, err := book.Update(diff(121, 151, []goex.DepthRecord{{Price: 1, Amount: 1}}, nil))
+	if err != nil || len(dep.BidList) != 1 {
+		t.Errorf("expected the book from the second snapshot, got %v %v", dep, err)
+	}
+}
+
+// TestOrderBook_Backoff feeds a diff every 100ms to a book whose snapshots
+// are rate limited, the failed fetches have to back off instead of going
+// out with every diff.
+func TestOrderBook_Backoff(t *testing.T) {
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ws := NewSpotWs()
+	defer ws.Close()
+	ws.SetRestBaseURL(srv.URL)
+	ws.SetHttpClient(srv.Client())
+	book := newOrderBook(goex.BTC_USDT, 0, func() (*depthSnapshot, error) {
+		return ws.fetchDepthSnapshot(goex.BTC_USDT)
+	})
+	book.spawn = func(f func()) { f() }
+	now := time.Date(2021, 12, 29, 0, 0, 0, 0, time.UTC)
+	book.now = func() time.Time { return now }
+
+	var failed int
+	for i := int64(1); i <= 600; i++ {
+		if _, err := book.Update(diff(i, i, nil, nil)); err != nil {
+			failed++
+		}
+		now = now.Add(100 * time.Millisecond)
+	}
+	// a minute of diffs, fetched at 0, 1, 3, 7, 15 and 31s
+	if fetches != 6 || failed != fetches {
+		t.Errorf("%d fetches and %d errors in a minute, want 6", fetches, failed)
+	}
+}
+
+// TestSpotWs_SnapshotClose checks that Close aborts a snapshot request the
+// server never answers.
+func TestSpotWs_SnapshotClose(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ws := NewSpotWs()
+	ws.SetRestBaseURL(srv.URL)
+	done := make(chan error, 1)
+	go func() {
+		_, err := ws.fetchDepthSnapshot(goex.BTC_USDT)
+		done <- err
+	}()
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ws.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("snapshot fetched after the close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("snapshot still in flight after the close")
+	}
+}
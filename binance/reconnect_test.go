@@ -0,0 +1,108 @@
+package binance
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/common"
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/nntaoli-project/goex"
+)
+
+func TestSpotWs_Lifecycle(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	var (
+		lock   sync.Mutex
+		events []string
+	)
+	bnWs := NewSpotWs()
+	bnWs.SetCombinedBaseURL(srv.URL + "/stream?streams=")
+	bnWs.streams.interval = 0
+	bnWs.LifecycleCallback(func(event *common.Event) {
+		lock.Lock()
+		events = append(events, event.Type.String())
+		lock.Unlock()
+	})
+	defer bnWs.Close()
+	bnWs.TickerCallback(func(ticker *goex.Ticker) {})
+	bnWs.TradeCallback(func(trade *goex.Trade) {})
+	bnWs.SubscribeTicker(goex.BTC_USDT)
+	bnWs.SubscribeTrade(goex.BTC_USDT)
+
+	got := func() string {
+		lock.Lock()
+		defer lock.Unlock()
+		return strings.Join(events, ",")
+	}
+	subscribes := func() int {
+		n := 0
+		for _, msg := range srv.Received() {
+			if strings.Contains(msg, `"SUBSCRIBE"`) && strings.Contains(msg, "btcusdt@trade") {
+				n++
+			}
+		}
+		return n
+	}
+
+	// the trade stream may go out live and with the replay, depending on
+	// which comes first
+	want := "connected,resubscribed"
+	if !wstest.Eventually(time.Second, func() bool { return got() == want && subscribes() > 0 }) {
+		t.Fatalf("events %s, received %v, want %s and the trade stream subscribed", got(), srv.Received(), want)
+	}
+
+	// the ticker stream is back through the url, the trade stream is
+	// subscribed again
+	n := subscribes()
+	srv.Drop()
+	want += ",disconnected,reconnecting,connected,resubscribed"
+	if !wstest.Eventually(3*time.Second, func() bool { return got() == want && subscribes() == n+1 }) {
+		t.Fatalf("events %s, received %v, want %s and the trade stream resubscribed", got(), srv.Received(), want)
+	}
+}
+
+func TestSpotWs_Stale(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	clock := common.NewFakeClock(time.Unix(0, 0))
+	var (
+		lock  sync.Mutex
+		stale []string
+	)
+	bnWs := NewSpotWs()
+	bnWs.SetCombinedBaseURL(srv.URL + "/stream?streams=")
+	bnWs.clock = clock
+	bnWs.SetMaxSilence(common.TickerChannel, 5*time.Second)
+	bnWs.LifecycleCallback(func(event *common.Event) {
+		if event.Type == common.Stale {
+			lock.Lock()
+			stale = append(stale, event.Channel)
+			lock.Unlock()
+		}
+	})
+	defer bnWs.Close()
+	bnWs.TickerCallback(func(ticker *goex.Ticker) {})
+	bnWs.SubscribeTicker(goex.BTC_USDT)
+
+	if !wstest.Eventually(time.Second, func() bool { return srv.Accepted() == 1 }) {
+		t.Fatal("not connected")
+	}
+	// the server stays silent, the connection is given up and redialed
+	ok := wstest.Eventually(3*time.Second, func() bool {
+		clock.Advance(time.Second)
+		return srv.Accepted() == 2
+	})
+	if !ok {
+		t.Fatal("stale connection not redialed")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(stale) == 0 || stale[0] != "btcusdt@ticker" {
+		t.Errorf("stale %v, want btcusdt@ticker", stale)
+	}
+}
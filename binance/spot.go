@@ -1,11 +1,13 @@
 package binance
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/goex-top/goexws/common"
 	jsoniter "github.com/json-iterator/go"
 	. "github.com/nntaoli-project/goex"
-	"strconv"
+	"net/http"
 	"strings"
 	"time"
 	"unsafe"
@@ -13,15 +15,34 @@ import (
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+// spotDepthSizes are the partial book streams plus the local order book kept
+// from the diff stream, which is seeded with a 1000 level snapshot.
+var spotDepthSizes = []int{5, 10, 20, 1000}
+
 type SpotWs struct {
 	baseURL         string
 	combinedBaseURL string
+	restBaseURL     string
 	proxyUrl        string
-	tickerCallback  func(*Ticker)
-	depthCallback   func(*Depth)
-	tradeCallback   func(*Trade)
-	klineCallback   func(*Kline, int)
-	wsConns         []*WsConn
+	httpClient      *http.Client
+	tickerCallback  common.Callback[func(*Ticker)]
+	depthCallback   common.Callback[func(*Depth)]
+	tradeCallback   common.Callback[func(*Trade)]
+	klineCallback   common.Callback[func(*Kline, int)]
+	dispatcher      common.Dispatcher
+	depthRoutes     common.Fanout[*Depth]
+	tickerRoutes    common.Fanout[*Ticker]
+	tradeRoutes     common.Fanout[*Trade]
+	klineRoutes     common.Fanout[*Kline]
+	events          common.Events
+	errs            common.Errors
+	log             common.Log
+	panics          common.Panics
+	clock           common.Clock
+	streams         *streamMux
+	// ctx is canceled by Close, it aborts the snapshots in flight
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type AggTrade struct {
@@ -84,6 +105,12 @@ func NewSpotWs() *SpotWs {
 	bnWs := &SpotWs{}
 	bnWs.baseURL = "wss://stream.binance.com:9443/ws"
 	bnWs.combinedBaseURL = "wss://stream.binance.com:9443/stream?streams="
+	bnWs.restBaseURL = "https://api.binance.com"
+	bnWs.httpClient = &http.Client{Timeout: snapshotTimeout}
+	bnWs.ctx, bnWs.cancel = context.WithCancel(context.Background())
+	bnWs.streams = newStreamMux(spotMaxStreamsPerConn, bnWs.dialStreams)
+	bnWs.streams.report = bnWs.errs.Report
+	bnWs.dispatcher = common.SerialPerSymbol(0)
 	return bnWs
 }
 
@@ -99,69 +126,271 @@ func (bnWs *SpotWs) SetCombinedBaseURL(combinedBaseURL string) {
 	bnWs.combinedBaseURL = combinedBaseURL
 }
 
+// SetRestBaseURL sets the REST endpoint the order book snapshots are read from.
+func (bnWs *SpotWs) SetRestBaseURL(restBaseURL string) {
+	bnWs.restBaseURL = restBaseURL
+}
+
+// SetHttpClient sets the client used for the order book snapshots.
+func (bnWs *SpotWs) SetHttpClient(httpClient *http.Client) {
+	bnWs.httpClient = httpClient
+}
+
 func (bnWs *SpotWs) SetCallbacks(
 	tickerCallback func(*Ticker),
 	depthCallback func(*Depth),
 	tradeCallback func(*Trade),
 	klineCallback func(*Kline, int),
 ) {
-	bnWs.tickerCallback = tickerCallback
-	bnWs.depthCallback = depthCallback
-	bnWs.tradeCallback = tradeCallback
-	bnWs.klineCallback = klineCallback
+	bnWs.tickerCallback.Set(tickerCallback)
+	bnWs.depthCallback.Set(depthCallback)
+	bnWs.tradeCallback.Set(tradeCallback)
+	bnWs.klineCallback.Set(klineCallback)
 }
 
 func (bnWs *SpotWs) DepthCallback(
 	depthCallback func(*Depth),
 ) {
-	bnWs.depthCallback = depthCallback
+	bnWs.depthCallback.Set(depthCallback)
 }
 
 func (bnWs *SpotWs) TickerCallback(
 	tickerCallback func(*Ticker),
 ) {
-	bnWs.tickerCallback = tickerCallback
+	bnWs.tickerCallback.Set(tickerCallback)
 }
 
 func (bnWs *SpotWs) TradeCallback(
 	tradeCallback func(*Trade),
 ) {
-	bnWs.tradeCallback = tradeCallback
+	bnWs.tradeCallback.Set(tradeCallback)
 }
 
 func (bnWs *SpotWs) KlineCallback(
 	klineCallback func(*Kline, int),
 ) {
-	bnWs.klineCallback = klineCallback
+	bnWs.klineCallback.Set(klineCallback)
+}
+
+// SetMaxStreamsPerConn sets how many streams are packed into one combined
+// stream connection before another one is opened.
+func (bnWs *SpotWs) SetMaxStreamsPerConn(maxStreams int) {
+	bnWs.streams.setMaxStreams(maxStreams)
+}
+
+// ErrorCallback gets the messages that could not be parsed or handled, the
+// error replies of the server and the rejected streams.
+func (bnWs *SpotWs) ErrorCallback(call func(err error)) {
+	bnWs.errs.SetCallback(call)
+}
+
+// SetLogger sets where the connection lifecycle and the message errors are
+// logged, nothing is logged by default.
+func (bnWs *SpotWs) SetLogger(logger common.Logger) {
+	bnWs.log.SetLogger(logger)
+}
+
+// LogFrames turns debug logging of every raw frame sent and received on or
+// off, it takes effect right away.
+func (bnWs *SpotWs) LogFrames(on bool) {
+	bnWs.log.SetFrames(on)
+}
+
+// RecoverPanics turns the recovery of panics in the callbacks and message
+// handling on or off, it is on by default. A recovered panic is handed to the
+// error callback as a *common.PanicError and the connection stays up.
+func (bnWs *SpotWs) RecoverPanics(on bool) {
+	bnWs.panics.SetRecover(on)
+}
+
+// LifecycleCallback is told whenever a connection comes up, drops, is
+// redialed and has its streams restored.
+func (bnWs *SpotWs) LifecycleCallback(call func(event *common.Event)) {
+	bnWs.events.SetCallback(call)
+}
+
+// SetMaxSilence sets how long streams of channelType may go without data
+// before their connection is dropped and redialed, 0 turns the check off. It
+// applies to the streams subscribed afterwards.
+func (bnWs *SpotWs) SetMaxSilence(channelType common.ChannelType, maxSilence time.Duration) {
+	bnWs.streams.maxSilence.Set(channelType, maxSilence)
+}
+
+// dialStreams opens a combined stream connection. Binance pings every few
+// minutes and the pongs are answered by the connection itself, so there is
+// no heartbeat of our own.
+func (bnWs *SpotWs) dialStreams(stream string, handle func(msg []byte) error, connected func()) streamSender {
+	url := bnWs.combinedBaseURL + stream
+	conn := common.NewConn(common.ConnConfig{
+		URL:               url,
+		ProxyURL:          bnWs.proxyUrl,
+		ReconnectInterval: time.Second,
+		Handle:            handle,
+		OnConnected: func() {
+			connected()
+			bnWs.events.Emit(common.Event{Type: common.Resubscribed, URL: url})
+		},
+		OnEvent: bnWs.events.Emit,
+		OnError: bnWs.errs.Report,
+		Log:     &bnWs.log,
+		Panics:  &bnWs.panics,
+		Clock:   bnWs.clock,
+	})
+	conn.Start()
+	return conn
+}
+
+// SetDispatcher sets how the data callbacks are run, they are serial per
+// symbol by default. It has to be called before subscribing.
+func (bnWs *SpotWs) SetDispatcher(dispatcher common.Dispatcher) {
+	bnWs.dispatcher = dispatcher
+}
+
+// Close closes every connection and the subscription channels, no callback
+// is called once it returns. It must not be called from a callback.
+func (bnWs *SpotWs) Close() error {
+	bnWs.cancel()
+	err := bnWs.streams.close()
+	bnWs.dispatcher.Close()
+	bnWs.depthRoutes.Close()
+	bnWs.tickerRoutes.Close()
+	bnWs.tradeRoutes.Close()
+	bnWs.klineRoutes.Close()
+	return err
+}
+
+// SubscribeDepthChan subscribes to the depth of pair and delivers it on the
+// returned channel, alongside the depth callback if one is set. The channel
+// is closed once ctx is done, the stream stays subscribed until
+// UnsubscribeDepth.
+func (bnWs *SpotWs) SubscribeDepthChan(ctx context.Context, pair CurrencyPair, size int, opts ...common.StreamOption) (<-chan *Depth, error) {
+	return common.SubscribeChan(ctx, &bnWs.depthRoutes, common.RouteKey(pair), func() error {
+		return bnWs.subscribeDepth(pair, size)
+	}, opts...)
+}
+
+func (bnWs *SpotWs) SubscribeTickerChan(ctx context.Context, pair CurrencyPair, opts ...common.StreamOption) (<-chan *Ticker, error) {
+	return common.SubscribeChan(ctx, &bnWs.tickerRoutes, common.RouteKey(pair), func() error {
+		return bnWs.subscribeTicker(pair)
+	}, opts...)
+}
+
+func (bnWs *SpotWs) SubscribeTradeChan(ctx context.Context, pair CurrencyPair, opts ...common.StreamOption) (<-chan *Trade, error) {
+	return common.SubscribeChan(ctx, &bnWs.tradeRoutes, common.RouteKey(pair), func() error {
+		return bnWs.subscribeTrade(pair)
+	}, opts...)
+}
+
+func (bnWs *SpotWs) SubscribeKlineChan(ctx context.Context, pair CurrencyPair, period int, opts ...common.StreamOption) (<-chan *Kline, error) {
+	return common.SubscribeChan(ctx, &bnWs.klineRoutes, common.RouteKey(pair, period), func() error {
+		return bnWs.subscribeKline(pair, period)
+	}, opts...)
 }
 
-func (bnWs *SpotWs) Subscribe(endpoint string, handle func(msg []byte) error) *WsConn {
-	wsConn := NewWsBuilder().
-		WsUrl(endpoint).
-		AutoReconnect().
-		ProtoHandleFunc(handle).
-		ProxyUrl(bnWs.proxyUrl).
-		ReconnectInterval(time.Millisecond * 5).
-		Build()
-	bnWs.wsConns = append(bnWs.wsConns, wsConn)
-	go bnWs.exitHandler(wsConn)
-	return wsConn
+// SubscribeDepthFunc subscribes to the depth of pair and hands it to call,
+// besides the depth callback if one is set. call replaces the one it was
+// subscribed with before and is dropped by UnsubscribeDepth.
+func (bnWs *SpotWs) SubscribeDepthFunc(pair CurrencyPair, size int, call func(depth *Depth)) error {
+	if call == nil {
+		return errors.New("please set depth callback func")
+	}
+	return common.SubscribeFunc(&bnWs.depthRoutes, common.RouteKey(pair), call, func() error {
+		return bnWs.subscribeDepth(pair, size)
+	})
 }
 
-func (bnWs *SpotWs) Close() {
-	for _, con := range bnWs.wsConns {
-		con.CloseWs()
+func (bnWs *SpotWs) SubscribeTickerFunc(pair CurrencyPair, call func(ticker *Ticker)) error {
+	if call == nil {
+		return errors.New("please set ticker callback func")
 	}
+	return common.SubscribeFunc(&bnWs.tickerRoutes, common.RouteKey(pair), call, func() error {
+		return bnWs.subscribeTicker(pair)
+	})
+}
+
+func (bnWs *SpotWs) SubscribeTradeFunc(pair CurrencyPair, call func(trade *Trade)) error {
+	if call == nil {
+		return errors.New("please set trade callback func")
+	}
+	return common.SubscribeFunc(&bnWs.tradeRoutes, common.RouteKey(pair), call, func() error {
+		return bnWs.subscribeTrade(pair)
+	})
+}
+
+func (bnWs *SpotWs) SubscribeKlineFunc(pair CurrencyPair, period int, call func(kline *Kline)) error {
+	if call == nil {
+		return errors.New("please set kline callback func")
+	}
+	return common.SubscribeFunc(&bnWs.klineRoutes, common.RouteKey(pair, period), call, func() error {
+		return bnWs.subscribeKline(pair, period)
+	})
+}
+
+// dispatch runs call through the dispatcher, a panic is recovered and
+// reported with the frame raw and data.
+func (bnWs *SpotWs) dispatch(key string, raw []byte, data interface{}, call func()) {
+	bnWs.dispatcher.Dispatch(key, func() {
+		bnWs.panics.Run(bnWs.errs.Report, raw, data, call)
+	})
+}
+
+func (bnWs *SpotWs) onDepth(raw []byte, depth *Depth) {
+	key := common.RouteKey(depth.Pair)
+	bnWs.dispatch(key, raw, depth, func() {
+		if call := bnWs.depthCallback.Get(); call != nil {
+			call(depth)
+		}
+		bnWs.depthRoutes.Emit(key, depth)
+	})
+}
+
+func (bnWs *SpotWs) onTicker(raw []byte, tick *Ticker) {
+	key := common.RouteKey(tick.Pair)
+	bnWs.dispatch(key, raw, tick, func() {
+		if call := bnWs.tickerCallback.Get(); call != nil {
+			call(tick)
+		}
+		bnWs.tickerRoutes.Emit(key, tick)
+	})
+}
+
+func (bnWs *SpotWs) onTrade(raw []byte, trade *Trade) {
+	key := common.RouteKey(trade.Pair)
+	bnWs.dispatch(key, raw, trade, func() {
+		if call := bnWs.tradeCallback.Get(); call != nil {
+			call(trade)
+		}
+		bnWs.tradeRoutes.Emit(key, trade)
+	})
+}
+
+// onKline takes the key the kline was subscribed under, the period Binance
+// reports back is not always the one asked for.
+func (bnWs *SpotWs) onKline(key string, raw []byte, kline *Kline, period int) {
+	bnWs.dispatch(key, raw, kline, func() {
+		if call := bnWs.klineCallback.Get(); call != nil {
+			call(kline, period)
+		}
+		bnWs.klineRoutes.Emit(key, kline)
+	})
 }
 
 func (bnWs *SpotWs) SubscribeDepth(pair CurrencyPair, size int) error {
-	if bnWs.depthCallback == nil {
+	if bnWs.depthCallback.Get() == nil {
 		return errors.New("please set depth callback func")
 	}
-	if size != 5 && size != 10 && size != 20 {
-		return errors.New("please set depth size as 5 / 10 / 20")
+	return bnWs.subscribeDepth(pair, size)
+}
+
+func (bnWs *SpotWs) subscribeDepth(pair CurrencyPair, size int) error {
+	channelSize, err := common.DepthChannelSize("binance", size, spotDepthSizes)
+	if err != nil {
+		return err
+	}
+	if channelSize > 20 {
+		return bnWs.subscribeOrderBook(pair, size, bnWs.onDepth)
 	}
-	endpoint := fmt.Sprintf("%s/%s@depth%d@100ms", bnWs.baseURL, strings.ToLower(pair.ToSymbol("")), size)
+	stream := fmt.Sprintf("%s@depth%d@100ms", strings.ToLower(pair.ToSymbol("")), channelSize)
 
 	handle := func(msg []byte) error {
 		rawDepth := struct {
@@ -171,75 +400,80 @@ func (bnWs *SpotWs) SubscribeDepth(pair CurrencyPair, size int) error {
 		}{}
 		err := json.Unmarshal(msg, &rawDepth)
 		if err != nil {
-			fmt.Println("json unmarshal error for ", string(msg))
-			return err
+			return &common.ParseError{Raw: msg, Err: err}
 		}
 		depth := bnWs.parseDepthData(rawDepth.Bids, rawDepth.Asks)
 		depth.Pair = pair
 		depth.UTime = time.Now()
-		bnWs.depthCallback(depth)
+		common.TruncateDepth(depth, size)
+		bnWs.onDepth(msg, depth)
 		return nil
 	}
-	bnWs.Subscribe(endpoint, handle)
-	return nil
+	return bnWs.streams.subscribe(stream, handle)
 }
 
 func (bnWs *SpotWs) SubscribeTicker(pair CurrencyPair) error {
-	if bnWs.tickerCallback == nil {
+	if bnWs.tickerCallback.Get() == nil {
 		return errors.New("please set ticker callback func")
 	}
-	endpoint := fmt.Sprintf("%s/%s@ticker", bnWs.baseURL, strings.ToLower(pair.ToSymbol("")))
+	return bnWs.subscribeTicker(pair)
+}
+
+func (bnWs *SpotWs) subscribeTicker(pair CurrencyPair) error {
+	stream := fmt.Sprintf("%s@ticker", strings.ToLower(pair.ToSymbol("")))
 
 	handle := func(msg []byte) error {
 		datamap := make(map[string]interface{})
 		err := json.Unmarshal(msg, &datamap)
 		if err != nil {
-			fmt.Println("json unmarshal error for ", string(msg))
-			return err
+			return &common.ParseError{Raw: msg, Err: err}
 		}
 
 		msgType, isOk := datamap["e"].(string)
 		if !isOk {
-			return errors.New("no message type")
+			return &common.ParseError{Raw: msg, Err: errors.New("no message type")}
 		}
 
 		switch msgType {
 		case "24hrTicker":
 			tick := bnWs.parseTickerData(datamap)
 			tick.Pair = pair
-			bnWs.tickerCallback(tick)
+			bnWs.onTicker(msg, tick)
 			return nil
 		default:
-			return errors.New("unknown message " + msgType)
+			return &common.UnknownChannelError{Channel: stream, Raw: msg}
 		}
 	}
-	bnWs.Subscribe(endpoint, handle)
-	return nil
+	return bnWs.streams.subscribe(stream, handle)
 }
 
 func (bnWs *SpotWs) SubscribeTrade(pair CurrencyPair) error {
-	if bnWs.tradeCallback == nil {
+	if bnWs.tradeCallback.Get() == nil {
 		return errors.New("please set trade callback func")
 	}
-	endpoint := fmt.Sprintf("%s/%s@trade", bnWs.baseURL, strings.ToLower(pair.ToSymbol("")))
+	return bnWs.subscribeTrade(pair)
+}
+
+func (bnWs *SpotWs) subscribeTrade(pair CurrencyPair) error {
+	stream := fmt.Sprintf("%s@trade", strings.ToLower(pair.ToSymbol("")))
 
 	handle := func(msg []byte) error {
 		datamap := make(map[string]interface{})
 		err := json.Unmarshal(msg, &datamap)
 		if err != nil {
-			fmt.Println("json unmarshal error for ", string(msg))
-			return err
+			return &common.ParseError{Raw: msg, Err: err}
 		}
 
 		msgType, isOk := datamap["e"].(string)
 		if !isOk {
-			return errors.New("no message type")
+			return &common.ParseError{Raw: msg, Err: errors.New("no message type")}
 		}
 
 		switch msgType {
 		case "trade":
+			// the buyer being the maker makes the taker a seller
 			side := BUY
-			if datamap["m"].(bool) == false {
+			if isMaker, _ := datamap["m"].(bool); isMaker {
 				side = SELL
 			}
 			trade := &RawTrade{
@@ -254,37 +488,40 @@ func (bnWs *SpotWs) SubscribeTrade(pair CurrencyPair) error {
 				SellerOrderID: ToInt64(datamap["a"]),
 			}
 			trade.Pair = pair
-			bnWs.tradeCallback((*Trade)(unsafe.Pointer(trade)))
+			bnWs.onTrade(msg, (*Trade)(unsafe.Pointer(trade)))
 			return nil
 		default:
-			return errors.New("unknown message " + msgType)
+			return &common.UnknownChannelError{Channel: stream, Raw: msg}
 		}
 	}
-	bnWs.Subscribe(endpoint, handle)
-	return nil
+	return bnWs.streams.subscribe(stream, handle)
 }
 
 func (bnWs *SpotWs) SubscribeKline(pair CurrencyPair, period int) error {
-	if bnWs.klineCallback == nil {
+	if bnWs.klineCallback.Get() == nil {
 		return errors.New("place set kline callback func")
 	}
+	return bnWs.subscribeKline(pair, period)
+}
+
+func (bnWs *SpotWs) subscribeKline(pair CurrencyPair, period int) error {
 	periodS, isOk := _INERNAL_KLINE_PERIOD_CONVERTER[period]
 	if isOk != true {
 		periodS = "M1"
 	}
-	endpoint := fmt.Sprintf("%s/%s@kline_%s", bnWs.baseURL, strings.ToLower(pair.ToSymbol("")), periodS)
+	stream := fmt.Sprintf("%s@kline_%s", strings.ToLower(pair.ToSymbol("")), periodS)
+	key := common.RouteKey(pair, period)
 
 	handle := func(msg []byte) error {
 		datamap := make(map[string]interface{})
 		err := json.Unmarshal(msg, &datamap)
 		if err != nil {
-			fmt.Println("json unmarshal error for ", string(msg))
-			return err
+			return &common.ParseError{Raw: msg, Err: err}
 		}
 
 		msgType, isOk := datamap["e"].(string)
 		if !isOk {
-			return errors.New("no message type")
+			return &common.ParseError{Raw: msg, Err: errors.New("no message type")}
 		}
 
 		switch msgType {
@@ -293,14 +530,52 @@ func (bnWs *SpotWs) SubscribeKline(pair CurrencyPair, period int) error {
 			period := _INERNAL_KLINE_PERIOD_REVERTER[k["i"].(string)]
 			kline := bnWs.parseKlineData(k)
 			kline.Pair = pair
-			bnWs.klineCallback(kline, period)
+			bnWs.onKline(key, msg, kline, period)
 			return nil
 		default:
-			return errors.New("unknown message " + msgType)
+			return &common.UnknownChannelError{Channel: stream, Raw: msg}
 		}
 	}
-	bnWs.Subscribe(endpoint, handle)
-	return nil
+	return bnWs.streams.subscribe(stream, handle)
+}
+
+// UnsubscribeDepth stops the depth of pair, whichever size it was subscribed
+// with, including a SubscribeOrderBook book.
+func (bnWs *SpotWs) UnsubscribeDepth(pair CurrencyPair) error {
+	bnWs.depthRoutes.Unhandle(common.RouteKey(pair))
+	symbol := strings.ToLower(pair.ToSymbol(""))
+	return bnWs.streams.unsubscribe(
+		symbol+"@depth5@100ms",
+		symbol+"@depth10@100ms",
+		symbol+"@depth20@100ms",
+		symbol+"@depth@100ms")
+}
+
+func (bnWs *SpotWs) UnsubscribeTicker(pair CurrencyPair) error {
+	bnWs.tickerRoutes.Unhandle(common.RouteKey(pair))
+	return bnWs.streams.unsubscribe(fmt.Sprintf("%s@ticker", strings.ToLower(pair.ToSymbol(""))))
+}
+
+func (bnWs *SpotWs) UnsubscribeTrade(pair CurrencyPair) error {
+	bnWs.tradeRoutes.Unhandle(common.RouteKey(pair))
+	return bnWs.streams.unsubscribe(fmt.Sprintf("%s@trade", strings.ToLower(pair.ToSymbol(""))))
+}
+
+func (bnWs *SpotWs) UnsubscribeKline(pair CurrencyPair, period int) error {
+	bnWs.klineRoutes.Unhandle(common.RouteKey(pair, period))
+	periodS, isOk := _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	if isOk != true {
+		periodS = "M1"
+	}
+	return bnWs.streams.unsubscribe(fmt.Sprintf("%s@kline_%s", strings.ToLower(pair.ToSymbol("")), periodS))
+}
+
+func (bnWs *SpotWs) UnsubscribeAggTrade(pair CurrencyPair) error {
+	return bnWs.streams.unsubscribe(fmt.Sprintf("%s@aggTrade", strings.ToLower(pair.ToSymbol(""))))
+}
+
+func (bnWs *SpotWs) UnsubscribeDiffDepth(pair CurrencyPair) error {
+	return bnWs.streams.unsubscribe(fmt.Sprintf("%s@depth", strings.ToLower(pair.ToSymbol(""))))
 }
 
 func (bnWs *SpotWs) parseTickerData(tickmap map[string]interface{}) *Ticker {
@@ -344,25 +619,25 @@ func (bnWs *SpotWs) SubscribeAggTrade(pair CurrencyPair, tradeCallback func(*Tra
 	if tradeCallback == nil {
 		return errors.New("please set trade callback func")
 	}
-	endpoint := fmt.Sprintf("%s/%s@aggTrade", bnWs.baseURL, strings.ToLower(pair.ToSymbol("")))
+	stream := fmt.Sprintf("%s@aggTrade", strings.ToLower(pair.ToSymbol("")))
 
 	handle := func(msg []byte) error {
 		datamap := make(map[string]interface{})
 		err := json.Unmarshal(msg, &datamap)
 		if err != nil {
-			fmt.Println("json unmarshal error for ", string(msg))
-			return err
+			return &common.ParseError{Raw: msg, Err: err}
 		}
 
 		msgType, isOk := datamap["e"].(string)
 		if !isOk {
-			return errors.New("no message type")
+			return &common.ParseError{Raw: msg, Err: errors.New("no message type")}
 		}
 
 		switch msgType {
 		case "aggTrade":
+			// the buyer being the maker makes the taker a seller
 			side := BUY
-			if datamap["m"].(bool) == false {
+			if isMaker, _ := datamap["m"].(bool); isMaker {
 				side = SELL
 			}
 			aggTrade := &AggTrade{
@@ -378,68 +653,104 @@ func (bnWs *SpotWs) SubscribeAggTrade(pair CurrencyPair, tradeCallback func(*Tra
 				TradeTime:             int64(ToUint64(datamap["T"])),
 			}
 			aggTrade.Pair = pair
-			tradeCallback((*Trade)(unsafe.Pointer(aggTrade)))
+			bnWs.dispatch(common.RouteKey(pair), msg, aggTrade, func() {
+				tradeCallback((*Trade)(unsafe.Pointer(aggTrade)))
+			})
 			return nil
 		default:
-			return errors.New("unknown message " + msgType)
+			return &common.UnknownChannelError{Channel: stream, Raw: msg}
 		}
 	}
-	bnWs.Subscribe(endpoint, handle)
-	return nil
+	return bnWs.streams.subscribe(stream, handle)
+}
+
+func (bnWs *SpotWs) parseDiffDepth(msg []byte) (*DiffDepth, error) {
+	rawDepth := struct {
+		Type          string          `json:"e"`
+		Time          int64           `json:"E"`
+		Symbol        string          `json:"s"`
+		FirstUpdateID int64           `json:"U"`
+		UpdateID      int64           `json:"u"`
+		Bids          [][]interface{} `json:"b"`
+		Asks          [][]interface{} `json:"a"`
+	}{}
+
+	err := json.Unmarshal(msg, &rawDepth)
+	if err != nil {
+		return nil, &common.ParseError{Raw: msg, Err: err}
+	}
+	diffDepth := new(DiffDepth)
+	for _, v := range rawDepth.Bids {
+		diffDepth.BidList = append(diffDepth.BidList, DepthRecord{Price: ToFloat64(v[0]), Amount: ToFloat64(v[1])})
+	}
+
+	for _, v := range rawDepth.Asks {
+		diffDepth.AskList = append(diffDepth.AskList, DepthRecord{Price: ToFloat64(v[0]), Amount: ToFloat64(v[1])})
+	}
+
+	diffDepth.FirstUpdateID = rawDepth.FirstUpdateID
+	diffDepth.UpdateID = rawDepth.UpdateID
+	diffDepth.UTime = time.Unix(0, rawDepth.Time*int64(time.Millisecond))
+	return diffDepth, nil
 }
 
 func (bnWs *SpotWs) SubscribeDiffDepth(pair CurrencyPair, depthCallback func(*Depth)) error {
 	if depthCallback == nil {
 		return errors.New("please set depth callback func")
 	}
-	endpoint := fmt.Sprintf("%s/%s@depth", bnWs.baseURL, strings.ToLower(pair.ToSymbol("")))
+	stream := fmt.Sprintf("%s@depth", strings.ToLower(pair.ToSymbol("")))
 
 	handle := func(msg []byte) error {
-		rawDepth := struct {
-			Type     string          `json:"e"`
-			Time     int64           `json:"E"`
-			Symbol   string          `json:"s"`
-			UpdateID int             `json:"u"`
-			Bids     [][]interface{} `json:"b"`
-			Asks     [][]interface{} `json:"a"`
-		}{}
-
-		err := json.Unmarshal(msg, &rawDepth)
+		diffDepth, err := bnWs.parseDiffDepth(msg)
 		if err != nil {
-			fmt.Println("json unmarshal error for ", string(msg))
 			return err
 		}
-		diffDepth := new(DiffDepth)
-		for _, v := range rawDepth.Bids {
-			diffDepth.BidList = append(diffDepth.BidList, DepthRecord{ToFloat64(v[0]), ToFloat64(v[1])})
-		}
-
-		for _, v := range rawDepth.Asks {
-			diffDepth.AskList = append(diffDepth.AskList, DepthRecord{ToFloat64(v[0]), ToFloat64(v[1])})
-		}
-
 		diffDepth.Pair = pair
-		diffDepth.UTime = time.Unix(0, rawDepth.Time*int64(time.Millisecond))
-		depthCallback((*Depth)(unsafe.Pointer(diffDepth)))
+		bnWs.dispatch(common.RouteKey(pair), msg, diffDepth, func() {
+			depthCallback((*Depth)(unsafe.Pointer(diffDepth)))
+		})
 		return nil
 	}
-	bnWs.Subscribe(endpoint, handle)
-	return nil
-}
-
-func (bnWs *SpotWs) exitHandler(c *WsConn) {
-	pingTicker := time.NewTicker(10 * time.Minute)
-	pongTicker := time.NewTicker(time.Second)
-	defer pingTicker.Stop()
-	defer pongTicker.Stop()
-	defer c.CloseWs()
-
-	for {
-		select {
-		case t := <-pingTicker.C:
-			c.SendPingMessage([]byte(strconv.Itoa(int(t.UnixNano() / int64(time.Millisecond)))))
-		case t := <-pongTicker.C:
-			c.SendPongMessage([]byte(strconv.Itoa(int(t.UnixNano() / int64(time.Millisecond)))))
+	return bnWs.streams.subscribe(stream, handle)
+}
+
+// SubscribeOrderBook maintains a local order book from the diff depth stream
+// and a REST snapshot, and calls depthCallback with the top size levels (the
+// whole book when size is 0) after every update once the book is in sync.
+// Sequence gaps are detected and resynced with a fresh snapshot.
+func (bnWs *SpotWs) SubscribeOrderBook(pair CurrencyPair, size int, depthCallback func(*Depth)) error {
+	if depthCallback == nil {
+		return errors.New("please set depth callback func")
+	}
+	return bnWs.subscribeOrderBook(pair, size, func(raw []byte, depth *Depth) {
+		bnWs.dispatch(common.RouteKey(pair), raw, depth, func() {
+			depthCallback(depth)
+		})
+	})
+}
+
+// subscribeOrderBook hands the book to call with the diff that brought it up
+// to date, right from the socket.
+func (bnWs *SpotWs) subscribeOrderBook(pair CurrencyPair, size int, call func(raw []byte, depth *Depth)) error {
+	stream := fmt.Sprintf("%s@depth@100ms", strings.ToLower(pair.ToSymbol("")))
+
+	book := newOrderBook(pair, size, func() (*depthSnapshot, error) {
+		return bnWs.fetchDepthSnapshot(pair)
+	})
+	handle := func(msg []byte) error {
+		diffDepth, err := bnWs.parseDiffDepth(msg)
+		if err != nil {
+			return err
 		}
+		diffDepth.Pair = pair
+		depth, err := book.Update(diffDepth)
+		if err != nil {
+			return err
+		}
+		if depth != nil {
+			call(msg, depth)
+		}
+		return nil
 	}
+	return bnWs.streams.subscribe(stream, handle)
 }
@@ -75,3 +75,8 @@ func TestBinanceWs_SubscribeDepth(t *testing.T) {
 	bnWs.SubscribeDepth(goex.ETC_USDT, 5)
 	time.Sleep(time.Second * 60)
 }
+
+func TestBinanceWs_SubscribeOrderBook(t *testing.T) {
+	bnWs.SubscribeOrderBook(goex.BTC_USDT, 50, printfDepth)
+	time.Sleep(time.Second * 10)
+}
@@ -0,0 +1,352 @@
+package binance
+
+import (
+	json2 "encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goex-top/goexws/common"
+)
+
+const (
+	// spotMaxStreamsPerConn and futuresMaxStreamsPerConn are the number of
+	// streams Binance lets a single combined stream connection listen to.
+	spotMaxStreamsPerConn    = 1024
+	futuresMaxStreamsPerConn = 200
+
+	// controlInterval spaces SUBSCRIBE/UNSUBSCRIBE messages on a connection,
+	// Binance drops connections sending more than 5 (spot) messages a second.
+	controlInterval = 250 * time.Millisecond
+)
+
+// streamSender is the part of *common.Conn the mux talks to.
+type streamSender interface {
+	SendJSON(v interface{}) error
+	Watch(channel string, maxSilence time.Duration)
+	Unwatch(channel string)
+	Feed(channel string)
+	Close() error
+}
+
+type streamConn struct {
+	sender streamSender
+	// first is the stream in the connection url, it is back on every
+	// reconnect whether it is still wanted or not
+	first   string
+	streams map[string]bool
+	// queue holds the control messages pace has yet to send, wake tells it
+	// about new ones
+	queue []streamControl
+	wake  chan struct{}
+	next  time.Time
+}
+
+// streamControl is a SUBSCRIBE or UNSUBSCRIBE message.
+type streamControl struct {
+	id      int64
+	method  string
+	streams []string
+}
+
+type streamError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+type streamEnvelope struct {
+	Stream string           `json:"stream"`
+	Data   json2.RawMessage `json:"data"`
+	Id     int64            `json:"id"`
+	// errors come either flat or wrapped in "error"
+	streamError
+	Error *streamError `json:"error"`
+}
+
+// streamMux packs streams into as few /stream?streams= connections as the
+// per-connection limit allows. The first stream of a connection is part of its
+// url, the others are added with the live SUBSCRIBE method. Frames arrive
+// wrapped in {"stream":..,"data":..} and are routed by stream name.
+//
+// Connections redial on their own, connected has to be called on every
+// (re)connect so that the streams added later are subscribed again.
+//
+// The control messages of a connection are queued and sent interval apart
+// by a goroutine of its own, the lock that routes the frames is never held
+// while waiting for the next slot.
+type streamMux struct {
+	maxStreams int
+	interval   time.Duration
+	// dial opens a combined stream connection listening to stream, handing
+	// every frame to handle and calling connected once it is up.
+	dial func(stream string, handle func(msg []byte) error, connected func()) streamSender
+	// maxSilence is handed to the watchdog of each stream's connection
+	maxSilence *common.MaxSilence
+	// report gets the rejected streams of a SUBSCRIBE
+	report func(err error)
+
+	lock     sync.Mutex
+	closed   bool
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	conns    []*streamConn
+	handlers map[string]func(data []byte) error
+	id       int64
+	// pending holds the streams of every SUBSCRIBE waiting for its reply
+	pending map[int64][]string
+}
+
+func newStreamMux(maxStreams int, dial func(stream string, handle func(msg []byte) error, connected func()) streamSender) *streamMux {
+	return &streamMux{
+		maxStreams: maxStreams,
+		interval:   controlInterval,
+		dial:       dial,
+		maxSilence: common.NewMaxSilence(),
+		report:     func(err error) {},
+		stop:       make(chan struct{}),
+		handlers:   make(map[string]func(data []byte) error),
+		pending:    make(map[int64][]string),
+	}
+}
+
+func (mux *streamMux) setMaxStreams(maxStreams int) {
+	mux.lock.Lock()
+	mux.maxStreams = maxStreams
+	mux.lock.Unlock()
+}
+
+// subscribe routes stream to handle, which gets the "data" of each frame.
+// Subscribing a stream again only replaces its handler.
+func (mux *streamMux) subscribe(stream string, handle func(data []byte) error) error {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+
+	if mux.closed {
+		return common.ErrClosed
+	}
+
+	if _, ok := mux.handlers[stream]; ok {
+		mux.handlers[stream] = handle
+		return nil
+	}
+
+	for _, conn := range mux.conns {
+		if len(conn.streams) >= mux.maxStreams {
+			continue
+		}
+		mux.send(conn, "SUBSCRIBE", stream)
+		conn.streams[stream] = true
+		conn.sender.Watch(stream, mux.maxSilence.Get(channelType(stream)))
+		mux.handlers[stream] = handle
+		return nil
+	}
+
+	conn := &streamConn{
+		first:   stream,
+		streams: map[string]bool{stream: true},
+		wake:    make(chan struct{}, 1),
+		next:    time.Now().Add(mux.interval),
+	}
+	// connected waits on the lock held here, so sender is set before it runs
+	conn.sender = mux.dial(stream, mux.handle, func() { mux.connected(conn) })
+	conn.sender.Watch(stream, mux.maxSilence.Get(channelType(stream)))
+	mux.conns = append(mux.conns, conn)
+	mux.wg.Add(1)
+	go mux.pace(conn)
+	mux.handlers[stream] = handle
+	return nil
+}
+
+// connected brings a fresh connection of conn back to its streams: the url
+// only carries the first one, the rest is subscribed again in one go. The
+// messages still queued are superseded and dropped.
+func (mux *streamMux) connected(conn *streamConn) {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+
+	for _, msg := range conn.queue {
+		delete(mux.pending, msg.id)
+	}
+	conn.queue = nil
+
+	if !conn.streams[conn.first] {
+		mux.send(conn, "UNSUBSCRIBE", conn.first)
+	}
+	var streams []string
+	for stream := range conn.streams {
+		if stream != conn.first {
+			streams = append(streams, stream)
+		}
+	}
+	if len(streams) > 0 {
+		sort.Strings(streams)
+		mux.send(conn, "SUBSCRIBE", streams...)
+	}
+}
+
+// close closes every connection and refuses later subscriptions.
+func (mux *streamMux) close() error {
+	mux.lock.Lock()
+	if !mux.closed {
+		mux.closed = true
+		close(mux.stop)
+	}
+	conns := mux.conns
+	mux.conns = nil
+	mux.lock.Unlock()
+
+	// the connections are closed unlocked, their read loops may be waiting
+	// on the lock
+	for _, conn := range conns {
+		conn.sender.Close()
+	}
+	mux.wg.Wait()
+	return nil
+}
+
+// unsubscribe drops whichever of streams are subscribed, a connection left
+// without streams stays open to take the next ones.
+func (mux *streamMux) unsubscribe(streams ...string) error {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+
+	for _, stream := range streams {
+		if _, ok := mux.handlers[stream]; !ok {
+			continue
+		}
+		delete(mux.handlers, stream)
+		for _, conn := range mux.conns {
+			if !conn.streams[stream] {
+				continue
+			}
+			delete(conn.streams, stream)
+			conn.sender.Unwatch(stream)
+			mux.send(conn, "UNSUBSCRIBE", stream)
+		}
+	}
+	return nil
+}
+
+// send queues a control message for pace, it is called with the lock held.
+func (mux *streamMux) send(conn *streamConn, method string, streams ...string) {
+	mux.id++
+	conn.queue = append(conn.queue, streamControl{id: mux.id, method: method, streams: streams})
+	if method == "SUBSCRIBE" {
+		mux.pending[mux.id] = streams
+	}
+	select {
+	case conn.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pace sends the queued control messages of conn interval apart until the
+// mux is closed. A message sent while the connection is down is dropped,
+// connected catches up with it.
+func (mux *streamMux) pace(conn *streamConn) {
+	defer mux.wg.Done()
+	for {
+		mux.lock.Lock()
+		if len(conn.queue) == 0 {
+			mux.lock.Unlock()
+			select {
+			case <-mux.stop:
+				return
+			case <-conn.wake:
+			}
+			continue
+		}
+		msg := conn.queue[0]
+		conn.queue = conn.queue[1:]
+		mux.lock.Unlock()
+
+		if wait := time.Until(conn.next); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-mux.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		conn.next = time.Now().Add(mux.interval)
+
+		err := conn.sender.SendJSON(map[string]interface{}{
+			"method": msg.method,
+			"params": msg.streams,
+			"id":     msg.id,
+		})
+		if err == nil {
+			continue
+		}
+		mux.lock.Lock()
+		delete(mux.pending, msg.id)
+		mux.lock.Unlock()
+		if err != common.ErrNotConnected && err != common.ErrClosed {
+			mux.report(err)
+		}
+	}
+}
+
+func (mux *streamMux) handle(msg []byte) error {
+	var env streamEnvelope
+	err := json.Unmarshal(msg, &env)
+	if err != nil {
+		return &common.ParseError{Raw: msg, Err: err}
+	}
+
+	if env.Stream == "" {
+		mux.lock.Lock()
+		streams := mux.pending[env.Id]
+		delete(mux.pending, env.Id)
+		mux.lock.Unlock()
+
+		if env.Error != nil {
+			env.streamError = *env.Error
+		}
+		if env.Code == 0 && env.Msg == "" {
+			// {"result":null,"id":1}, the reply to SUBSCRIBE/UNSUBSCRIBE
+			return nil
+		}
+		serverErr := &common.ServerError{Code: strconv.Itoa(env.Code), Msg: env.Msg}
+		if len(streams) == 0 {
+			return serverErr
+		}
+		for _, stream := range streams {
+			mux.report(&common.SubscribeError{Channel: stream, Err: serverErr})
+		}
+		return nil
+	}
+
+	mux.lock.Lock()
+	handle := mux.handlers[env.Stream]
+	for _, conn := range mux.conns {
+		if conn.streams[env.Stream] {
+			conn.sender.Feed(env.Stream)
+			break
+		}
+	}
+	mux.lock.Unlock()
+	if handle == nil {
+		return &common.UnknownChannelError{Channel: env.Stream, Raw: msg}
+	}
+	return handle(env.Data)
+}
+
+// channelType tells the watchdog what kind of data a stream such as
+// btcusdt@kline_1m carries.
+func channelType(stream string) common.ChannelType {
+	switch {
+	case strings.Contains(stream, "@depth"):
+		return common.DepthChannel
+	case strings.Contains(stream, "@kline_"):
+		return common.KlineChannel
+	case strings.Contains(stream, "@ticker"):
+		return common.TickerChannel
+	case strings.HasSuffix(stream, "@trade"), strings.HasSuffix(stream, "@aggTrade"):
+		return common.TradeChannel
+	}
+	return ""
+}
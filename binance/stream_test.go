@@ -0,0 +1,270 @@
+package binance
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/common"
+)
+
+type fakeSender struct {
+	url  string
+	lock sync.Mutex
+	sent []map[string]interface{}
+	at   []time.Time
+}
+
+func (s *fakeSender) Watch(channel string, maxSilence time.Duration) {}
+
+func (s *fakeSender) Unwatch(channel string) {}
+
+func (s *fakeSender) Feed(channel string) {}
+
+func (s *fakeSender) Close() error { return nil }
+
+func (s *fakeSender) SendJSON(sub interface{}) error {
+	s.lock.Lock()
+	s.sent = append(s.sent, sub.(map[string]interface{}))
+	s.at = append(s.at, time.Now())
+	s.lock.Unlock()
+	return nil
+}
+
+// wait waits for n messages to be sent and returns them.
+func (s *fakeSender) wait(t *testing.T, n int) []map[string]interface{} {
+	t.Helper()
+	var sent []map[string]interface{}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.lock.Lock()
+		sent = append([]map[string]interface{}(nil), s.sent...)
+		s.lock.Unlock()
+		if len(sent) >= n {
+			break
+		}
+	}
+	if len(sent) != n {
+		t.Fatalf("%s sent %v, want %d messages", s.url, sent, n)
+	}
+	return sent
+}
+
+func (s *fakeSender) reset() {
+	s.lock.Lock()
+	s.sent, s.at = nil, nil
+	s.lock.Unlock()
+}
+
+func TestStreamMux_Shard(t *testing.T) {
+	var senders []*fakeSender
+	var handles []func([]byte) error
+	mux := newStreamMux(2, func(stream string, handle func([]byte) error, connected func()) streamSender {
+		s := &fakeSender{url: stream}
+		senders = append(senders, s)
+		handles = append(handles, handle)
+		return s
+	})
+	mux.interval = 0
+	defer mux.close()
+
+	got := make(map[string]string)
+	for _, stream := range []string{"btcusdt@trade", "ethusdt@trade", "bnbusdt@trade", "btcusdt@trade", "ltcusdt@trade", "xrpusdt@trade"} {
+		stream := stream
+		err := mux.subscribe(stream, func(data []byte) error {
+			got[stream] = string(data)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 5 distinct streams, 2 per connection
+	if len(senders) != 3 {
+		t.Fatalf("got %d connections, want 3", len(senders))
+	}
+	wantUrls := []string{"btcusdt@trade", "bnbusdt@trade", "xrpusdt@trade"}
+	wantSubs := [][]string{{"ethusdt@trade"}, {"ltcusdt@trade"}, nil}
+	for i, s := range senders {
+		if s.url != wantUrls[i] {
+			t.Errorf("connection %d url %s, want %s", i, s.url, wantUrls[i])
+		}
+		for j, sub := range s.wait(t, len(wantSubs[i])) {
+			if sub["method"] != "SUBSCRIBE" || sub["params"].([]string)[0] != wantSubs[i][j] {
+				t.Errorf("connection %d sent %v, want SUBSCRIBE %s", i, sub, wantSubs[i][j])
+			}
+		}
+	}
+
+	err := handles[1]([]byte(`{"stream":"ltcusdt@trade","data":{"e":"trade"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["ltcusdt@trade"] != `{"e":"trade"}` {
+		t.Errorf("ltcusdt@trade got %q", got["ltcusdt@trade"])
+	}
+	if err := handles[0]([]byte(`{"result":null,"id":1}`)); err != nil {
+		t.Errorf("subscribe reply: %v", err)
+	}
+	if err := handles[0]([]byte(`{"code":2,"msg":"Invalid request"}`)); err == nil {
+		t.Error("expected an error for an error reply")
+	}
+	if err := handles[0]([]byte(`{"stream":"dogeusdt@trade","data":{}}`)); err == nil {
+		t.Error("expected an error for an unknown stream")
+	}
+}
+
+func TestStreamMux_Unsubscribe(t *testing.T) {
+	var senders []*fakeSender
+	var handle func([]byte) error
+	mux := newStreamMux(2, func(stream string, h func([]byte) error, connected func()) streamSender {
+		s := &fakeSender{url: stream}
+		senders = append(senders, s)
+		handle = h
+		return s
+	})
+	mux.interval = 0
+	defer mux.close()
+
+	noop := func([]byte) error { return nil }
+	mux.subscribe("btcusdt@trade", noop)
+	mux.subscribe("ethusdt@trade", noop)
+
+	err := mux.unsubscribe("ethusdt@trade", "bnbusdt@trade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a stream never subscribed is not unsubscribed
+	sent := senders[0].wait(t, 2)
+	if last := sent[1]; last["method"] != "UNSUBSCRIBE" || last["params"].([]string)[0] != "ethusdt@trade" {
+		t.Errorf("got %v, want UNSUBSCRIBE ethusdt@trade", last)
+	}
+	if err := handle([]byte(`{"stream":"ethusdt@trade","data":{}}`)); err == nil {
+		t.Error("expected an error for an unsubscribed stream")
+	}
+
+	// the freed slot is reused before a new connection is opened
+	mux.subscribe("ltcusdt@trade", noop)
+	if len(senders) != 1 {
+		t.Errorf("got %d connections, want 1", len(senders))
+	}
+}
+
+func TestStreamMux_Reconnect(t *testing.T) {
+	var sender *fakeSender
+	var connected func()
+	mux := newStreamMux(3, func(stream string, h func([]byte) error, c func()) streamSender {
+		sender = &fakeSender{url: stream}
+		connected = c
+		return sender
+	})
+	mux.interval = 0
+	defer mux.close()
+
+	noop := func([]byte) error { return nil }
+	mux.subscribe("btcusdt@trade", noop)
+	mux.subscribe("ethusdt@trade", noop)
+	mux.subscribe("bnbusdt@trade", noop)
+	mux.unsubscribe("btcusdt@trade")
+	sender.wait(t, 3)
+
+	// the url brings back the stream dropped since, the others are lost
+	sender.reset()
+	connected()
+	sent := sender.wait(t, 2)
+	if sent := sent[0]; sent["method"] != "UNSUBSCRIBE" || !reflect.DeepEqual(sent["params"], []string{"btcusdt@trade"}) {
+		t.Errorf("got %v, want UNSUBSCRIBE btcusdt@trade", sent)
+	}
+	if sent := sent[1]; sent["method"] != "SUBSCRIBE" || !reflect.DeepEqual(sent["params"], []string{"bnbusdt@trade", "ethusdt@trade"}) {
+		t.Errorf("got %v, want SUBSCRIBE bnbusdt@trade ethusdt@trade", sent)
+	}
+}
+
+func TestStreamMux_Errors(t *testing.T) {
+	var handle func([]byte) error
+	mux := newStreamMux(2, func(stream string, h func([]byte) error, connected func()) streamSender {
+		handle = h
+		return &fakeSender{url: stream}
+	})
+	mux.interval = 0
+	defer mux.close()
+	var reported []error
+	mux.report = func(err error) {
+		reported = append(reported, err)
+	}
+
+	noop := func([]byte) error { return nil }
+	mux.subscribe("btcusdt@trade", noop)
+	mux.subscribe("ethusdt@trade", noop)
+
+	// the SUBSCRIBE for ethusdt@trade went out with id 1
+	if err := handle([]byte(`{"error":{"code":2,"msg":"Invalid request"},"id":1}`)); err != nil {
+		t.Errorf("rejected SUBSCRIBE returned %v", err)
+	}
+	if len(reported) != 1 {
+		t.Fatalf("reported %v, want the rejected stream", reported)
+	}
+	if e, ok := reported[0].(*common.SubscribeError); !ok || e.Channel != "ethusdt@trade" || e.Err.(*common.ServerError).Code != "2" {
+		t.Errorf("reported %v, want ethusdt@trade rejected with code 2", reported[0])
+	}
+
+	if err, ok := handle([]byte(`{"code":3,"msg":"Invalid JSON"}`)).(*common.ServerError); !ok || err.Code != "3" {
+		t.Errorf("got %v, want a server error", err)
+	}
+	if err, ok := handle([]byte(`{"stream":"dogeusdt@trade","data":{}}`)).(*common.UnknownChannelError); !ok || err.Channel != "dogeusdt@trade" {
+		t.Errorf("got %v, want an unknown channel", err)
+	}
+	if err, ok := handle([]byte(`{"stream":`)).(*common.ParseError); !ok || string(err.Raw) != `{"stream":` {
+		t.Errorf("got %v, want a parse error with the payload", err)
+	}
+}
+
+// TestStreamMux_Pace subscribes streams faster than the control messages may
+// go out and checks that neither the subscriptions nor the frames wait for
+// them.
+func TestStreamMux_Pace(t *testing.T) {
+	var (
+		sender *fakeSender
+		handle func([]byte) error
+	)
+	mux := newStreamMux(10, func(stream string, h func([]byte) error, connected func()) streamSender {
+		sender = &fakeSender{url: stream}
+		handle = h
+		return sender
+	})
+	const interval = 50 * time.Millisecond
+	mux.interval = interval
+	defer mux.close()
+
+	noop := func([]byte) error { return nil }
+	start := time.Now()
+	for _, stream := range []string{"btcusdt@trade", "ethusdt@trade", "bnbusdt@trade", "ltcusdt@trade", "xrpusdt@trade"} {
+		if err := mux.subscribe(stream, noop); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= interval {
+		t.Errorf("subscribing took %s, it must not wait for the control messages", elapsed)
+	}
+
+	// frames are routed while the SUBSCRIBEs are still queued
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		if err := handle([]byte(`{"stream":"btcusdt@trade","data":{}}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= interval {
+		t.Errorf("routing took %s, frames are held back by the control messages", elapsed)
+	}
+
+	sender.wait(t, 4)
+	sender.lock.Lock()
+	defer sender.lock.Unlock()
+	for i := 1; i < len(sender.at); i++ {
+		if gap := sender.at[i].Sub(sender.at[i-1]); gap < interval {
+			t.Errorf("message %d sent %s after the previous one, want at least %s", i, gap, interval)
+		}
+	}
+}
@@ -1 +1,31 @@
 package binance
+
+import (
+	"fmt"
+	"github.com/goex-top/goexws/common"
+	. "github.com/nntaoli-project/goex"
+	"strings"
+)
+
+// SwapWs streams the USDⓈ-M perpetual swap markets from fstream.binance.com.
+type SwapWs struct {
+	*baseWs
+}
+
+func NewSwapWs() *SwapWs {
+	swapWs := &SwapWs{baseWs: &baseWs{}}
+	swapWs.baseURL = "wss://fstream.binance.com/ws"
+	swapWs.combinedBaseURL = "wss://fstream.binance.com/stream?streams="
+	swapWs.resolveContract = swapWs.adaptContract
+	swapWs.streams = newStreamMux(futuresMaxStreamsPerConn, swapWs.dialStreams)
+	swapWs.streams.report = swapWs.errs.Report
+	swapWs.dispatcher = common.SerialPerSymbol(0)
+	return swapWs
+}
+
+func (swapWs *SwapWs) adaptContract(pair CurrencyPair, contractType string) (string, string, error) {
+	if contractType != SWAP_CONTRACT {
+		return "", "", fmt.Errorf("unsupported contract type %s in binance swap", contractType)
+	}
+	return strings.ToLower(pair.ToSymbol("")), SWAP_CONTRACT, nil
+}
@@ -1 +1,47 @@
 package binance
+
+import (
+	"github.com/nntaoli-project/goex"
+	"log"
+	"testing"
+	"time"
+)
+
+var swapWs = NewSwapWs()
+
+func init() {
+	swapWs.ProxyUrl("socks5://127.0.0.1:1080")
+	swapWs.SetCallbacks(printfFutureTicker, printfDepth, printfFutureTrade, printfFutureKline)
+}
+
+func printfFutureTicker(ticker *goex.FutureTicker) {
+	log.Println("ticker:", ticker.ContractType, ticker.Ticker)
+}
+
+func printfFutureTrade(trade *goex.Trade, contract string) {
+	log.Println("trade:", contract, trade)
+}
+
+func printfFutureKline(kline *goex.FutureKline, period int, contract string) {
+	log.Println("kline:", contract, period, kline.Kline, kline.Vol2)
+}
+
+func TestSwapWs_SubscribeDepth(t *testing.T) {
+	swapWs.SubscribeDepth(goex.BTC_USDT, 5, goex.SWAP_CONTRACT)
+	time.Sleep(time.Second * 5)
+}
+
+func TestSwapWs_SubscribeTicker(t *testing.T) {
+	swapWs.SubscribeTicker(goex.BTC_USDT, goex.SWAP_CONTRACT)
+	time.Sleep(time.Second * 5)
+}
+
+func TestSwapWs_SubscribeTrade(t *testing.T) {
+	swapWs.SubscribeTrade(goex.BTC_USDT, goex.SWAP_CONTRACT)
+	time.Sleep(time.Second * 5)
+}
+
+func TestSwapWs_SubscribeKline(t *testing.T) {
+	swapWs.SubscribeKline(goex.BTC_USDT, goex.KLINE_PERIOD_1MIN, goex.SWAP_CONTRACT)
+	time.Sleep(time.Second * 5)
+}
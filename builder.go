@@ -1,33 +1,51 @@
 package goexws
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/goex-top/goexws/binance"
 	"github.com/goex-top/goexws/huobi"
 	"github.com/goex-top/goexws/okex"
 )
 
-func SpotBuild(ex string) SpotWsApi {
+var ErrUnknownExchange = errors.New("unknown exchange")
+
+func SpotBuild(ex string) (SpotWsApi, error) {
 	switch ex {
 	case Spot_Binance:
-		return binance.NewSpotWs()
+		return binance.NewSpotWs(), nil
 	case Spot_Huobi:
-		return huobi.NewSpotWs()
+		return huobi.NewSpotWs(), nil
 	case Spot_OKEx:
-		return okex.NewSpotWs()
+		return okex.NewSpotWs(), nil
 	default:
-		return nil
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExchange, ex)
 	}
 }
 
-func FuturesBuild(ex string) FuturesWsApi {
+func FuturesBuild(ex string) (FuturesWsApi, error) {
 	switch ex {
 	case Futures_Binance:
-		return nil
+		return binance.NewFuturesWs(), nil
 	case Futures_Huobi:
-		return huobi.NewFutureWs()
+		return huobi.NewFutureWs(), nil
 	case Futures_OKEx:
-		return okex.NewFuturesWs()
+		return okex.NewFuturesWs(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExchange, ex)
+	}
+}
+
+func SwapBuild(ex string) (SwapWsApi, error) {
+	switch ex {
+	case Swap_Binance:
+		return binance.NewSwapWs(), nil
+	case Swap_Huobi:
+		return huobi.NewSwapWs(), nil
+	case Swap_OKEx:
+		return okex.NewSwapWs(), nil
 	default:
-		return nil
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExchange, ex)
 	}
 }
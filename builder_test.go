@@ -1,7 +1,28 @@
 package goexws
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestBuild(t *testing.T) {
-	SpotBuild(Futures_Binance)
+	for _, ex := range []string{Spot_Binance, Spot_Huobi, Spot_OKEx} {
+		if api, err := SpotBuild(ex); err != nil || api == nil {
+			t.Errorf("SpotBuild(%s): %v", ex, err)
+		}
+	}
+	for _, ex := range []string{Futures_Binance, Futures_Huobi, Futures_OKEx} {
+		if api, err := FuturesBuild(ex); err != nil || api == nil {
+			t.Errorf("FuturesBuild(%s): %v", ex, err)
+		}
+	}
+	for _, ex := range []string{Swap_Binance, Swap_Huobi, Swap_OKEx} {
+		if api, err := SwapBuild(ex); err != nil || api == nil {
+			t.Errorf("SwapBuild(%s): %v", ex, err)
+		}
+	}
+
+	if _, err := SpotBuild(Futures_Binance); !errors.Is(err, ErrUnknownExchange) {
+		t.Errorf("SpotBuild(%s): got %v", Futures_Binance, err)
+	}
 }
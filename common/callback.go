@@ -0,0 +1,22 @@
+package common
+
+import "sync"
+
+// Callback holds a user callback of type F, it may be set while the adapter
+// is calling it.
+type Callback[F any] struct {
+	lock sync.RWMutex
+	call F
+}
+
+func (c *Callback[F]) Set(call F) {
+	c.lock.Lock()
+	c.call = call
+	c.lock.Unlock()
+}
+
+func (c *Callback[F]) Get() F {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.call
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source of the stale feed watchdog, it is swapped for a
+// FakeClock in tests.
+type Clock interface {
+	Now() time.Time
+	// Tick delivers the time every d until stop is called, dropping ticks
+	// for a slow receiver like time.Ticker does.
+	Tick(d time.Duration) (c <-chan time.Time, stop func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// RealClock is the wall clock.
+var RealClock Clock = realClock{}
+
+type fakeTicker struct {
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+// FakeClock only moves when told to, for tests.
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	tickers map[*fakeTicker]bool
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, tickers: make(map[*fakeTicker]bool)}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := &fakeTicker{c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers[t] = true
+	return t.c, func() {
+		c.lock.Lock()
+		delete(c.tickers, t)
+		c.lock.Unlock()
+	}
+}
+
+// Advance moves the clock forward by d and fires the tickers that came due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	for t := range c.tickers {
+		if c.now.Before(t.next) {
+			continue
+		}
+		for !c.now.Before(t.next) {
+			t.next = t.next.Add(t.period)
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrNotConnected is returned when sending on a connection that is down.
+var ErrNotConnected = errors.New("websocket not connected")
+
+// ErrClosed is returned when subscribing on an adapter that was closed.
+var ErrClosed = errors.New("websocket closed")
+
+// DefaultWriteTimeout is how long a write may take before the connection is
+// dropped.
+const DefaultWriteTimeout = 10 * time.Second
+
+type ConnConfig struct {
+	URL      string
+	ProxyURL string
+	// ReconnectInterval is the pause between a drop and the next dial.
+	ReconnectInterval time.Duration
+	// Heartbeat, when set, is sent as a text message every HeartbeatInterval.
+	Heartbeat         func() []byte
+	HeartbeatInterval time.Duration
+	// WriteTimeout bounds every write, a write running past it drops the
+	// connection. It defaults to DefaultWriteTimeout.
+	WriteTimeout time.Duration
+	// Decompress is applied to binary messages.
+	Decompress func([]byte) ([]byte, error)
+	// Handle gets every message, one at a time from the read loop.
+	Handle func(msg []byte) error
+	// OnConnected runs after every successful dial, before the first message
+	// is read, OnDisconnected after the connection dropped.
+	OnConnected    func()
+	OnDisconnected func(err error)
+	// OnEvent, when set, is told about every connect, drop and redial.
+	OnEvent func(event Event)
+	// OnError, when set, gets the messages that could not be decompressed
+	// and the errors returned by Handle.
+	OnError func(err error)
+	// Panics, when set, recovers the panics of Handle, they are reported to
+	// OnError.
+	Panics *Panics
+	// Log gets the lifecycle events and errors, and every frame while its
+	// frame logging is on.
+	Log *Log
+	// Clock drives the stale feed watchdog every WatchInterval, they default
+	// to RealClock and DefaultWatchInterval.
+	Clock         Clock
+	WatchInterval time.Duration
+}
+
+// Conn is a websocket connection that keeps redialing until closed. Unlike
+// goex's WsConn it never panics on a failed dial and knows nothing about
+// subscriptions, replaying them is left to the OnConnected hook.
+type Conn struct {
+	cfg    ConnConfig
+	dialer *websocket.Dialer
+
+	lock sync.Mutex
+	ws   *websocket.Conn
+	// dropErr is the reason the current ws was closed from our side
+	dropErr error
+	// writeLock serializes the writes, they run without lock so that a
+	// stuck write never holds up drop or Close
+	writeLock sync.Mutex
+
+	watchLock sync.Mutex
+	watched   map[string]*watched
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	// ctx aborts a dial in progress on Close, wg is every goroutine of the
+	// connection
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewConn(cfg ConnConfig) *Conn {
+	if cfg.ReconnectInterval <= 0 {
+		cfg.ReconnectInterval = time.Second
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = RealClock
+	}
+	if cfg.WatchInterval <= 0 {
+		cfg.WatchInterval = DefaultWatchInterval
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = DefaultWriteTimeout
+	}
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	if cfg.ProxyURL != "" {
+		if proxy, err := url.Parse(cfg.ProxyURL); err == nil {
+			dialer.Proxy = http.ProxyURL(proxy)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{
+		cfg:     cfg,
+		dialer:  dialer,
+		closed:  make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		watched: make(map[string]*watched),
+	}
+}
+
+// Start dials in the background and keeps the connection up until Close.
+func (c *Conn) Start() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.isClosed() {
+		return
+	}
+	c.wg.Add(1)
+	go c.run()
+}
+
+func (c *Conn) run() {
+	defer c.wg.Done()
+	attempt := 0
+	for {
+		if attempt > 0 {
+			c.emit(Event{Type: Reconnecting, Attempt: attempt})
+		}
+		ws, _, err := c.dialer.DialContext(c.ctx, c.cfg.URL, nil)
+		if err == nil {
+			attempt = 0
+			err = c.serve(ws)
+			if c.isClosed() {
+				return
+			}
+			c.emit(Event{Type: Disconnected, Err: err})
+		}
+		if c.isClosed() {
+			return
+		}
+		if c.cfg.OnDisconnected != nil {
+			c.cfg.OnDisconnected(err)
+		}
+		attempt++
+
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(c.cfg.ReconnectInterval):
+		}
+	}
+}
+
+// serve runs one connection until it drops.
+func (c *Conn) serve(ws *websocket.Conn) error {
+	c.lock.Lock()
+	c.ws = ws
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		c.ws = nil
+		c.dropErr = nil
+		c.lock.Unlock()
+		ws.Close()
+	}()
+
+	if c.isClosed() {
+		return nil
+	}
+	c.emit(Event{Type: Connected})
+	c.resetWatch()
+	if c.cfg.OnConnected != nil {
+		c.cfg.OnConnected()
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	if c.cfg.Heartbeat != nil && c.cfg.HeartbeatInterval > 0 {
+		c.wg.Add(1)
+		go c.heartbeat(stop)
+	}
+	c.wg.Add(1)
+	go c.watch(ws, stop)
+
+	for {
+		msgType, msg, err := ws.ReadMessage()
+		if err != nil {
+			c.lock.Lock()
+			if c.dropErr != nil {
+				err = c.dropErr
+			}
+			c.lock.Unlock()
+			return err
+		}
+		if msgType == websocket.BinaryMessage && c.cfg.Decompress != nil {
+			raw := msg
+			msg, err = c.cfg.Decompress(raw)
+			if err != nil {
+				c.report(&ParseError{Raw: raw, Err: err})
+				continue
+			}
+		}
+		if c.cfg.Log.Frames() {
+			c.cfg.Log.Logger().Debug("websocket recv", "url", c.cfg.URL, "frame", string(msg))
+		}
+		if c.isClosed() {
+			return nil
+		}
+		c.report(c.handle(msg))
+	}
+}
+
+func (c *Conn) handle(msg []byte) (err error) {
+	defer c.cfg.Panics.Recover(&err, msg, nil)
+	return c.cfg.Handle(msg)
+}
+
+func (c *Conn) emit(event Event) {
+	if c.isClosed() {
+		return
+	}
+	event.URL = c.cfg.URL
+	logger := c.cfg.Log.Logger()
+	switch event.Type {
+	case Connected:
+		logger.Info("websocket connected", "url", event.URL)
+	case Disconnected:
+		logger.Warn("websocket disconnected", "url", event.URL, "err", event.Err)
+	case Reconnecting:
+		logger.Info("websocket reconnecting", "url", event.URL, "attempt", event.Attempt)
+	case Stale:
+		logger.Warn("websocket stale", "url", event.URL, "channel", event.Channel, "err", event.Err)
+	}
+	if c.cfg.OnEvent != nil {
+		c.cfg.OnEvent(event)
+	}
+}
+
+func (c *Conn) report(err error) {
+	if err == nil || c.isClosed() {
+		return
+	}
+	c.cfg.Log.Logger().Warn("websocket message", "url", c.cfg.URL, "err", err)
+	if c.cfg.OnError != nil {
+		c.cfg.OnError(err)
+	}
+}
+
+func (c *Conn) heartbeat(stop chan struct{}) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.Send(c.cfg.Heartbeat())
+		}
+	}
+}
+
+// Send writes a text message, it fails with ErrNotConnected while the
+// connection is down. A failed write drops the connection.
+func (c *Conn) Send(msg []byte) error {
+	c.lock.Lock()
+	ws := c.ws
+	c.lock.Unlock()
+	if ws == nil {
+		return ErrNotConnected
+	}
+	if c.cfg.Log.Frames() {
+		c.cfg.Log.Logger().Debug("websocket send", "url", c.cfg.URL, "frame", string(msg))
+	}
+
+	c.writeLock.Lock()
+	ws.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+	err := ws.WriteMessage(websocket.TextMessage, msg)
+	c.writeLock.Unlock()
+	if err != nil {
+		c.drop(ws, err)
+	}
+	return err
+}
+
+func (c *Conn) SendJSON(v interface{}) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(msg)
+}
+
+// drop closes ws if it is still the current connection, err is reported as
+// the cause of the disconnect.
+func (c *Conn) drop(ws *websocket.Conn, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.ws == ws {
+		c.dropErr = err
+		ws.Close()
+	}
+}
+
+func (c *Conn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close drops the connection, stops redialing and waits for the read loop,
+// heartbeat and watchdog to exit. None of the hooks is called once it
+// returns, it must not be called from one of them.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		close(c.closed)
+		if c.ws != nil {
+			c.ws.Close()
+		}
+		c.lock.Unlock()
+		c.cancel()
+	})
+	c.wg.Wait()
+	return nil
+}
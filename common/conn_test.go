@@ -0,0 +1,387 @@
+package common
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/internal/wstest"
+)
+
+func TestConn_Reconnect(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {
+		c.Send("echo " + msg)
+	})
+	defer srv.Close()
+
+	received := make(chan string, 16)
+	connected := make(chan struct{}, 4)
+	disconnected := make(chan error, 4)
+
+	var conn *Conn
+	conn = NewConn(ConnConfig{
+		URL:               srv.URL,
+		ReconnectInterval: 10 * time.Millisecond,
+		Handle: func(msg []byte) error {
+			received <- string(msg)
+			return nil
+		},
+		OnConnected: func() {
+			conn.Send([]byte("hello"))
+			connected <- struct{}{}
+		},
+		OnDisconnected: func(err error) {
+			disconnected <- err
+		},
+	})
+	conn.Start()
+	defer conn.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-connected:
+		case <-time.After(time.Second):
+			t.Fatalf("connection %d not established", i)
+		}
+		select {
+		case msg := <-received:
+			if msg != "echo hello" {
+				t.Errorf("got %q, want echo hello", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no message received")
+		}
+		if i == 0 {
+			srv.Drop()
+			select {
+			case err := <-disconnected:
+				if err == nil {
+					t.Error("disconnected without a cause")
+				}
+			case <-time.After(time.Second):
+				t.Fatal("drop not noticed")
+			}
+		}
+	}
+}
+
+func TestConn_Events(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+
+	events := make(chan Event, 16)
+	conn := NewConn(ConnConfig{
+		URL:               srv.URL,
+		ReconnectInterval: 10 * time.Millisecond,
+		Handle:            func(msg []byte) error { return nil },
+		OnEvent: func(event Event) {
+			events <- event
+		},
+	})
+	conn.Start()
+	defer conn.Close()
+
+	next := func() Event {
+		select {
+		case event := <-events:
+			if event.URL != srv.URL {
+				t.Errorf("event url %s, want %s", event.URL, srv.URL)
+			}
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+		}
+		return Event{}
+	}
+
+	if event := next(); event.Type != Connected {
+		t.Fatalf("got %v, want connected", &event)
+	}
+	// the server goes away for good, every redial fails
+	srv.Drop()
+	srv.Close()
+	if event := next(); event.Type != Disconnected || event.Err == nil {
+		t.Fatalf("got %v, want disconnected with a cause", &event)
+	}
+	for attempt := 1; attempt <= 3; attempt++ {
+		if event := next(); event.Type != Reconnecting || event.Attempt != attempt {
+			t.Fatalf("got %v, want reconnecting attempt %d", &event, attempt)
+		}
+	}
+}
+
+func TestConn_Stale(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	events := make(chan Event, 16)
+	conn := NewConn(ConnConfig{
+		URL:               srv.URL,
+		ReconnectInterval: 10 * time.Millisecond,
+		Handle:            func(msg []byte) error { return nil },
+		OnEvent: func(event Event) {
+			events <- event
+		},
+		Clock: clock,
+	})
+	conn.Watch("depth", 5*time.Second)
+	conn.Watch("trade", 0)
+	conn.Start()
+	defer conn.Close()
+
+	next := func() Event {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+		}
+		return Event{}
+	}
+	if event := next(); event.Type != Connected {
+		t.Fatalf("got %v, want connected", &event)
+	}
+
+	// data keeps coming in
+	for i := 0; i < 10; i++ {
+		clock.Advance(time.Second)
+		conn.Feed("depth")
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("got %v while data is flowing", &event)
+	default:
+	}
+
+	// and stops
+	wstest.Eventually(time.Second, func() bool {
+		clock.Advance(time.Second)
+		return len(events) > 0
+	})
+	event := next()
+	if event.Type != Stale || event.Channel != "depth" {
+		t.Fatalf("got %v, want depth stale", &event)
+	}
+	event = next()
+	if _, ok := event.Err.(*StaleError); event.Type != Disconnected || !ok {
+		t.Fatalf("got %v, want disconnected for the stale feed", &event)
+	}
+	if event := next(); event.Type != Reconnecting || event.Attempt != 1 {
+		t.Fatalf("got %v, want reconnecting", &event)
+	}
+	if event := next(); event.Type != Connected {
+		t.Fatalf("got %v, want connected", &event)
+	}
+}
+
+func TestConn_Close(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+	before := runtime.NumGoroutine()
+
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	var calls int32
+	conn := NewConn(ConnConfig{
+		URL:               srv.URL,
+		Heartbeat:         func() []byte { return []byte("ping") },
+		HeartbeatInterval: time.Millisecond,
+		Handle: func(msg []byte) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				close(handling)
+				<-release
+			}
+			return nil
+		},
+	})
+	conn.Start()
+	if !wstest.Eventually(time.Second, func() bool { return srv.Connected() == 1 }) {
+		t.Fatal("not connected")
+	}
+	srv.Broadcast("1")
+	<-handling
+	srv.Broadcast("2")
+
+	// Close waits for the message being handled
+	closed := make(chan struct{})
+	go func() {
+		conn.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a message was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return")
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("handled %d messages, want 1", n)
+	}
+	if err := conn.Send([]byte("ping")); err != ErrNotConnected {
+		t.Fatalf("send after close got %v", err)
+	}
+	if !wstest.Eventually(time.Second, func() bool { return runtime.NumGoroutine() <= before }) {
+		t.Fatalf("%d goroutines left, want %d", runtime.NumGoroutine(), before)
+	}
+}
+
+func TestConn_Panic(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	received := make(chan string, 4)
+	errs := make(chan error, 4)
+	var panics Panics
+	conn := NewConn(ConnConfig{
+		URL: srv.URL,
+		Handle: func(msg []byte) error {
+			if string(msg) == "boom" {
+				panic("boom")
+			}
+			received <- string(msg)
+			return nil
+		},
+		OnError: func(err error) { errs <- err },
+		Panics:  &panics,
+	})
+	conn.Start()
+	defer conn.Close()
+	if !wstest.Eventually(time.Second, func() bool { return srv.Connected() == 1 }) {
+		t.Fatal("not connected")
+	}
+
+	srv.Broadcast("boom")
+	srv.Broadcast("after")
+	select {
+	case err := <-errs:
+		e, ok := err.(*PanicError)
+		if !ok || e.Value != "boom" || string(e.Raw) != "boom" || len(e.Stack) == 0 {
+			t.Fatalf("got %#v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+	// the connection carries on
+	select {
+	case msg := <-received:
+		if msg != "after" {
+			t.Fatalf("got %q, want after", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing handled after the panic")
+	}
+	if srv.Accepted() != 1 {
+		t.Fatalf("%d connections, want 1", srv.Accepted())
+	}
+}
+
+func TestPanics_Off(t *testing.T) {
+	var panics Panics
+	panics.SetRecover(false)
+	defer func() {
+		if v := recover(); v != "boom" {
+			t.Fatalf("recovered %v, want the panic to go through", v)
+		}
+	}()
+	panics.Run(func(err error) { t.Errorf("reported %v", err) }, nil, nil, func() {
+		panic("boom")
+	})
+}
+
+// TestConn_WriteTimeout writes to a server that stopped reading until the
+// socket buffers are full, the stuck write must neither hold up Close nor
+// outlive WriteTimeout.
+func TestConn_WriteTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) { <-release })
+	defer srv.Close()
+	defer close(release)
+
+	// fill writes until a write fails and hands back the error, progress is
+	// the time of the last write that went through
+	fill := func(conn *Conn, progress *int64) <-chan error {
+		failed := make(chan error, 1)
+		go func() {
+			msg := make([]byte, 1<<20)
+			for {
+				if err := conn.Send(msg); err != nil {
+					failed <- err
+					return
+				}
+				atomic.StoreInt64(progress, time.Now().UnixNano())
+			}
+		}()
+		return failed
+	}
+	start := func(writeTimeout time.Duration, disconnected chan error) *Conn {
+		conn := NewConn(ConnConfig{
+			URL:            srv.URL,
+			Handle:         func(msg []byte) error { return nil },
+			WriteTimeout:   writeTimeout,
+			OnDisconnected: func(err error) { disconnected <- err },
+		})
+		// the server never lets go of the earlier connections
+		accepted := srv.Accepted()
+		conn.Start()
+		if !wstest.Eventually(time.Second, func() bool { return srv.Accepted() > accepted }) {
+			t.Fatal("not connected")
+		}
+		return conn
+	}
+
+	t.Run("Close", func(t *testing.T) {
+		conn := start(0, make(chan error, 1))
+		progress := time.Now().UnixNano()
+		failed := fill(conn, &progress)
+		if !wstest.Eventually(5*time.Second, func() bool {
+			return time.Since(time.Unix(0, atomic.LoadInt64(&progress))) > 200*time.Millisecond
+		}) {
+			t.Fatal("writes never got stuck")
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			conn.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("Close waited for a stuck write")
+		}
+		select {
+		case <-failed:
+		case <-time.After(time.Second):
+			t.Fatal("the stuck write outlived Close")
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		disconnected := make(chan error, 1)
+		conn := start(300*time.Millisecond, disconnected)
+		defer conn.Close()
+		var progress int64
+		select {
+		case err := <-fill(conn, &progress):
+			if err == ErrNotConnected {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("write never timed out")
+		}
+		// the connection is dropped and redialed
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second):
+			t.Fatal("connection kept after a timed out write")
+		}
+	})
+}
@@ -0,0 +1,56 @@
+// Package common holds what the exchange adapters share: typed errors and
+// small helpers every adapter applies the same way.
+package common
+
+import (
+	"fmt"
+
+	"github.com/nntaoli-project/goex"
+)
+
+// DepthSizeError is returned by SubscribeDepth when none of the exchange's
+// depth channels can serve the requested number of levels.
+type DepthSizeError struct {
+	Exchange  string
+	Size      int
+	Supported []int
+}
+
+func (e *DepthSizeError) Error() string {
+	return fmt.Sprintf("%s: unsupported depth size %d, supported sizes are %v", e.Exchange, e.Size, e.Supported)
+}
+
+// DepthChannelSize picks the smallest of the supported native sizes (in
+// ascending order) that holds at least size levels.
+func DepthChannelSize(exchange string, size int, supported []int) (int, error) {
+	if size > 0 {
+		for _, s := range supported {
+			if s >= size {
+				return s, nil
+			}
+		}
+	}
+	return 0, &DepthSizeError{Exchange: exchange, Size: size, Supported: supported}
+}
+
+// TruncateDepth keeps the best size levels of each side. Adapters differ in
+// which way they sort the asks, so the order of each side is kept as is and
+// the levels are cut from whichever end is furthest from the touch.
+func TruncateDepth(dep *goex.Depth, size int) {
+	if size <= 0 {
+		return
+	}
+	dep.BidList = truncateSide(dep.BidList, size, false)
+	dep.AskList = truncateSide(dep.AskList, size, true)
+}
+
+func truncateSide(side goex.DepthRecords, size int, ask bool) goex.DepthRecords {
+	if len(side) <= size {
+		return side
+	}
+	ascending := side[0].Price < side[len(side)-1].Price
+	if ascending == ask {
+		return side[:size]
+	}
+	return side[len(side)-size:]
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nntaoli-project/goex"
+)
+
+func TestDepthChannelSize(t *testing.T) {
+	supported := []int{5, 20, 150}
+	for size, want := range map[int]int{1: 5, 5: 5, 6: 20, 150: 150} {
+		if got, err := DepthChannelSize("huobi", size, supported); err != nil || got != want {
+			t.Errorf("size %d: got %d %v, want %d", size, got, err, want)
+		}
+	}
+
+	for _, size := range []int{0, 151} {
+		_, err := DepthChannelSize("huobi", size, supported)
+		var sizeErr *DepthSizeError
+		if !errors.As(err, &sizeErr) || sizeErr.Size != size || !reflect.DeepEqual(sizeErr.Supported, supported) {
+			t.Errorf("size %d: got %v", size, err)
+		}
+	}
+}
+
+func TestTruncateDepth(t *testing.T) {
+	records := func(prices ...float64) goex.DepthRecords {
+		var r goex.DepthRecords
+		for _, p := range prices {
+			r = append(r, goex.DepthRecord{Price: p, Amount: 1})
+		}
+		return r
+	}
+
+	// asks best first
+	dep := &goex.Depth{BidList: records(10, 9, 8), AskList: records(11, 12, 13)}
+	TruncateDepth(dep, 2)
+	if !reflect.DeepEqual(dep.BidList, records(10, 9)) || !reflect.DeepEqual(dep.AskList, records(11, 12)) {
+		t.Errorf("ascending asks: %v %v", dep.BidList, dep.AskList)
+	}
+
+	// asks in reverse, best last
+	dep = &goex.Depth{BidList: records(10, 9, 8), AskList: records(13, 12, 11)}
+	TruncateDepth(dep, 2)
+	if !reflect.DeepEqual(dep.BidList, records(10, 9)) || !reflect.DeepEqual(dep.AskList, records(12, 11)) {
+		t.Errorf("descending asks: %v %v", dep.BidList, dep.AskList)
+	}
+}
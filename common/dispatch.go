@@ -0,0 +1,142 @@
+package common
+
+import (
+	"hash/fnv"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Dispatcher runs the data callbacks of an adapter, Events and Errors are
+// always called right away from the goroutine that ran into them.
+//
+// key is the route key of the data, SerialPerSymbol keeps the callbacks of
+// every symbol, the key up to the first /, in order. Dispatch may block while
+// the dispatcher is busy, which holds back the socket it was called from.
+type Dispatcher interface {
+	Dispatch(key string, call func())
+	// Close waits for the running callbacks, the calls dispatched afterwards
+	// and those still queued are dropped.
+	Close()
+}
+
+// DefaultDispatchQueue is how many calls a dispatcher queues before
+// Dispatch blocks.
+const DefaultDispatchQueue = 256
+
+// Inline runs every callback right away on the goroutine reading the socket.
+// The callbacks of a socket are serial, but an adapter reading several
+// sockets, like binance with one per stream, calls them concurrently.
+func Inline() Dispatcher {
+	return inline{}
+}
+
+type inline struct{}
+
+func (inline) Dispatch(key string, call func()) { call() }
+func (inline) Close()                           {}
+
+// SerialGlobal runs every callback of the adapter one after the other on a
+// single goroutine.
+func SerialGlobal() Dispatcher {
+	return newQueues(1, 1, nil)
+}
+
+// SerialPerSymbol runs the callbacks of a symbol one after the other, those
+// of different symbols on up to workers goroutines at once. workers defaults
+// to GOMAXPROCS. It is the dispatcher the adapters start with.
+func SerialPerSymbol(workers int) Dispatcher {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return newQueues(workers, workers, func(key string) int {
+		if i := strings.IndexByte(key, '/'); i >= 0 {
+			key = key[:i]
+		}
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return int(h.Sum32() % uint32(workers))
+	})
+}
+
+// WorkerPool runs the callbacks on up to workers goroutines at once in no
+// particular order, even those of one symbol. workers defaults to
+// GOMAXPROCS.
+func WorkerPool(workers int) Dispatcher {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return newQueues(1, workers, nil)
+}
+
+// queues hands the calls to workers goroutines reading from queues channels,
+// worker i reads queue i%len(queues). The goroutines are started by the
+// first Dispatch.
+type queues struct {
+	queues  []chan func()
+	workers int
+	pick    func(key string) int
+
+	start     sync.Once
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func newQueues(n, workers int, pick func(key string) int) *queues {
+	q := &queues{queues: make([]chan func(), n), workers: workers, pick: pick, stop: make(chan struct{})}
+	for i := range q.queues {
+		q.queues[i] = make(chan func(), DefaultDispatchQueue)
+	}
+	return q
+}
+
+func (q *queues) Dispatch(key string, call func()) {
+	select {
+	case <-q.stop:
+		return
+	default:
+	}
+	q.start.Do(func() {
+		for i := 0; i < q.workers; i++ {
+			q.wg.Add(1)
+			go q.work(q.queues[i%len(q.queues)])
+		}
+	})
+
+	queue := q.queues[0]
+	if q.pick != nil {
+		queue = q.queues[q.pick(key)]
+	}
+	select {
+	case queue <- call:
+	case <-q.stop:
+	}
+}
+
+func (q *queues) work(queue chan func()) {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case call := <-queue:
+			// a stop racing with the queue is not run past
+			select {
+			case <-q.stop:
+				return
+			default:
+			}
+			call()
+		}
+	}
+}
+
+func (q *queues) Close() {
+	q.closeOnce.Do(func() {
+		// no worker is started once stop is closed
+		q.start.Do(func() {})
+		close(q.stop)
+	})
+	q.wg.Wait()
+}
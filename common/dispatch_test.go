@@ -0,0 +1,95 @@
+package common
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSerialPerSymbol(t *testing.T) {
+	d := SerialPerSymbol(4)
+	defer d.Close()
+
+	var (
+		lock sync.Mutex
+		got  = map[string][]int{}
+		wg   sync.WaitGroup
+	)
+	wg.Add(200)
+	for i := 0; i < 100; i++ {
+		for _, key := range []string{"BTC_USDT/60", "ETH_USDT"} {
+			i, key := i, key
+			d.Dispatch(key, func() {
+				lock.Lock()
+				got[key] = append(got[key], i)
+				lock.Unlock()
+				wg.Done()
+			})
+		}
+	}
+	wg.Wait()
+
+	want := make([]int, 100)
+	for i := range want {
+		want[i] = i
+	}
+	for key, calls := range got {
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("%s called out of order: %v", key, calls)
+		}
+	}
+}
+
+func TestDispatcher_Close(t *testing.T) {
+	for name, d := range map[string]Dispatcher{
+		"SerialGlobal":    SerialGlobal(),
+		"SerialPerSymbol": SerialPerSymbol(2),
+		"WorkerPool":      WorkerPool(1),
+	} {
+		running := make(chan struct{})
+		release := make(chan struct{})
+		var (
+			lock  sync.Mutex
+			calls int
+		)
+		d.Dispatch("BTC_USDT", func() {
+			close(running)
+			<-release
+			lock.Lock()
+			calls++
+			lock.Unlock()
+		})
+		<-running
+		d.Dispatch("BTC_USDT", func() {
+			lock.Lock()
+			calls++
+			lock.Unlock()
+		})
+
+		// Close waits for the running call and drops the queued one
+		closed := make(chan struct{})
+		go func() {
+			d.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+			t.Fatalf("%s: Close returned while a call was running", name)
+		case <-time.After(20 * time.Millisecond):
+		}
+		close(release)
+		<-closed
+
+		d.Dispatch("BTC_USDT", func() {
+			lock.Lock()
+			calls++
+			lock.Unlock()
+		})
+		lock.Lock()
+		if calls != 1 {
+			t.Errorf("%s: %d calls, want 1", name, calls)
+		}
+		lock.Unlock()
+	}
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ParseError is a message that could not be decoded, Raw is the message as
+// it came off the wire.
+type ParseError struct {
+	Raw []byte
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse %s: %v", e.Raw, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ServerError is an error reply of the exchange.
+type ServerError struct {
+	Code string
+	Msg  string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error %s: %s", e.Code, e.Msg)
+}
+
+// UnknownChannelError is data for a channel nobody subscribed to, or a
+// message the adapter does not know.
+type UnknownChannelError struct {
+	Channel string
+	Raw     []byte
+}
+
+func (e *UnknownChannelError) Error() string {
+	return fmt.Sprintf("unknown channel %q: %s", e.Channel, e.Raw)
+}
+
+// SubscribeError is a subscription the server rejected or never
+// acknowledged, Err is the ServerError or ErrAckTimeout.
+type SubscribeError struct {
+	Channel string
+	Err     error
+}
+
+func (e *SubscribeError) Error() string {
+	return fmt.Sprintf("subscribe %s: %v", e.Channel, e.Err)
+}
+
+func (e *SubscribeError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is a panic recovered from a callback or from the handling of a
+// message. Raw is the message being handled, Data what was handed to the
+// callback when it came from the dispatcher. A panic of a hub consumer has
+// only Data.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+	Raw   []byte
+	Data  interface{}
+}
+
+func (e *PanicError) Error() string {
+	if e.Raw != nil {
+		return fmt.Sprintf("panic handling %s: %v", e.Raw, e.Value)
+	}
+	return fmt.Sprintf("panic in callback with %+v: %v", e.Data, e.Value)
+}
+
+// Errors hands errors to the callback set by the user, if any.
+type Errors struct {
+	lock sync.RWMutex
+	call func(err error)
+}
+
+func (e *Errors) SetCallback(call func(err error)) {
+	e.lock.Lock()
+	e.call = call
+	e.lock.Unlock()
+}
+
+func (e *Errors) Report(err error) {
+	if err == nil {
+		return
+	}
+	e.lock.RLock()
+	call := e.call
+	e.lock.RUnlock()
+	if call != nil {
+		call(err)
+	}
+}
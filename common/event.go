@@ -0,0 +1,85 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+)
+
+type EventType int
+
+const (
+	// Connected is sent after every successful dial.
+	Connected EventType = iota + 1
+	// Disconnected is sent when a connection dropped, Err holds the cause.
+	Disconnected
+	// Reconnecting is sent before every redial, Attempt counts the attempts
+	// since the connection was last up, starting at 1.
+	Reconnecting
+	// Resubscribed is sent once every subscription replayed on a connection
+	// was acknowledged or given up on, Failed lists the latter.
+	Resubscribed
+	// Stale is sent when Channel went silent for too long, the connection
+	// is dropped right after with the same Err.
+	Stale
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Reconnecting:
+		return "reconnecting"
+	case Resubscribed:
+		return "resubscribed"
+	case Stale:
+		return "stale"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a connection lifecycle event.
+type Event struct {
+	Type    EventType
+	URL     string
+	Channel string
+	Attempt int
+	Err     error
+	Failed  []string
+}
+
+func (e *Event) String() string {
+	switch e.Type {
+	case Disconnected, Stale:
+		return fmt.Sprintf("%s %s: %v", e.URL, e.Type, e.Err)
+	case Reconnecting:
+		return fmt.Sprintf("%s %s, attempt %d", e.URL, e.Type, e.Attempt)
+	case Resubscribed:
+		if len(e.Failed) > 0 {
+			return fmt.Sprintf("%s %s, failed %v", e.URL, e.Type, e.Failed)
+		}
+	}
+	return fmt.Sprintf("%s %s", e.URL, e.Type)
+}
+
+// Events hands lifecycle events to the callback set by the user, if any.
+type Events struct {
+	lock sync.RWMutex
+	call func(event *Event)
+}
+
+func (e *Events) SetCallback(call func(event *Event)) {
+	e.lock.Lock()
+	e.call = call
+	e.lock.Unlock()
+}
+
+func (e *Events) Emit(event Event) {
+	e.lock.RLock()
+	call := e.call
+	e.lock.RUnlock()
+	if call != nil {
+		call(&event)
+	}
+}
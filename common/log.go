@@ -0,0 +1,65 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Logger takes a message and alternating key/value pairs, *slog.Logger
+// satisfies it as is.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// NopLogger drops everything, it is what an adapter logs to until told
+// otherwise.
+var NopLogger Logger = nopLogger{}
+
+// Log is the logger of an adapter together with the raw frame switch, both
+// can be changed while connected.
+type Log struct {
+	lock   sync.RWMutex
+	logger Logger
+	frames int32
+}
+
+func (l *Log) SetLogger(logger Logger) {
+	l.lock.Lock()
+	l.logger = logger
+	l.lock.Unlock()
+}
+
+func (l *Log) Logger() Logger {
+	if l == nil {
+		return NopLogger
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.logger == nil {
+		return NopLogger
+	}
+	return l.logger
+}
+
+// SetFrames turns debug logging of every frame sent and received on or off.
+func (l *Log) SetFrames(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&l.frames, v)
+}
+
+func (l *Log) Frames() bool {
+	return l != nil && atomic.LoadInt32(&l.frames) == 1
+}
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// Panics recovers the panics of the callbacks and message handlers of an
+// adapter so that they do not take the connection down, unless recovering
+// was turned off. The zero value recovers.
+type Panics struct {
+	off int32
+}
+
+// SetRecover turns recovering on or off, with it off a panic crashes the
+// program as it would without the adapter in between.
+func (p *Panics) SetRecover(on bool) {
+	var off int32
+	if !on {
+		off = 1
+	}
+	atomic.StoreInt32(&p.off, off)
+}
+
+// Recover stores a recovered panic in err as a PanicError with raw and
+// data, it has to be deferred right where the panic is to be stopped.
+func (p *Panics) Recover(err *error, raw []byte, data interface{}) {
+	if p == nil || atomic.LoadInt32(&p.off) == 1 {
+		return
+	}
+	if v := recover(); v != nil {
+		*err = &PanicError{Value: v, Stack: debug.Stack(), Raw: raw, Data: data}
+	}
+}
+
+// Run calls call and reports a recovered panic with raw and data to report,
+// raw being the frame data was parsed from.
+func (p *Panics) Run(report func(err error), raw []byte, data interface{}, call func()) {
+	var err error
+	defer func() {
+		if err != nil {
+			report(err)
+		}
+	}()
+	defer p.Recover(&err, raw, data)
+	call()
+}
@@ -0,0 +1,14 @@
+//go:build go1.21
+
+package common
+
+import "log/slog"
+
+// SlogLogger logs to logger, slog.Default() if nil. Records carry the
+// websocket url, tag the logger With the exchange to tell adapters apart.
+func SlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
@@ -0,0 +1,81 @@
+//go:build go1.21
+
+package common
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/internal/wstest"
+)
+
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestConn_LogFrames(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {
+		c.Send("echo " + msg)
+	})
+	defer srv.Close()
+
+	var out syncBuffer
+	log := new(Log)
+	log.SetLogger(SlogLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+
+	received := make(chan string, 4)
+	conn := NewConn(ConnConfig{
+		URL: srv.URL,
+		Handle: func(msg []byte) error {
+			received <- string(msg)
+			return nil
+		},
+		Log: log,
+	})
+	conn.Start()
+	defer conn.Close()
+
+	roundTrip := func(msg string) {
+		if !wstest.Eventually(time.Second, func() bool { return conn.Send([]byte(msg)) == nil }) {
+			t.Fatal("not connected")
+		}
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("no echo")
+		}
+	}
+
+	roundTrip("quiet")
+	if !strings.Contains(out.String(), "websocket connected") {
+		t.Errorf("connect not logged: %s", out.String())
+	}
+	if strings.Contains(out.String(), "quiet") {
+		t.Errorf("frame logged while off: %s", out.String())
+	}
+
+	log.SetFrames(true)
+	roundTrip("loud")
+	for _, want := range []string{`msg="websocket send"`, `frame=loud`, `msg="websocket recv"`, `frame="echo loud"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("%s missing from %s", want, out.String())
+		}
+	}
+}
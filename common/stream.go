@@ -0,0 +1,325 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/nntaoli-project/goex"
+)
+
+// Overflow tells a Stream what to do with a value its buffer has no room for.
+type Overflow int
+
+const (
+	// Block waits for the reader, holding up the connection that feeds the
+	// stream and every other subscription on it.
+	Block Overflow = iota
+	// DropOldest throws away the oldest buffered value to make room.
+	DropOldest
+	// DropNewest throws away the value that did not fit.
+	DropNewest
+	// CoalesceLatest keeps only the latest unread value, the reader always
+	// gets the freshest state however slow it is.
+	CoalesceLatest
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case CoalesceLatest:
+		return "coalesce-latest"
+	}
+	return fmt.Sprintf("Overflow(%d)", int(o))
+}
+
+// DefaultStreamBuffer is the buffer of a stream opened without WithBuffer.
+const DefaultStreamBuffer = 64
+
+type streamConfig struct {
+	buffer   int
+	overflow Overflow
+}
+
+type StreamOption func(*streamConfig)
+
+// WithBuffer sets how many values a stream holds for its reader, it is
+// ignored by CoalesceLatest which holds one.
+func WithBuffer(n int) StreamOption {
+	return func(c *streamConfig) {
+		c.buffer = n
+	}
+}
+
+// WithOverflow sets the overflow policy of a stream, DropOldest by default.
+func WithOverflow(o Overflow) StreamOption {
+	return func(c *streamConfig) {
+		c.overflow = o
+	}
+}
+
+// Stream hands pushed values to a channel, it closes the channel once ctx is
+// done or Close is called. Push never blocks past the close.
+type Stream[T any] struct {
+	c         chan T
+	overflow  Overflow
+	lock      sync.Mutex
+	closed    bool
+	stop      chan struct{}
+	closeOnce sync.Once
+	dropped   uint64
+	onClose   func()
+}
+
+func NewStream[T any](ctx context.Context, opts ...StreamOption) *Stream[T] {
+	s := newStream[T](opts...)
+	s.start(ctx)
+	return s
+}
+
+func newStream[T any](opts ...StreamOption) *Stream[T] {
+	cfg := streamConfig{buffer: DefaultStreamBuffer, overflow: DropOldest}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.buffer < 1 || cfg.overflow == CoalesceLatest {
+		cfg.buffer = 1
+	}
+
+	return &Stream[T]{
+		c:        make(chan T, cfg.buffer),
+		overflow: cfg.overflow,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (s *Stream[T]) start(ctx context.Context) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.stop:
+		}
+	}()
+}
+
+func (s *Stream[T]) C() <-chan T {
+	return s.c
+}
+
+// Dropped is the number of values thrown away by the overflow policy.
+func (s *Stream[T]) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Stream[T]) Push(v T) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+
+	switch s.overflow {
+	case Block:
+		select {
+		case s.c <- v:
+		case <-s.stop:
+		}
+	case DropNewest:
+		select {
+		case s.c <- v:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	default:
+		for {
+			select {
+			case s.c <- v:
+				return
+			default:
+			}
+			select {
+			case <-s.c:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	}
+}
+
+// Close closes the channel and runs the close hook, the reader still gets
+// what is buffered.
+func (s *Stream[T]) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.lock.Lock()
+		s.closed = true
+		close(s.c)
+		s.lock.Unlock()
+		if s.onClose != nil {
+			s.onClose()
+		}
+	})
+}
+
+// Fanout hands a value to every func added under its key. The zero value is
+// ready to use.
+type Fanout[T any] struct {
+	lock   sync.RWMutex
+	routes map[string]map[int]route[T]
+	next   int
+	// handlers is the route set by Handle for a key
+	handlers map[string]int
+}
+
+type route[T any] struct {
+	push func(T)
+	// close, if set, is called by Fanout.Close
+	close func()
+}
+
+// Add routes the values emitted under key to fn until remove is called.
+func (f *Fanout[T]) Add(key string, fn func(T)) (remove func()) {
+	return f.add(key, route[T]{push: fn})
+}
+
+func (f *Fanout[T]) add(key string, r route[T]) (remove func()) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id := f.addLocked(key, r)
+	return func() {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		f.removeLocked(key, id)
+	}
+}
+
+// Handle routes the values emitted under key to fn until Unhandle, in place
+// of the fn an earlier Handle of key set.
+func (f *Fanout[T]) Handle(key string, fn func(T)) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if id, ok := f.handlers[key]; ok {
+		f.removeLocked(key, id)
+	}
+	if f.handlers == nil {
+		f.handlers = make(map[string]int)
+	}
+	f.handlers[key] = f.addLocked(key, route[T]{push: fn})
+}
+
+// Unhandle drops the fn set by Handle for key.
+func (f *Fanout[T]) Unhandle(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if id, ok := f.handlers[key]; ok {
+		f.removeLocked(key, id)
+		delete(f.handlers, key)
+	}
+}
+
+func (f *Fanout[T]) addLocked(key string, r route[T]) int {
+	if f.routes == nil {
+		f.routes = make(map[string]map[int]route[T])
+	}
+	if f.routes[key] == nil {
+		f.routes[key] = make(map[int]route[T])
+	}
+	id := f.next
+	f.next++
+	f.routes[key][id] = r
+	return id
+}
+
+func (f *Fanout[T]) removeLocked(key string, id int) {
+	delete(f.routes[key], id)
+	if len(f.routes[key]) == 0 {
+		delete(f.routes, key)
+	}
+}
+
+func (f *Fanout[T]) Emit(key string, v T) {
+	f.lock.RLock()
+	fns := make([]func(T), 0, len(f.routes[key]))
+	for _, r := range f.routes[key] {
+		fns = append(fns, r.push)
+	}
+	f.lock.RUnlock()
+	for _, fn := range fns {
+		fn(v)
+	}
+}
+
+// Close drops every route and closes the streams opened by SubscribeChan.
+func (f *Fanout[T]) Close() {
+	f.lock.Lock()
+	routes := f.routes
+	f.routes, f.handlers = nil, nil
+	f.lock.Unlock()
+
+	for _, byId := range routes {
+		for _, r := range byId {
+			if r.close != nil {
+				r.close()
+			}
+		}
+	}
+}
+
+// SubscribeChan opens a stream fed by the values fanout emits under key and
+// runs subscribe, the stream stops being fed once ctx is done.
+func SubscribeChan[T any](ctx context.Context, fanout *Fanout[T], key string, subscribe func() error, opts ...StreamOption) (<-chan T, error) {
+	stream := newStream[T](opts...)
+	stream.onClose = fanout.add(key, route[T]{push: stream.Push, close: stream.Close})
+	stream.start(ctx)
+	if err := subscribe(); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream.C(), nil
+}
+
+// SubscribeFunc has fanout hand the values emitted under key to fn, in place
+// of the fn of an earlier SubscribeFunc of key, and runs subscribe.
+func SubscribeFunc[T any](fanout *Fanout[T], key string, fn func(T), subscribe func() error) error {
+	fanout.Handle(key, fn)
+	if err := subscribe(); err != nil {
+		fanout.Unhandle(key)
+		return err
+	}
+	return nil
+}
+
+// RouteKey is the key data of pair is emitted under, parts tell contracts
+// and periods apart.
+func RouteKey(pair CurrencyPair, parts ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(pair.ToSymbol("_")))
+	for _, part := range parts {
+		b.WriteByte('/')
+		b.WriteString(fmt.Sprint(part))
+	}
+	return b.String()
+}
+
+// ContractTrade is a trade along with the contract it belongs to, as the
+// trade funcs of the futures adapters get it.
+type ContractTrade struct {
+	Trade    *Trade
+	Contract string
+}
+
+// ContractKline is a kline along with its period and the contract it
+// belongs to, as the kline funcs of the futures adapters get it.
+type ContractKline struct {
+	Kline    *FutureKline
+	Period   int
+	Contract string
+}
@@ -0,0 +1,192 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func drain(c <-chan int) []int {
+	var got []int
+	for {
+		select {
+		case v, ok := <-c:
+			if !ok {
+				return got
+			}
+			got = append(got, v)
+		default:
+			return got
+		}
+	}
+}
+
+func TestStream_Overflow(t *testing.T) {
+	tests := []struct {
+		overflow Overflow
+		want     []int
+		dropped  uint64
+	}{
+		{DropOldest, []int{3, 4, 5}, 2},
+		{DropNewest, []int{1, 2, 3}, 2},
+		{CoalesceLatest, []int{5}, 4},
+	}
+	for _, test := range tests {
+		s := NewStream[int](context.Background(), WithBuffer(3), WithOverflow(test.overflow))
+		for i := 1; i <= 5; i++ {
+			s.Push(i)
+		}
+		if got := drain(s.C()); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.overflow, got, test.want)
+		}
+		if s.Dropped() != test.dropped {
+			t.Errorf("%s: dropped %d, want %d", test.overflow, s.Dropped(), test.dropped)
+		}
+		s.Close()
+	}
+}
+
+func TestStream_Block(t *testing.T) {
+	s := NewStream[int](context.Background(), WithBuffer(1), WithOverflow(Block))
+	defer s.Close()
+	s.Push(1)
+
+	pushed := make(chan struct{})
+	go func() {
+		s.Push(2)
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push did not block on a full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if v := <-s.C(); v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push still blocked after the read")
+	}
+	if v := <-s.C(); v != 2 {
+		t.Fatalf("got %d, want 2", v)
+	}
+}
+
+func TestStream_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewStream[int](ctx, WithBuffer(1), WithOverflow(Block))
+	s.Push(1)
+
+	// a push blocked on the full buffer is let go by the cancel
+	pushed := make(chan struct{})
+	go func() {
+		s.Push(2)
+		close(pushed)
+	}()
+	cancel()
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push still blocked after the cancel")
+	}
+
+	// what was buffered is still read, then the channel is closed
+	got := []int{}
+	for v := range s.C() {
+		got = append(got, v)
+	}
+	if !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("got %v, want [1]", got)
+	}
+	s.Push(3)
+}
+
+func TestSubscribeChan(t *testing.T) {
+	var fanout Fanout[int]
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := SubscribeChan(ctx, &fanout, "btc", func() error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := SubscribeChan(context.Background(), &fanout, "eth", func() error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fanout.Emit("btc", 1)
+	fanout.Emit("eth", 2)
+	if v := <-c; v != 1 {
+		t.Fatalf("btc got %d, want 1", v)
+	}
+	if v := <-other; v != 2 {
+		t.Fatalf("eth got %d, want 2", v)
+	}
+
+	cancel()
+	if _, ok := <-c; ok {
+		t.Fatal("channel open after the cancel")
+	}
+	// the route goes right after the channel is closed
+	routes := func() int {
+		fanout.lock.RLock()
+		defer fanout.lock.RUnlock()
+		return len(fanout.routes)
+	}
+	for deadline := time.Now().Add(time.Second); routes() != 1 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if routes() != 1 {
+		t.Fatalf("%d routes left, want 1", routes())
+	}
+
+	// a failed subscribe leaves no route behind
+	_, err = SubscribeChan(context.Background(), &fanout, "ltc", func() error { return errors.New("refused") })
+	if err == nil {
+		t.Fatal("no error from the failed subscribe")
+	}
+	if routes() != 1 {
+		t.Fatalf("%d routes left, want 1", routes())
+	}
+}
+
+func TestFanout_Handle(t *testing.T) {
+	var fanout Fanout[int]
+	var got []string
+	fanout.Handle("btc", func(v int) { got = append(got, "first") })
+	fanout.Handle("btc", func(v int) { got = append(got, "second") })
+	remove := fanout.Add("btc", func(v int) { got = append(got, "added") })
+	defer remove()
+
+	// the second handler replaced the first, the added func is left alone
+	fanout.Emit("btc", 1)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"added", "second"}) {
+		t.Fatalf("got %v", got)
+	}
+
+	got = nil
+	fanout.Unhandle("btc")
+	fanout.Emit("btc", 2)
+	if !reflect.DeepEqual(got, []string{"added"}) {
+		t.Fatalf("got %v after the unhandle", got)
+	}
+
+	// a failed subscribe drops the handler again
+	err := SubscribeFunc(&fanout, "eth", func(v int) { got = append(got, "eth") }, func() error {
+		return errors.New("refused")
+	})
+	if err == nil {
+		t.Fatal("no error from the failed subscribe")
+	}
+	got = nil
+	fanout.Emit("eth", 3)
+	if len(got) != 0 {
+		t.Fatalf("got %v from a failed subscribe", got)
+	}
+}
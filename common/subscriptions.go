@@ -0,0 +1,312 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrAckTimeout is reported for a subscription the server did not
+// acknowledge within the ack timeout.
+var ErrAckTimeout = errors.New("subscription not acknowledged")
+
+// DefaultAckTimeout is how long a subscription may wait for its ack.
+const DefaultAckTimeout = 10 * time.Second
+
+// Subscriptions is the set of channels an adapter is subscribed to. It owns
+// the subscribe messages, sends them while the connection is up and replays
+// all of them when it comes back, and checks that every one of them is
+// acknowledged by the server within AckTimeout. Channels that were rejected
+// or never acknowledged are handed to the failed callback, and once every
+// channel replayed on a connection is settled the resubscribed callback gets
+// the ones that failed.
+//
+// Connected and Disconnected are meant to be wired to the ConnConfig hooks.
+// The messages are sent without holding the lock, so that a slow write does
+// not hold up the acks coming in on the read loop.
+type Subscriptions struct {
+	send       func(msg interface{}) error
+	ackTimeout time.Duration
+	failed     func(channel string, err error)
+	resubbed   func(failed []string)
+
+	// callLock is held by the ack timers while they call back, Close takes
+	// it to wait for them
+	callLock sync.RWMutex
+
+	lock    sync.Mutex
+	closed  bool
+	live    bool
+	order   []string
+	msgs    map[string]*subscription
+	pending map[string]*time.Timer
+	// replaying holds the channels of the last replay still waiting for
+	// their ack, replayFailed the ones that did not make it
+	replaying    map[string]bool
+	replayFailed []string
+}
+
+// subscription is the message of a channel, it is a pointer so that a
+// failed send can tell whether the channel was subscribed again meanwhile.
+type subscription struct {
+	msg interface{}
+}
+
+func NewSubscriptions(send func(msg interface{}) error) *Subscriptions {
+	return &Subscriptions{
+		send:       send,
+		ackTimeout: DefaultAckTimeout,
+		msgs:       make(map[string]*subscription),
+		pending:    make(map[string]*time.Timer),
+	}
+}
+
+func (s *Subscriptions) SetAckTimeout(ackTimeout time.Duration) {
+	s.lock.Lock()
+	s.ackTimeout = ackTimeout
+	s.lock.Unlock()
+}
+
+// FailedCallback is told about every channel that did not come back.
+func (s *Subscriptions) FailedCallback(call func(channel string, err error)) {
+	s.lock.Lock()
+	s.failed = call
+	s.lock.Unlock()
+}
+
+// ResubscribedCallback is called after every replay, once each replayed
+// channel was acknowledged or failed.
+func (s *Subscriptions) ResubscribedCallback(call func(failed []string)) {
+	s.lock.Lock()
+	s.resubbed = call
+	s.lock.Unlock()
+}
+
+// Subscribe records channel and sends msg if the connection is up, otherwise
+// msg goes out with the replay once it is. A channel whose msg could not be
+// sent is forgotten again, unless the connection dropped and the replay
+// takes care of it.
+func (s *Subscriptions) Subscribe(channel string, msg interface{}) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return ErrClosed
+	}
+
+	prev, ok := s.msgs[channel]
+	if !ok {
+		s.order = append(s.order, channel)
+	}
+	sub := &subscription{msg: msg}
+	s.msgs[channel] = sub
+	if !s.live {
+		s.lock.Unlock()
+		return nil
+	}
+	t := s.watchLocked(channel)
+	s.lock.Unlock()
+
+	err := s.send(msg)
+	if err == nil || err == ErrNotConnected {
+		return err
+	}
+	s.lock.Lock()
+	if s.pending[channel] == t {
+		s.stopLocked(channel)
+	}
+	if s.msgs[channel] == sub {
+		if ok {
+			s.msgs[channel] = prev
+		} else {
+			s.forgetLocked(channel)
+		}
+	}
+	s.lock.Unlock()
+	return err
+}
+
+// Unsubscribe forgets channel and sends msg if it was subscribed. It reports
+// whether the channel was subscribed.
+func (s *Subscriptions) Unsubscribe(channel string, msg interface{}) (bool, error) {
+	s.lock.Lock()
+	if _, ok := s.msgs[channel]; !ok {
+		s.lock.Unlock()
+		return false, nil
+	}
+	s.forgetLocked(channel)
+	s.stopLocked(channel)
+	done := s.settleLocked(channel, false)
+	live := s.live
+	s.lock.Unlock()
+	done()
+	if !live {
+		return true, nil
+	}
+	return true, s.send(msg)
+}
+
+func (s *Subscriptions) Has(channel string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.msgs[channel]
+	return ok
+}
+
+// Channels returns the subscribed channels in subscription order.
+func (s *Subscriptions) Channels() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.order...)
+}
+
+// Connected replays every subscription on the fresh connection.
+func (s *Subscriptions) Connected() {
+	s.lock.Lock()
+	s.live = true
+	s.replaying = make(map[string]bool, len(s.order))
+	s.replayFailed = nil
+	msgs := make([]interface{}, 0, len(s.order))
+	for _, channel := range s.order {
+		s.replaying[channel] = true
+		s.watchLocked(channel)
+		msgs = append(msgs, s.msgs[channel].msg)
+	}
+	// nothing to wait for
+	done := s.settleLocked("", false)
+	s.lock.Unlock()
+	done()
+
+	// a failed send drops the connection, the replay starts over with the
+	// next one
+	for _, msg := range msgs {
+		if s.send(msg) != nil {
+			return
+		}
+	}
+}
+
+// Disconnected holds sends back until the next Connected, acks still pending
+// from the dropped connection are forgotten since everything is replayed.
+func (s *Subscriptions) Disconnected() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.live = false
+	s.replaying = nil
+	for channel := range s.pending {
+		s.stopLocked(channel)
+	}
+}
+
+// Close stops the ack timers and refuses later subscriptions. The callbacks
+// are not called once it returns.
+func (s *Subscriptions) Close() {
+	s.lock.Lock()
+	s.closed = true
+	s.live = false
+	s.replaying = nil
+	for channel := range s.pending {
+		s.stopLocked(channel)
+	}
+	s.lock.Unlock()
+
+	// a timer past the check above is done calling back once this is held
+	s.callLock.Lock()
+	s.callLock.Unlock()
+}
+
+// Ack marks channel as acknowledged by the server.
+func (s *Subscriptions) Ack(channel string) {
+	s.lock.Lock()
+	s.stopLocked(channel)
+	done := s.settleLocked(channel, false)
+	s.lock.Unlock()
+	done()
+}
+
+// Reject reports channel as refused by the server and tells whether it was
+// waiting for its ack. The channel stays registered, it is tried again on
+// the next reconnect.
+func (s *Subscriptions) Reject(channel string, err error) bool {
+	s.lock.Lock()
+	_, ok := s.pending[channel]
+	s.stopLocked(channel)
+	failed := s.failed
+	done := s.settleLocked(channel, ok)
+	s.lock.Unlock()
+
+	if ok && failed != nil {
+		failed(channel, err)
+	}
+	done()
+	return ok
+}
+
+// forgetLocked drops channel from the subscriptions.
+func (s *Subscriptions) forgetLocked(channel string) {
+	delete(s.msgs, channel)
+	for i, ch := range s.order {
+		if ch == channel {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// watchLocked starts the ack timer of channel, whose msg is about to be
+// sent.
+func (s *Subscriptions) watchLocked(channel string) *time.Timer {
+	s.stopLocked(channel)
+	// t is set before the timer can take the lock
+	var t *time.Timer
+	t = time.AfterFunc(s.ackTimeout, func() {
+		s.callLock.RLock()
+		defer s.callLock.RUnlock()
+		s.lock.Lock()
+		ok := !s.closed && s.pending[channel] == t
+		done := func() {}
+		if ok {
+			delete(s.pending, channel)
+			done = s.settleLocked(channel, true)
+		}
+		failed := s.failed
+		s.lock.Unlock()
+
+		if ok && failed != nil {
+			failed(channel, ErrAckTimeout)
+		}
+		done()
+	})
+	s.pending[channel] = t
+	return t
+}
+
+func (s *Subscriptions) stopLocked(channel string) {
+	if t, ok := s.pending[channel]; ok {
+		t.Stop()
+		delete(s.pending, channel)
+	}
+}
+
+// settleLocked takes channel off the running replay and returns the
+// resubscribed notification to run after unlocking once the replay is over.
+func (s *Subscriptions) settleLocked(channel string, failed bool) func() {
+	if s.replaying == nil {
+		return func() {}
+	}
+	if s.replaying[channel] {
+		delete(s.replaying, channel)
+		if failed {
+			s.replayFailed = append(s.replayFailed, channel)
+		}
+	}
+	if len(s.replaying) > 0 {
+		return func() {}
+	}
+	call, failedChannels := s.resubbed, s.replayFailed
+	s.replaying, s.replayFailed = nil, nil
+	if call == nil {
+		return func() {}
+	}
+	return func() { call(failedChannels) }
+}
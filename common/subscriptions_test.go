@@ -0,0 +1,191 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscriptions_Replay(t *testing.T) {
+	var (
+		lock   sync.Mutex
+		sent   []interface{}
+		failed = make(map[string]error)
+	)
+	subs := NewSubscriptions(func(msg interface{}) error {
+		lock.Lock()
+		sent = append(sent, msg)
+		lock.Unlock()
+		return nil
+	})
+	subs.SetAckTimeout(20 * time.Millisecond)
+	subs.FailedCallback(func(channel string, err error) {
+		lock.Lock()
+		failed[channel] = err
+		lock.Unlock()
+	})
+
+	// nothing goes out before the connection is up
+	subs.Subscribe("a", "sub a")
+	subs.Subscribe("b", "sub b")
+	subs.Subscribe("c", "sub c")
+	subs.Unsubscribe("c", "unsub c")
+	if len(sent) != 0 {
+		t.Fatalf("sent %v while disconnected", sent)
+	}
+
+	subs.Connected()
+	subs.Ack("a")
+	subs.Reject("b", ErrNotConnected)
+	if want := []interface{}{"sub a", "sub b"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+
+	// a live subscription goes out right away and has to be acked as well
+	subs.Subscribe("d", "sub d")
+	time.Sleep(50 * time.Millisecond)
+
+	lock.Lock()
+	if len(failed) != 2 || failed["b"] != ErrNotConnected || failed["d"] != ErrAckTimeout {
+		t.Errorf("failed %v, want b rejected and d timed out", failed)
+	}
+	lock.Unlock()
+
+	// everything still registered is replayed on reconnect, acks pending from
+	// the dropped connection are not reported
+	sent = nil
+	subs.Disconnected()
+	subs.Connected()
+	subs.Ack("a")
+	subs.Ack("b")
+	subs.Ack("d")
+	if want := []interface{}{"sub a", "sub b", "sub d"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("replayed %v, want %v", sent, want)
+	}
+	if want := []string{"a", "b", "d"}; !reflect.DeepEqual(subs.Channels(), want) {
+		t.Errorf("channels %v, want %v", subs.Channels(), want)
+	}
+}
+
+func TestSubscriptions_Resubscribed(t *testing.T) {
+	subs := NewSubscriptions(func(msg interface{}) error { return nil })
+	subs.SetAckTimeout(20 * time.Millisecond)
+	resubscribed := make(chan []string, 4)
+	subs.ResubscribedCallback(func(failed []string) {
+		resubscribed <- failed
+	})
+
+	// nothing to replay, done right away
+	subs.Connected()
+	select {
+	case failed := <-resubscribed:
+		if len(failed) != 0 {
+			t.Errorf("failed %v, want none", failed)
+		}
+	default:
+		t.Fatal("no resubscribed after an empty replay")
+	}
+
+	subs.Subscribe("a", "sub a")
+	subs.Subscribe("b", "sub b")
+	subs.Subscribe("c", "sub c")
+	subs.Disconnected()
+	subs.Connected()
+	subs.Ack("a")
+	subs.Unsubscribe("c", "unsub c")
+	select {
+	case failed := <-resubscribed:
+		t.Fatalf("resubscribed %v while b is pending", failed)
+	default:
+	}
+
+	// b times out
+	select {
+	case failed := <-resubscribed:
+		if !reflect.DeepEqual(failed, []string{"b"}) {
+			t.Errorf("failed %v, want [b]", failed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no resubscribed after the ack timeout")
+	}
+
+	// a replay cut short by a drop is not reported
+	subs.Disconnected()
+	time.Sleep(50 * time.Millisecond)
+	if len(resubscribed) != 0 {
+		t.Errorf("resubscribed %v after a drop", <-resubscribed)
+	}
+}
+
+// TestSubscriptions_SlowSend keeps a subscribe stuck in its write, the acks
+// of the other channels must not wait for it.
+func TestSubscriptions_SlowSend(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	subs := NewSubscriptions(func(msg interface{}) error {
+		if msg == "sub slow" {
+			close(entered)
+			<-release
+		}
+		return nil
+	})
+	subs.Subscribe("a", "sub a")
+	subs.Connected()
+
+	done := make(chan error, 1)
+	go func() { done <- subs.Subscribe("slow", "sub slow") }()
+	<-entered
+	acked := make(chan struct{})
+	go func() {
+		subs.Ack("a")
+		close(acked)
+	}()
+	select {
+	case <-acked:
+	case <-time.After(time.Second):
+		t.Fatal("ack held up by a slow send")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	subs.Close()
+}
+
+// TestSubscriptions_SendFailed checks that a subscription whose message
+// could not be sent is rolled back, unless the replay sends it again.
+func TestSubscriptions_SendFailed(t *testing.T) {
+	errSend := errors.New("send failed")
+	var sendErr error
+	subs := NewSubscriptions(func(msg interface{}) error { return sendErr })
+	subs.SetAckTimeout(20 * time.Millisecond)
+	failed := make(chan string, 4)
+	subs.FailedCallback(func(channel string, err error) { failed <- channel })
+	subs.Connected()
+
+	sendErr = errSend
+	if err := subs.Subscribe("a", "sub a"); err != errSend {
+		t.Fatalf("got %v, want %v", err, errSend)
+	}
+	if subs.Has("a") {
+		t.Error("a kept after its send failed")
+	}
+	sendErr = ErrNotConnected
+	if err := subs.Subscribe("b", "sub b"); err != ErrNotConnected {
+		t.Fatalf("got %v, want %v", err, ErrNotConnected)
+	}
+	if !subs.Has("b") {
+		t.Error("b dropped before the replay")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if len(failed) != 1 {
+		t.Fatalf("%d channels failed, want b alone", len(failed))
+	}
+	if channel := <-failed; channel != "b" {
+		t.Errorf("%s failed, want b", channel)
+	}
+	subs.Close()
+}
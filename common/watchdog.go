@@ -0,0 +1,141 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ChannelType groups channels by how often data is expected on them.
+type ChannelType string
+
+const (
+	DepthChannel  ChannelType = "depth"
+	TickerChannel ChannelType = "ticker"
+	TradeChannel  ChannelType = "trade"
+	KlineChannel  ChannelType = "kline"
+)
+
+// DefaultWatchInterval is how often the watchdog looks at the channels.
+const DefaultWatchInterval = time.Second
+
+// StaleError is the cause of a reconnect forced by the watchdog.
+type StaleError struct {
+	Channel string
+	Silence time.Duration
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("no data on %s for %s", e.Channel, e.Silence)
+}
+
+// MaxSilence is how long each type of channel may go without data before
+// its connection is considered dead, 0 turns the check off. Trades are not
+// checked by default, a quiet market has none for minutes.
+type MaxSilence struct {
+	lock sync.Mutex
+	m    map[ChannelType]time.Duration
+}
+
+func NewMaxSilence() *MaxSilence {
+	return &MaxSilence{m: map[ChannelType]time.Duration{
+		DepthChannel:  time.Minute,
+		TickerChannel: time.Minute,
+		KlineChannel:  2 * time.Minute,
+	}}
+}
+
+func (s *MaxSilence) Set(channelType ChannelType, maxSilence time.Duration) {
+	s.lock.Lock()
+	s.m[channelType] = maxSilence
+	s.lock.Unlock()
+}
+
+func (s *MaxSilence) Get(channelType ChannelType) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.m[channelType]
+}
+
+type watched struct {
+	maxSilence time.Duration
+	last       time.Time
+}
+
+// Watch has the connection dropped and redialed whenever channel goes
+// without data for longer than maxSilence, data being reported with Feed.
+// The silence counts from the (re)connect at the earliest.
+func (c *Conn) Watch(channel string, maxSilence time.Duration) {
+	c.watchLock.Lock()
+	defer c.watchLock.Unlock()
+	if maxSilence <= 0 {
+		delete(c.watched, channel)
+		return
+	}
+	c.watched[channel] = &watched{maxSilence: maxSilence, last: c.cfg.Clock.Now()}
+}
+
+func (c *Conn) Unwatch(channel string) {
+	c.watchLock.Lock()
+	delete(c.watched, channel)
+	c.watchLock.Unlock()
+}
+
+// Feed tells the watchdog that data arrived on channel.
+func (c *Conn) Feed(channel string) {
+	c.watchLock.Lock()
+	if w, ok := c.watched[channel]; ok {
+		w.last = c.cfg.Clock.Now()
+	}
+	c.watchLock.Unlock()
+}
+
+// resetWatch restarts the silence of every channel on a fresh connection.
+func (c *Conn) resetWatch() {
+	c.watchLock.Lock()
+	now := c.cfg.Clock.Now()
+	for _, w := range c.watched {
+		w.last = now
+	}
+	c.watchLock.Unlock()
+}
+
+// stale returns the channel silent for the longest past its limit, if any.
+func (c *Conn) stale() *StaleError {
+	c.watchLock.Lock()
+	defer c.watchLock.Unlock()
+
+	now := c.cfg.Clock.Now()
+	var worst *StaleError
+	for channel, w := range c.watched {
+		silence := now.Sub(w.last)
+		if silence <= w.maxSilence {
+			continue
+		}
+		if worst == nil || silence > worst.Silence || silence == worst.Silence && channel < worst.Channel {
+			worst = &StaleError{Channel: channel, Silence: silence}
+		}
+	}
+	return worst
+}
+
+// watch drops ws once a channel went stale.
+func (c *Conn) watch(ws *websocket.Conn, stop chan struct{}) {
+	defer c.wg.Done()
+	tick, stopTick := c.cfg.Clock.Tick(c.cfg.WatchInterval)
+	defer stopTick()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick:
+			if err := c.stale(); err != nil {
+				c.emit(Event{Type: Stale, Channel: err.Channel, Err: err})
+				c.drop(ws, err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package goexws
+
+import (
+	"context"
+	"sync"
+
+	"github.com/goex-top/goexws/common"
+	"github.com/nntaoli-project/goex"
+)
+
+// SpotHub lets any number of consumers attach to the same exchange, pair and
+// channel over one adapter per exchange. The first consumer of a stream
+// subscribes upstream, the last one to detach unsubscribes it.
+//
+// The consumers of a stream are called one after the other in attach order
+// from a goroutine of the stream, a slow consumer holds back the others and
+// the socket behind them. A consumer may still get the value being handed
+// out while it detaches. A panic of a consumer is recovered and handed to the
+// error callback, the other consumers still get the value.
+type SpotHub struct {
+	build func(ex string) (SpotWsApi, error)
+
+	lock   sync.Mutex
+	closed bool
+	apis   map[string]SpotWsApi
+	hooks  hubHooks
+
+	depths  hubStreams[*goex.Depth]
+	tickers hubStreams[*goex.Ticker]
+	trades  hubStreams[*goex.Trade]
+	klines  hubStreams[*goex.Kline]
+}
+
+// NewSpotHub builds the adapter of an exchange with build the first time the
+// exchange is attached to, SpotBuild or a func setting up the adapters.
+func NewSpotHub(build func(ex string) (SpotWsApi, error)) *SpotHub {
+	h := &SpotHub{build: build, apis: make(map[string]SpotWsApi)}
+	h.depths.hooks = &h.hooks
+	h.tickers.hooks = &h.hooks
+	h.trades.hooks = &h.hooks
+	h.klines.hooks = &h.hooks
+	return h
+}
+
+// ErrorCallback gets the panics of the consumers as a *common.PanicError
+// with the value they were called with.
+func (h *SpotHub) ErrorCallback(call func(err error)) {
+	h.hooks.errs.SetCallback(call)
+}
+
+// RecoverPanics turns the recovery of panics in the consumers on or off, it
+// is on by default.
+func (h *SpotHub) RecoverPanics(on bool) {
+	h.hooks.panics.SetRecover(on)
+}
+
+func (h *SpotHub) api(ex string) (SpotWsApi, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return nil, common.ErrClosed
+	}
+	if api, ok := h.apis[ex]; ok {
+		return api, nil
+	}
+	api, err := h.build(ex)
+	if err != nil {
+		return nil, err
+	}
+	h.apis[ex] = api
+	return api, nil
+}
+
+// AttachDepth calls call with the depth of pair on ex until detach is called.
+// The stream is subscribed with the largest size attached so far, each
+// consumer gets its depth cut down to the size it asked for.
+func (h *SpotHub) AttachDepth(ex string, pair goex.CurrencyPair, size int, call func(depth *goex.Depth)) (detach func() error, err error) {
+	api, err := h.api(ex)
+	if err != nil {
+		return nil, err
+	}
+	return h.depths.attach(hubKey(ex, pair), size, truncated(size, call), func(ctx context.Context, size int) (<-chan *goex.Depth, error) {
+		return api.SubscribeDepthChan(ctx, pair, size, common.WithOverflow(common.Block))
+	}, func() error {
+		return api.UnsubscribeDepth(pair)
+	})
+}
+
+func (h *SpotHub) AttachTicker(ex string, pair goex.CurrencyPair, call func(ticker *goex.Ticker)) (detach func() error, err error) {
+	api, err := h.api(ex)
+	if err != nil {
+		return nil, err
+	}
+	return h.tickers.attach(hubKey(ex, pair), 0, call, func(ctx context.Context, _ int) (<-chan *goex.Ticker, error) {
+		return api.SubscribeTickerChan(ctx, pair, common.WithOverflow(common.Block))
+	}, func() error {
+		return api.UnsubscribeTicker(pair)
+	})
+}
+
+func (h *SpotHub) AttachTrade(ex string, pair goex.CurrencyPair, call func(trade *goex.Trade)) (detach func() error, err error) {
+	api, err := h.api(ex)
+	if err != nil {
+		return nil, err
+	}
+	return h.trades.attach(hubKey(ex, pair), 0, call, func(ctx context.Context, _ int) (<-chan *goex.Trade, error) {
+		return api.SubscribeTradeChan(ctx, pair, common.WithOverflow(common.Block))
+	}, func() error {
+		return api.UnsubscribeTrade(pair)
+	})
+}
+
+func (h *SpotHub) AttachKline(ex string, pair goex.CurrencyPair, period int, call func(kline *goex.Kline)) (detach func() error, err error) {
+	api, err := h.api(ex)
+	if err != nil {
+		return nil, err
+	}
+	return h.klines.attach(hubKey(ex, pair, period), 0, call, func(ctx context.Context, _ int) (<-chan *goex.Kline, error) {
+		return api.SubscribeKlineChan(ctx, pair, period, common.WithOverflow(common.Block))
+	}, func() error {
+		return api.UnsubscribeKline(pair, period)
+	})
+}
+
+// Close detaches everyone and closes the adapters.
+func (h *SpotHub) Close() error {
+	h.lock.Lock()
+	h.closed = true
+	apis := h.apis
+	h.apis = nil
+	h.lock.Unlock()
+
+	h.depths.close()
+	h.tickers.close()
+	h.trades.close()
+	h.klines.close()
+	return closeApis(apis)
+}
+
+// FuturesHub is the SpotHub of futures and swaps, its streams are told
+// apart by contract type too.
+type FuturesHub struct {
+	build func(ex string) (FuturesWsApi, error)
+
+	lock   sync.Mutex
+	closed bool
+	apis   map[string]FuturesWsApi
+	hooks  hubHooks
+
+	depths  hubStreams[*goex.Depth]
+	tickers hubStreams[*goex.FutureTicker]
+	trades  hubStreams[*goex.Trade]
+	klines  hubStreams[*goex.FutureKline]
+}
+
+// NewFuturesHub builds the adapter of an exchange with build the first time
+// the exchange is attached to, FuturesBuild or a func setting up the
+// adapters.
+func NewFuturesHub(build func(ex string) (FuturesWsApi, error)) *FuturesHub {
+	h := &FuturesHub{build: build, apis: make(map[string]FuturesWsApi)}
+	h.depths.hooks = &h.hooks
+	h.tickers.hooks = &h.hooks
+	h.trades.hooks = &h.hooks
+	h.klines.hooks = &h.hooks
+	return h
+}
+
+// ErrorCallback gets the panics of the consumers as a *common.PanicError
+// with the value they were called with.
+func (h *FuturesHub) ErrorCallback(call func(err error)) {
+	h.hooks.errs.SetCallback(call)
+}
+
+// RecoverPanics turns the recovery of panics in the consumers on or off, it
+// is on by default.
+func (h *FuturesHub) RecoverPanics(on bool) {
+	h.hooks.panics.SetRecover(on)
+}
+
+func (h *FuturesHub) api(ex string) (FuturesWsApi, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return nil, common.ErrClosed
+	}
+	if api, ok := h.apis[ex]; ok {
+		return api, nil
+	}
+	api, err := h.build(ex)
+	if err != nil {
+		return nil, err
+	}
+	h.apis[ex] = api
+	return api, nil
+}
+
+// AttachDepth calls call with the depth of pair on ex until detach is called.
+// The stream is subscribed with the largest size attached so far, each
+// consumer gets its depth cut down to the size it asked for.
+func (h *FuturesHub) AttachDepth(ex string, pair goex.CurrencyPair, size int, contractType string, call func(depth *goex.Depth)) (detach func() error, err error) {
+	api, err := h.api(ex)
+	if err != nil {
+		return nil, err
+	}
+	return h.depths.attach(hubKey(ex, pair, contractType), size, truncated(size, call), func(ctx context.Context, size int) (<-chan *goex.Depth, error) {
+		return api.SubscribeDepthChan(ctx, pair, size, contractType, common.WithOverflow(common.Block))
+	}, func() error {
+		return api.UnsubscribeDepth(pair, contractType)
+	})
+}
+
+func (h *FuturesHub) AttachTicker(ex string, pair goex.CurrencyPair, contractType string, call func(ticker *goex.FutureTicker)) (detach func() error, err error) {
+	api, err := h.api(ex)
+	if err != nil {
+		return nil, err
+	}
+	return h.tickers.attach(hubKey(ex, pair, contractType), 0, call, func(ctx context.Context, _ int) (<-chan *goex.FutureTicker, error) {
+		return api.SubscribeTickerChan(ctx, pair, contractType, common.WithOverflow(common.Block))
+	}, func() error {
+		return api.UnsubscribeTicker(pair, contractType)
+	})
+}
+
+func (h *FuturesHub) AttachTrade(ex string, pair goex.CurrencyPair, contractType string, call func(trade *goex.Trade)) (detach func() error, err error) {
+	api, err := h.api(ex)
+	if err != nil {
+		return nil, err
+	}
+	return h.trades.attach(hubKey(ex, pair, contractType), 0, call, func(ctx context.Context, _ int) (<-chan *goex.Trade, error) {
+		return api.SubscribeTradeChan(ctx, pair, contractType, common.WithOverflow(common.Block))
+	}, func() error {
+		return api.UnsubscribeTrade(pair, contractType)
+	})
+}
+
+func (h *FuturesHub) AttachKline(ex string, pair goex.CurrencyPair, period int, contractType string, call func(kline *goex.FutureKline)) (detach func() error, err error) {
+	api, err := h.api(ex)
+	if err != nil {
+		return nil, err
+	}
+	return h.klines.attach(hubKey(ex, pair, contractType, period), 0, call, func(ctx context.Context, _ int) (<-chan *goex.FutureKline, error) {
+		return api.SubscribeKlineChan(ctx, pair, period, contractType, common.WithOverflow(common.Block))
+	}, func() error {
+		return api.UnsubscribeKline(pair, period, contractType)
+	})
+}
+
+// Close detaches everyone and closes the adapters.
+func (h *FuturesHub) Close() error {
+	h.lock.Lock()
+	h.closed = true
+	apis := h.apis
+	h.apis = nil
+	h.lock.Unlock()
+
+	h.depths.close()
+	h.tickers.close()
+	h.trades.close()
+	h.klines.close()
+	return closeApis(apis)
+}
+
+// truncated cuts the depth handed to call down to size levels, the depth is
+// shared by the consumers so it is copied first.
+func truncated(size int, call func(depth *goex.Depth)) func(depth *goex.Depth) {
+	return func(depth *goex.Depth) {
+		if len(depth.AskList) <= size && len(depth.BidList) <= size {
+			call(depth)
+			return
+		}
+		dep := *depth
+		common.TruncateDepth(&dep, size)
+		call(&dep)
+	}
+}
+
+func hubKey(ex string, pair goex.CurrencyPair, parts ...interface{}) string {
+	return ex + "/" + common.RouteKey(pair, parts...)
+}
+
+func closeApis[T interface{ Close() error }](apis map[string]T) error {
+	var err error
+	for _, api := range apis {
+		if e := api.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// hubHooks are the error callback and the panic recovery of a hub, shared
+// by all its streams.
+type hubHooks struct {
+	errs   common.Errors
+	panics common.Panics
+}
+
+// hubStreams reference counts the upstream subscriptions of one data type.
+// Attaching to a key nobody is attached to subscribes it, the last detach
+// unsubscribes it again.
+type hubStreams[T any] struct {
+	hooks   *hubHooks
+	lock    sync.Mutex
+	streams map[string]*hubStream[T]
+	next    int
+}
+
+// hubStream is one upstream subscription and the consumers attached to it.
+// size is the depth size it was subscribed with, 0 for the other data.
+type hubStream[T any] struct {
+	hooks       *hubHooks
+	cancel      context.CancelFunc
+	size        int
+	subscribe   func(ctx context.Context, size int) (<-chan T, error)
+	unsubscribe func() error
+
+	lock  sync.RWMutex
+	calls []hubCall[T]
+}
+
+type hubCall[T any] struct {
+	id   int
+	call func(T)
+}
+
+// attach adds call to the stream of key, subscribing it first if it has no
+// consumer yet or subscribing it again if size is larger than the size it
+// has. The lock is held over subscribe and unsubscribe, so that a detach and
+// an attach of the same key do not overtake each other upstream.
+func (h *hubStreams[T]) attach(key string, size int, call func(T), subscribe func(ctx context.Context, size int) (<-chan T, error), unsubscribe func() error) (func() error, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	s, ok := h.streams[key]
+	if !ok {
+		s = &hubStream[T]{hooks: h.hooks, subscribe: subscribe, unsubscribe: unsubscribe}
+		if err := s.start(size); err != nil {
+			return nil, err
+		}
+		if h.streams == nil {
+			h.streams = make(map[string]*hubStream[T])
+		}
+		h.streams[key] = s
+	} else if size > s.size {
+		if err := h.resize(key, s, size); err != nil {
+			return nil, err
+		}
+	}
+
+	h.next++
+	id := h.next
+	s.lock.Lock()
+	s.calls = append(s.calls[:len(s.calls):len(s.calls)], hubCall[T]{id: id, call: call})
+	s.lock.Unlock()
+
+	var once sync.Once
+	return func() error {
+		var err error
+		once.Do(func() { err = h.detach(key, s, id) })
+		return err
+	}, nil
+}
+
+func (h *hubStreams[T]) detach(key string, s *hubStream[T], id int) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	s.lock.Lock()
+	calls := make([]hubCall[T], 0, len(s.calls))
+	for _, c := range s.calls {
+		if c.id != id {
+			calls = append(calls, c)
+		}
+	}
+	s.calls = calls
+	s.lock.Unlock()
+
+	// the stream is gone already if the hub was closed
+	if len(calls) > 0 || h.streams[key] != s {
+		return nil
+	}
+	delete(h.streams, key)
+	s.cancel()
+	return s.unsubscribe()
+}
+
+// resize subscribes s again with size. The consumers attached are moved back
+// to the old size if that fails, the stream is dropped if even that fails.
+func (h *hubStreams[T]) resize(key string, s *hubStream[T], size int) error {
+	if err := s.unsubscribe(); err != nil {
+		return err
+	}
+	s.cancel()
+	err := s.start(size)
+	if err == nil {
+		return nil
+	}
+	if s.start(s.size) != nil {
+		delete(h.streams, key)
+	}
+	return err
+}
+
+// close drops every stream without unsubscribing, the adapters are closed
+// right after.
+func (h *hubStreams[T]) close() {
+	h.lock.Lock()
+	streams := h.streams
+	h.streams = nil
+	h.lock.Unlock()
+	for _, s := range streams {
+		s.cancel()
+	}
+}
+
+// start subscribes the stream with size and hands what it gets to the
+// consumers until canceled.
+func (s *hubStream[T]) start(size int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := s.subscribe(ctx, size)
+	if err != nil {
+		cancel()
+		return err
+	}
+	s.cancel, s.size = cancel, size
+	go s.run(c)
+	return nil
+}
+
+func (s *hubStream[T]) run(c <-chan T) {
+	for v := range c {
+		s.lock.RLock()
+		calls := s.calls
+		s.lock.RUnlock()
+		for _, c := range calls {
+			s.hooks.panics.Run(s.hooks.errs.Report, nil, v, func() {
+				c.call(v)
+			})
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package goexws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/binance"
+	"github.com/goex-top/goexws/common"
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/goex-top/goexws/okex"
+	"github.com/nntaoli-project/goex"
+)
+
+func TestSpotHub(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {
+		var req struct {
+			Op   string   `json:"op"`
+			Args []string `json:"args"`
+		}
+		json.Unmarshal([]byte(msg), &req)
+		for _, ch := range req.Args {
+			c.Send(`{"event":"` + req.Op + `","channel":"` + ch + `"}`)
+		}
+	})
+	defer srv.Close()
+
+	hub := NewSpotHub(func(ex string) (SpotWsApi, error) {
+		api, err := SpotBuild(ex)
+		if err == nil {
+			api.(*okex.SpotWs).SetBaseUrl(srv.URL)
+		}
+		return api, err
+	})
+	defer hub.Close()
+
+	consumer := func() (chan *goex.Ticker, func(*goex.Ticker)) {
+		c := make(chan *goex.Ticker, 16)
+		return c, func(ticker *goex.Ticker) { c <- ticker }
+	}
+	first, call := consumer()
+	detachFirst, err := hub.AttachTicker(Spot_OKEx, goex.BTC_USDT, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, call := consumer()
+	detachSecond, err := hub.AttachTicker(Spot_OKEx, goex.BTC_USDT, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eth, call := consumer()
+	if _, err := hub.AttachTicker(Spot_OKEx, goex.ETH_USDT, call); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(op string) int {
+		n := 0
+		for _, msg := range srv.Received() {
+			var req struct {
+				Op   string   `json:"op"`
+				Args []string `json:"args"`
+			}
+			json.Unmarshal([]byte(msg), &req)
+			for _, ch := range req.Args {
+				if req.Op == op && ch == "spot/ticker:BTC-USDT" {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	if !wstest.Eventually(time.Second, func() bool { return count("subscribe") == 1 }) {
+		t.Fatalf("got %v, want btc subscribed once", srv.Received())
+	}
+
+	ticker := func(last string) {
+		srv.Broadcast(`{"table":"spot/ticker","data":[{"instrument_id":"BTC-USDT","last":"` + last + `"}]}`)
+	}
+	receive := func(name string, c chan *goex.Ticker, last float64) {
+		t.Helper()
+		select {
+		case ticker := <-c:
+			if ticker.Last != last {
+				t.Fatalf("%s got %v, want %v", name, ticker.Last, last)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s got no ticker", name)
+		}
+	}
+	ticker("1")
+	receive("first", first, 1)
+	receive("second", second, 1)
+
+	// the stream stays up while anyone is attached
+	if err := detachFirst(); err != nil {
+		t.Fatal(err)
+	}
+	ticker("2")
+	receive("second", second, 2)
+	if count("unsubscribe") != 0 {
+		t.Fatalf("got %v, btc unsubscribed with a consumer left", srv.Received())
+	}
+	if len(first) != 0 || len(eth) != 0 {
+		t.Fatal("ticker handed to a consumer not attached to it")
+	}
+
+	if err := detachSecond(); err != nil {
+		t.Fatal(err)
+	}
+	if !wstest.Eventually(time.Second, func() bool { return count("unsubscribe") == 1 }) {
+		t.Fatalf("got %v, want btc unsubscribed after the last detach", srv.Received())
+	}
+	// detaching twice does nothing
+	if err := detachSecond(); err != nil || count("unsubscribe") != 1 {
+		t.Fatalf("second detach got %v", err)
+	}
+
+	// a new consumer subscribes again
+	third, call := consumer()
+	if _, err := hub.AttachTicker(Spot_OKEx, goex.BTC_USDT, call); err != nil {
+		t.Fatal(err)
+	}
+	if !wstest.Eventually(time.Second, func() bool { return count("subscribe") == 2 }) {
+		t.Fatalf("got %v, want btc subscribed again", srv.Received())
+	}
+	ticker("3")
+	receive("third", third, 3)
+
+	hub.Close()
+	if _, err := hub.AttachTicker(Spot_OKEx, goex.BTC_USDT, call); err != common.ErrClosed {
+		t.Fatalf("attach after the close got %v", err)
+	}
+	if _, err := NewSpotHub(SpotBuild).AttachTicker(Futures_OKEx, goex.BTC_USDT, call); err == nil {
+		t.Fatal("attached to an unknown exchange")
+	}
+}
+
+// TestSpotHub_Depth attaches a larger depth to a stream subscribed with a
+// smaller one, the stream is subscribed again with the larger size and every
+// consumer gets the size it asked for.
+func TestSpotHub_Depth(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	hub := NewSpotHub(func(ex string) (SpotWsApi, error) {
+		api, err := SpotBuild(ex)
+		if err == nil {
+			api.(*binance.SpotWs).SetCombinedBaseURL(srv.URL + "/stream?streams=")
+		}
+		return api, err
+	})
+	defer hub.Close()
+
+	consumer := func() (chan *goex.Depth, func(*goex.Depth)) {
+		c := make(chan *goex.Depth, 16)
+		return c, func(depth *goex.Depth) { c <- depth }
+	}
+	small, call := consumer()
+	if _, err := hub.AttachDepth(Spot_Binance, goex.BTC_USDT, 5, call); err != nil {
+		t.Fatal(err)
+	}
+	large, call := consumer()
+	if _, err := hub.AttachDepth(Spot_Binance, goex.BTC_USDT, 20, call); err != nil {
+		t.Fatal(err)
+	}
+	// no resubscribe for a size the stream covers
+	medium, call := consumer()
+	if _, err := hub.AttachDepth(Spot_Binance, goex.BTC_USDT, 10, call); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := func(method, stream string) bool {
+		for _, msg := range srv.Received() {
+			var req struct {
+				Method string   `json:"method"`
+				Params []string `json:"params"`
+			}
+			json.Unmarshal([]byte(msg), &req)
+			if req.Method == method && len(req.Params) == 1 && req.Params[0] == stream {
+				return true
+			}
+		}
+		return false
+	}
+	if !wstest.Eventually(2*time.Second, func() bool {
+		return sent("UNSUBSCRIBE", "btcusdt@depth5@100ms") && sent("SUBSCRIBE", "btcusdt@depth20@100ms")
+	}) {
+		t.Fatalf("got %v, want the depth subscribed again with 20 levels", srv.Received())
+	}
+	if sent("SUBSCRIBE", "btcusdt@depth10@100ms") {
+		t.Fatalf("got %v, subscribed again for a smaller size", srv.Received())
+	}
+
+	var levels []string
+	for i := 0; i < 20; i++ {
+		levels = append(levels, fmt.Sprintf(`["%d","1"]`, 100+i))
+	}
+	srv.Broadcast(`{"stream":"btcusdt@depth20@100ms","data":{"lastUpdateId":1,` +
+		`"bids":[` + strings.Join(levels, ",") + `],"asks":[` + strings.Join(levels, ",") + `]}}`)
+	for name, want := range map[string]struct {
+		c    chan *goex.Depth
+		size int
+	}{"small": {small, 5}, "medium": {medium, 10}, "large": {large, 20}} {
+		select {
+		case depth := <-want.c:
+			if len(depth.AskList) != want.size || len(depth.BidList) != want.size {
+				t.Errorf("%s got %d asks and %d bids, want %d", name, len(depth.AskList), len(depth.BidList), want.size)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s got no depth", name)
+		}
+	}
+}
+
+// TestSpotHub_Panic checks that a panicking consumer is reported and holds
+// back neither the other consumers nor the next values.
+func TestSpotHub_Panic(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	hub := NewSpotHub(func(ex string) (SpotWsApi, error) {
+		api, err := SpotBuild(ex)
+		if err == nil {
+			api.(*binance.SpotWs).SetCombinedBaseURL(srv.URL + "/stream?streams=")
+		}
+		return api, err
+	})
+	defer hub.Close()
+	errs := make(chan error, 4)
+	hub.ErrorCallback(func(err error) { errs <- err })
+
+	if _, err := hub.AttachTicker(Spot_Binance, goex.BTC_USDT, func(ticker *goex.Ticker) {
+		if ticker.Last == 1 {
+			panic("boom")
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tickers := make(chan *goex.Ticker, 4)
+	if _, err := hub.AttachTicker(Spot_Binance, goex.BTC_USDT, func(ticker *goex.Ticker) {
+		tickers <- ticker
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !wstest.Eventually(time.Second, func() bool { return srv.Connected() == 1 }) {
+		t.Fatal("not connected")
+	}
+
+	ticker := func(last string) {
+		srv.Broadcast(`{"stream":"btcusdt@ticker","data":{"e":"24hrTicker","c":"` + last + `"}}`)
+	}
+	ticker("1")
+	ticker("2")
+	select {
+	case err := <-errs:
+		e, ok := err.(*common.PanicError)
+		if !ok || e.Value != "boom" || len(e.Stack) == 0 {
+			t.Fatalf("got %#v", err)
+		}
+		if ticker, ok := e.Data.(*goex.Ticker); !ok || ticker.Last != 1 {
+			t.Fatalf("panic reported with %#v, want the ticker", e.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+	for _, want := range []float64{1, 2} {
+		select {
+		case ticker := <-tickers:
+			if ticker.Last != want {
+				t.Fatalf("got %v, want %v", ticker.Last, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no ticker %v", want)
+		}
+	}
+}
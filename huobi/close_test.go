@@ -0,0 +1,93 @@
+package huobi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goex-top/goexws/common"
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/nntaoli-project/goex"
+)
+
+func TestSpotWs_Close(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	ws := NewSpotWs()
+	ws.SetBaseUrl(srv.URL)
+	calls := make(chan struct{}, 16)
+	ws.TickerCallback(func(ticker *goex.Ticker) { calls <- struct{}{} })
+	var trades <-chan *goex.Trade
+	wstest.CheckTeardown(t, srv, wstest.Teardown{
+		Subscribe: func() (err error) {
+			if err = ws.SubscribeTicker(goex.BTC_USDT); err != nil {
+				return err
+			}
+			trades, err = ws.SubscribeTradeChan(context.Background(), goex.BTC_USDT)
+			return err
+		},
+		Conns:  1,
+		Frames: []string{`{"ch":"market.btcusdt.detail","ts":1,"tick":{"close":1}}`},
+		Calls:  calls,
+		Close:  ws.Close,
+		Closed: func() error {
+			return wstest.Closed(trades, ws.SubscribeTicker(goex.ETH_USDT), common.ErrClosed)
+		},
+	})
+}
+
+func TestFuturesWs_Close(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	ws := NewFutureWs()
+	ws.SetBaseUrl(srv.URL)
+	calls := make(chan struct{}, 16)
+	ws.TickerCallback(func(ticker *goex.FutureTicker) { calls <- struct{}{} })
+	var trades <-chan *goex.Trade
+	wstest.CheckTeardown(t, srv, wstest.Teardown{
+		Subscribe: func() (err error) {
+			if err = ws.SubscribeTicker(goex.BTC_USD, goex.QUARTER_CONTRACT); err != nil {
+				return err
+			}
+			trades, err = ws.SubscribeTradeChan(context.Background(), goex.BTC_USD, goex.QUARTER_CONTRACT)
+			return err
+		},
+		Conns:  1,
+		Frames: []string{`{"ch":"market.BTC_CQ.detail","ts":1,"tick":{"close":1}}`},
+		Calls:  calls,
+		Close:  ws.Close,
+		Closed: func() error {
+			return wstest.Closed(trades, ws.SubscribeTicker(goex.ETH_USD, goex.QUARTER_CONTRACT), common.ErrClosed)
+		},
+	})
+}
+
+// TestSwapWs_Close has the swap adapter open both its coin and its USDT
+// margined connection.
+func TestSwapWs_Close(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	ws := NewSwapWs()
+	ws.SetBaseUrl(srv.URL)
+	calls := make(chan struct{}, 16)
+	ws.TickerCallback(func(ticker *goex.FutureTicker) { calls <- struct{}{} })
+	var trades <-chan *goex.Trade
+	wstest.CheckTeardown(t, srv, wstest.Teardown{
+		Subscribe: func() (err error) {
+			if err = ws.SubscribeTicker(goex.BTC_USD, goex.SWAP_CONTRACT); err != nil {
+				return err
+			}
+			trades, err = ws.SubscribeTradeChan(context.Background(), goex.BTC_USDT, goex.SWAP_CONTRACT)
+			return err
+		},
+		Conns:  2,
+		Frames: []string{`{"ch":"market.BTC-USD.detail","ts":1,"tick":{"close":1}}`},
+		Calls:  calls,
+		Close:  ws.Close,
+		Closed: func() error {
+			return wstest.Closed(trades, ws.SubscribeTicker(goex.ETH_USDT, goex.SWAP_CONTRACT), common.ErrClosed)
+		},
+	})
+}
@@ -0,0 +1,184 @@
+package huobi
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/goex-top/goexws/common"
+	. "github.com/nntaoli-project/goex"
+)
+
+// marketConn is one Huobi market socket together with its subscriptions. It
+// answers the server pings, replays the subscriptions after a reconnect and
+// matches the subbed replies against them, everything else is passed on to
+// handle. Subscriptions are sent with the channel as id, so that an error
+// reply can be traced back to its channel.
+type marketConn struct {
+	wsURL    string
+	proxyUrl string
+	once     sync.Once
+	conn     *common.Conn
+	subs     *common.Subscriptions
+	handle   func(raw []byte, resp WsResponse) error
+	hooks    *hooks
+	clock    common.Clock
+
+	// onDisconnected lets the adapter drop state tied to the connection
+	onDisconnected func()
+}
+
+// hooks are the user callbacks and settings shared by the connections of an
+// adapter.
+type hooks struct {
+	events     common.Events
+	errs       common.Errors
+	log        common.Log
+	panics     common.Panics
+	maxSilence *common.MaxSilence
+
+	failedLock sync.Mutex
+	failed     func(channel string, err error)
+}
+
+func newHooks() *hooks {
+	return &hooks{maxSilence: common.NewMaxSilence()}
+}
+
+func (h *hooks) setFailed(call func(channel string, err error)) {
+	h.failedLock.Lock()
+	h.failed = call
+	h.failedLock.Unlock()
+}
+
+// subscribeFailed reports channel to both the error and the failed callback.
+func (h *hooks) subscribeFailed(channel string, err error) {
+	h.errs.Report(&common.SubscribeError{Channel: channel, Err: err})
+	h.failedLock.Lock()
+	call := h.failed
+	h.failedLock.Unlock()
+	if call != nil {
+		call(channel, err)
+	}
+}
+
+func newMarketConn(wsURL string, hooks *hooks, handle func(raw []byte, resp WsResponse) error) *marketConn {
+	c := &marketConn{wsURL: wsURL, hooks: hooks, handle: handle}
+	c.subs = common.NewSubscriptions(func(msg interface{}) error {
+		return c.conn.SendJSON(msg)
+	})
+	c.subs.FailedCallback(hooks.subscribeFailed)
+	c.subs.ResubscribedCallback(func(failed []string) {
+		c.hooks.events.Emit(common.Event{Type: common.Resubscribed, URL: c.wsURL, Failed: failed})
+	})
+	return c
+}
+
+func (c *marketConn) connect() {
+	c.once.Do(func() {
+		c.conn = common.NewConn(common.ConnConfig{
+			URL:               c.wsURL,
+			ProxyURL:          c.proxyUrl,
+			ReconnectInterval: time.Second,
+			Decompress:        GzipDecompress,
+			Handle:            c.receive,
+			OnConnected:       c.subs.Connected,
+			OnDisconnected: func(err error) {
+				c.subs.Disconnected()
+				if c.onDisconnected != nil {
+					c.onDisconnected()
+				}
+			},
+			OnEvent: c.hooks.events.Emit,
+			OnError: c.hooks.errs.Report,
+			Log:     &c.hooks.log,
+			Panics:  &c.hooks.panics,
+			Clock:   c.clock,
+		})
+		c.conn.Start()
+	})
+}
+
+// close closes the socket and stops the ack timers, no connection is made
+// afterwards.
+func (c *marketConn) close() error {
+	c.once.Do(func() {})
+	var err error
+	if c.conn != nil {
+		err = c.conn.Close()
+	}
+	c.subs.Close()
+	return err
+}
+
+func (c *marketConn) subscribe(ch string) error {
+	c.connect()
+	if c.conn == nil {
+		return common.ErrClosed
+	}
+	c.conn.Watch(ch, c.hooks.maxSilence.Get(channelType(ch)))
+	err := c.subs.Subscribe(ch, map[string]interface{}{
+		"id":  ch,
+		"sub": ch})
+	// a connection that just dropped replays it on the way back
+	if err == common.ErrNotConnected {
+		return nil
+	}
+	return err
+}
+
+// unsubscribe unsubs ch if it is subscribed and reports whether it was.
+func (c *marketConn) unsubscribe(ch string) (bool, error) {
+	if c.conn != nil {
+		c.conn.Unwatch(ch)
+	}
+	ok, err := c.subs.Unsubscribe(ch, map[string]interface{}{
+		"id":    ch,
+		"unsub": ch})
+	if err == common.ErrNotConnected {
+		return ok, nil
+	}
+	return ok, err
+}
+
+func (c *marketConn) send(v interface{}) error {
+	return c.conn.SendJSON(v)
+}
+
+func (c *marketConn) receive(msg []byte) error {
+	//心跳
+	if bytes.Contains(msg, []byte("ping")) {
+		pong := bytes.ReplaceAll(msg, []byte("ping"), []byte("pong"))
+		c.conn.Send(pong)
+		return nil
+	}
+
+	var resp WsResponse
+	err := json.Unmarshal(msg, &resp)
+	if err != nil {
+		return &common.ParseError{Raw: msg, Err: err}
+	}
+
+	if resp.Ch != "" {
+		c.conn.Feed(resp.Ch)
+	}
+
+	switch {
+	case resp.Subbed != "":
+		c.subs.Ack(resp.Subbed)
+		return nil
+	case resp.Unsubbed != "":
+		return nil
+	case resp.Status == "error" && resp.Rep == "":
+		// a refused subscription carries its channel as id, anything else
+		// is up to the adapter
+		if c.subs.Reject(resp.Id, &common.ServerError{Code: resp.ErrCode, Msg: resp.ErrMsg}) {
+			return nil
+		}
+	}
+	err = c.handle(msg, resp)
+	if e, ok := err.(*common.UnknownChannelError); ok && e.Raw == nil {
+		e.Raw = msg
+	}
+	return err
+}
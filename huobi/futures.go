@@ -1,142 +1,435 @@
 package huobi
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/goex-top/goexws/common"
 	. "github.com/nntaoli-project/goex"
 	"strings"
-	"sync"
 	"time"
 )
 
 type FuturesWs struct {
-	*WsBuilder
-	sync.Once
-	wsConn *WsConn
+	conn  *marketConn
+	hooks *hooks
 
-	tickerCallback func(*FutureTicker)
-	depthCallback  func(*Depth)
-	tradeCallback  func(*Trade, string)
-	klineCallback  func(*FutureKline, int, string)
+	tickerCallback common.Callback[func(*FutureTicker)]
+	depthCallback  common.Callback[func(*Depth)]
+	depthSizes     depthSizes
+	tradeCallback  common.Callback[func(*Trade, string)]
+	klineCallback  common.Callback[func(*FutureKline, int, string)]
+	dispatcher     common.Dispatcher
+	depthRoutes    common.Fanout[*Depth]
+	tickerRoutes   common.Fanout[*FutureTicker]
+	tradeRoutes    common.Fanout[*Trade]
+	klineRoutes    common.Fanout[*FutureKline]
+	tradeFuncs     common.Fanout[common.ContractTrade]
+	klineFuncs     common.Fanout[common.ContractKline]
 }
 
 func NewFutureWs() *FuturesWs {
-	ws := &FuturesWs{WsBuilder: NewWsBuilder()}
-	ws.WsBuilder = ws.WsBuilder.
-		WsUrl("wss://api.hbdm.com/ws").
-		AutoReconnect().
-		//Heartbeat([]byte("{\"event\": \"ping\"} "), 30*time.Second).
-		//Heartbeat(func() []byte { return []byte("{\"op\":\"ping\"}") }(), 5*time.Second).
-		DecompressFunc(GzipDecompress).
-		ProtoHandleFunc(ws.handle)
+	ws := &FuturesWs{hooks: newHooks()}
+	ws.conn = newMarketConn("wss://api.hbdm.com/ws", ws.hooks, ws.handle)
+	ws.dispatcher = common.SerialPerSymbol(0)
 	return ws
 }
 
+// SetBaseUrl sets the websocket url, it has to be called before subscribing.
+func (ws *FuturesWs) SetBaseUrl(baseURL string) {
+	ws.conn.wsURL = baseURL
+}
+
+func (ws *FuturesWs) ProxyUrl(proxyUrl string) {
+	ws.conn.proxyUrl = proxyUrl
+}
+
+// SetDispatcher sets how the data callbacks are run, they are serial per
+// symbol by default. It has to be called before subscribing.
+func (ws *FuturesWs) SetDispatcher(dispatcher common.Dispatcher) {
+	ws.dispatcher = dispatcher
+}
+
+// Close closes the connection and the subscription channels, no callback is
+// called once it returns. It must not be called from a callback.
+func (ws *FuturesWs) Close() error {
+	err := ws.conn.close()
+	ws.dispatcher.Close()
+	ws.depthRoutes.Close()
+	ws.tickerRoutes.Close()
+	ws.tradeRoutes.Close()
+	ws.klineRoutes.Close()
+	ws.tradeFuncs.Close()
+	ws.klineFuncs.Close()
+	return err
+}
+
+// SubscribeFailedCallback is told about every channel the server rejected or
+// did not acknowledge in time, be it on the first subscription or on the
+// replay after a reconnect.
+func (ws *FuturesWs) SubscribeFailedCallback(call func(channel string, err error)) {
+	ws.hooks.setFailed(call)
+}
+
+// ErrorCallback gets the messages that could not be parsed or handled, the
+// error replies of the server and the failed subscriptions.
+func (ws *FuturesWs) ErrorCallback(call func(err error)) {
+	ws.hooks.errs.SetCallback(call)
+}
+
+// SetLogger sets where the connection lifecycle and the message errors are
+// logged, nothing is logged by default.
+func (ws *FuturesWs) SetLogger(logger common.Logger) {
+	ws.hooks.log.SetLogger(logger)
+}
+
+// LogFrames turns debug logging of every raw frame sent and received on or
+// off, it takes effect right away.
+func (ws *FuturesWs) LogFrames(on bool) {
+	ws.hooks.log.SetFrames(on)
+}
+
+// RecoverPanics turns the recovery of panics in the callbacks and message
+// handling on or off, it is on by default. A recovered panic is handed to the
+// error callback as a *common.PanicError and the connection stays up.
+func (ws *FuturesWs) RecoverPanics(on bool) {
+	ws.hooks.panics.SetRecover(on)
+}
+
+// LifecycleCallback is told whenever the connection comes up, drops, is
+// redialed and has its subscriptions restored.
+func (ws *FuturesWs) LifecycleCallback(call func(event *common.Event)) {
+	ws.hooks.events.SetCallback(call)
+}
+
+// SetMaxSilence sets how long channels of channelType may go without data
+// before the connection is dropped and redialed, 0 turns the check off. It
+// applies to the channels subscribed afterwards.
+func (ws *FuturesWs) SetMaxSilence(channelType common.ChannelType, maxSilence time.Duration) {
+	ws.hooks.maxSilence.Set(channelType, maxSilence)
+}
+
 func (ws *FuturesWs) SetCallbacks(tickerCallback func(*FutureTicker),
 	depthCallback func(*Depth),
 	tradeCallback func(*Trade, string)) {
-	ws.tickerCallback = tickerCallback
-	ws.depthCallback = depthCallback
-	ws.tradeCallback = tradeCallback
+	ws.tickerCallback.Set(tickerCallback)
+	ws.depthCallback.Set(depthCallback)
+	ws.tradeCallback.Set(tradeCallback)
 }
 
 func (ws *FuturesWs) TickerCallback(call func(ticker *FutureTicker)) {
-	ws.tickerCallback = call
+	ws.tickerCallback.Set(call)
 }
 
 func (ws *FuturesWs) TradeCallback(call func(trade *Trade, contract string)) {
-	ws.tradeCallback = call
+	ws.tradeCallback.Set(call)
 }
 
 func (ws *FuturesWs) DepthCallback(call func(depth *Depth)) {
-	ws.depthCallback = call
+	ws.depthCallback.Set(call)
 }
 
 func (ws *FuturesWs) KlineCallback(call func(*FutureKline, int, string)) {
-	ws.klineCallback = call
+	ws.klineCallback.Set(call)
 }
 func (ws *FuturesWs) SubscribeTicker(pair CurrencyPair, contract string) error {
-	if ws.tickerCallback == nil {
+	if ws.tickerCallback.Get() == nil {
 		return errors.New("please set ticker callback func")
 	}
-	return ws.subscribe(map[string]interface{}{
-		"id":  "ticker_1",
-		"sub": fmt.Sprintf("market.%s_%s.detail", pair.CurrencyA.Symbol, ws.adaptContractSymbol(contract))})
+	return ws.subscribeTicker(pair, contract)
+}
+
+func (ws *FuturesWs) subscribeTicker(pair CurrencyPair, contract string) error {
+	if err := ws.checkContract(contract); err != nil {
+		return err
+	}
+	return ws.subscribe(fmt.Sprintf("market.%s_%s.detail", pair.CurrencyA.Symbol, ws.adaptContractSymbol(contract)))
 }
 
 func (ws *FuturesWs) SubscribeDepth(pair CurrencyPair, size int, contract string) error {
-	if ws.depthCallback == nil {
+	if ws.depthCallback.Get() == nil {
 		return errors.New("please set depth callback func")
 	}
-	return ws.subscribe(map[string]interface{}{
-		"id":  "futures.depth",
-		"sub": fmt.Sprintf("market.%s_%s.depth.size_20.high_freq", pair.CurrencyA.Symbol, ws.adaptContractSymbol(contract))})
+	return ws.subscribeDepth(pair, size, contract)
+}
+
+func (ws *FuturesWs) subscribeDepth(pair CurrencyPair, size int, contract string) error {
+	if err := ws.checkContract(contract); err != nil {
+		return err
+	}
+	channelSize, err := common.DepthChannelSize("huobi", size, futuresDepthSizes)
+	if err != nil {
+		return err
+	}
+
+	ch := fmt.Sprintf("market.%s_%s.depth.size_%d.high_freq", pair.CurrencyA.Symbol, ws.adaptContractSymbol(contract), channelSize)
+	ws.depthSizes.set(ch, size)
+	return ws.subscribe(ch)
 }
 
 func (ws *FuturesWs) SubscribeKline(pair CurrencyPair, period int, contractType string) error {
-	return nil
+	if ws.klineCallback.Get() == nil {
+		return errors.New("place set kline callback func")
+	}
+	return ws.subscribeKline(pair, period, contractType)
+}
+
+func (ws *FuturesWs) subscribeKline(pair CurrencyPair, period int, contractType string) error {
+	if err := ws.checkContract(contractType); err != nil {
+		return err
+	}
+	periodS, isOk := _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	if !isOk || period == KLINE_PERIOD_1YEAR {
+		return fmt.Errorf("unsupported kline period %d in huobi futures", period)
+	}
+	return ws.subscribe(fmt.Sprintf("market.%s_%s.kline.%s", pair.CurrencyA.Symbol, ws.adaptContractSymbol(contractType), periodS))
 }
 
 func (ws *FuturesWs) SubscribeTrade(pair CurrencyPair, contract string) error {
-	if ws.tradeCallback == nil {
+	if ws.tradeCallback.Get() == nil {
 		return errors.New("please set trade callback func")
 	}
-	return ws.subscribe(map[string]interface{}{
-		"id":  "trade_3",
-		"sub": fmt.Sprintf("market.%s_%s.trade.detail", pair.CurrencyA.Symbol, ws.adaptContractSymbol(contract))})
+	return ws.subscribeTrade(pair, contract)
 }
 
-func (ws *FuturesWs) subscribe(sub map[string]interface{}) error {
-	//	log.Println(sub)
-	ws.connectWs()
-	return ws.wsConn.Subscribe(sub)
+func (ws *FuturesWs) subscribeTrade(pair CurrencyPair, contract string) error {
+	if err := ws.checkContract(contract); err != nil {
+		return err
+	}
+	return ws.subscribe(fmt.Sprintf("market.%s_%s.trade.detail", pair.CurrencyA.Symbol, ws.adaptContractSymbol(contract)))
 }
 
-func (ws *FuturesWs) connectWs() {
-	ws.Do(func() {
-		ws.wsConn = ws.WsBuilder.Build()
-	})
+func (ws *FuturesWs) subscribe(ch string) error {
+	return ws.conn.subscribe(ch)
 }
 
-func (ws *FuturesWs) handle(msg []byte) error {
-	//心跳
-	if bytes.Contains(msg, []byte("ping")) {
-		pong := bytes.ReplaceAll(msg, []byte("ping"), []byte("pong"))
-		ws.wsConn.SendMessage(pong)
-		return nil
+// unsubscribe unsubs whichever of channels are subscribed.
+func (ws *FuturesWs) unsubscribe(channels ...string) error {
+	for _, ch := range channels {
+		ok, err := ws.conn.unsubscribe(ch)
+		if !ok {
+			continue
+		}
+		ws.depthSizes.remove(ch)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	var resp WsResponse
-	err := json.Unmarshal(msg, &resp)
-	if err != nil {
+// UnsubscribeDepth stops the depth of the contract, whichever size it was
+// subscribed with.
+func (ws *FuturesWs) UnsubscribeDepth(pair CurrencyPair, contract string) error {
+	if err := ws.checkContract(contract); err != nil {
+		return err
+	}
+	ws.depthRoutes.Unhandle(ws.contractSymbol(pair, contract))
+	var channels []string
+	for _, size := range futuresDepthSizes {
+		channels = append(channels, fmt.Sprintf("market.%s_%s.depth.size_%d.high_freq",
+			pair.CurrencyA.Symbol, ws.adaptContractSymbol(contract), size))
+	}
+	return ws.unsubscribe(channels...)
+}
+
+func (ws *FuturesWs) UnsubscribeTicker(pair CurrencyPair, contract string) error {
+	if err := ws.checkContract(contract); err != nil {
+		return err
+	}
+	ws.tickerRoutes.Unhandle(ws.contractSymbol(pair, contract))
+	return ws.unsubscribe(fmt.Sprintf("market.%s_%s.detail", pair.CurrencyA.Symbol, ws.adaptContractSymbol(contract)))
+}
+
+func (ws *FuturesWs) UnsubscribeTrade(pair CurrencyPair, contract string) error {
+	if err := ws.checkContract(contract); err != nil {
+		return err
+	}
+	ws.tradeFuncs.Unhandle(ws.contractSymbol(pair, contract))
+	return ws.unsubscribe(fmt.Sprintf("market.%s_%s.trade.detail", pair.CurrencyA.Symbol, ws.adaptContractSymbol(contract)))
+}
+
+func (ws *FuturesWs) UnsubscribeKline(pair CurrencyPair, period int, contractType string) error {
+	if err := ws.checkContract(contractType); err != nil {
 		return err
 	}
+	key := ws.contractSymbol(pair, contractType) + "/" + _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	ws.klineFuncs.Unhandle(key)
+	periodS, isOk := _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	if !isOk || period == KLINE_PERIOD_1YEAR {
+		return fmt.Errorf("unsupported kline period %d in huobi futures", period)
+	}
+	return ws.unsubscribe(fmt.Sprintf("market.%s_%s.kline.%s", pair.CurrencyA.Symbol, ws.adaptContractSymbol(contractType), periodS))
+}
+
+// SubscribeDepthChan subscribes to the depth of the contract and delivers it
+// on the returned channel, alongside the depth callback if one is set. The
+// channel is closed once ctx is done, the contract stays subscribed until
+// UnsubscribeDepth.
+func (ws *FuturesWs) SubscribeDepthChan(ctx context.Context, pair CurrencyPair, size int, contract string, opts ...common.StreamOption) (<-chan *Depth, error) {
+	return common.SubscribeChan(ctx, &ws.depthRoutes, ws.contractSymbol(pair, contract), func() error {
+		return ws.subscribeDepth(pair, size, contract)
+	}, opts...)
+}
+
+func (ws *FuturesWs) SubscribeTickerChan(ctx context.Context, pair CurrencyPair, contract string, opts ...common.StreamOption) (<-chan *FutureTicker, error) {
+	return common.SubscribeChan(ctx, &ws.tickerRoutes, ws.contractSymbol(pair, contract), func() error {
+		return ws.subscribeTicker(pair, contract)
+	}, opts...)
+}
+
+func (ws *FuturesWs) SubscribeTradeChan(ctx context.Context, pair CurrencyPair, contract string, opts ...common.StreamOption) (<-chan *Trade, error) {
+	return common.SubscribeChan(ctx, &ws.tradeRoutes, ws.contractSymbol(pair, contract), func() error {
+		return ws.subscribeTrade(pair, contract)
+	}, opts...)
+}
+
+func (ws *FuturesWs) SubscribeKlineChan(ctx context.Context, pair CurrencyPair, period int, contractType string, opts ...common.StreamOption) (<-chan *FutureKline, error) {
+	key := ws.contractSymbol(pair, contractType) + "/" + _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	return common.SubscribeChan(ctx, &ws.klineRoutes, key, func() error {
+		return ws.subscribeKline(pair, period, contractType)
+	}, opts...)
+}
+
+// SubscribeDepthFunc subscribes to the depth of the contract and hands it to
+// call, besides the depth callback if one is set. call replaces the one it
+// was subscribed with before and is dropped by UnsubscribeDepth.
+func (ws *FuturesWs) SubscribeDepthFunc(pair CurrencyPair, size int, contract string, call func(depth *Depth)) error {
+	if call == nil {
+		return errors.New("please set depth callback func")
+	}
+	return common.SubscribeFunc(&ws.depthRoutes, ws.contractSymbol(pair, contract), call, func() error {
+		return ws.subscribeDepth(pair, size, contract)
+	})
+}
+
+func (ws *FuturesWs) SubscribeTickerFunc(pair CurrencyPair, contract string, call func(ticker *FutureTicker)) error {
+	if call == nil {
+		return errors.New("please set ticker callback func")
+	}
+	return common.SubscribeFunc(&ws.tickerRoutes, ws.contractSymbol(pair, contract), call, func() error {
+		return ws.subscribeTicker(pair, contract)
+	})
+}
+
+func (ws *FuturesWs) SubscribeTradeFunc(pair CurrencyPair, contract string, call func(trade *Trade, contract string)) error {
+	if call == nil {
+		return errors.New("please set trade callback func")
+	}
+	return common.SubscribeFunc(&ws.tradeFuncs, ws.contractSymbol(pair, contract), func(t common.ContractTrade) {
+		call(t.Trade, t.Contract)
+	}, func() error {
+		return ws.subscribeTrade(pair, contract)
+	})
+}
+
+func (ws *FuturesWs) SubscribeKlineFunc(pair CurrencyPair, period int, contractType string, call func(kline *FutureKline, period int, contract string)) error {
+	if call == nil {
+		return errors.New("please set kline callback func")
+	}
+	key := ws.contractSymbol(pair, contractType) + "/" + _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	return common.SubscribeFunc(&ws.klineFuncs, key, func(k common.ContractKline) {
+		call(k.Kline, k.Period, k.Contract)
+	}, func() error {
+		return ws.subscribeKline(pair, period, contractType)
+	})
+}
+
+// dispatch runs call through the dispatcher, a panic is recovered and
+// reported with the frame raw and data.
+func (ws *FuturesWs) dispatch(key string, raw []byte, data interface{}, call func()) {
+	ws.dispatcher.Dispatch(key, func() {
+		ws.hooks.panics.Run(ws.hooks.errs.Report, raw, data, call)
+	})
+}
+
+func (ws *FuturesWs) onDepth(key string, raw []byte, depth *Depth) {
+	ws.dispatch(key, raw, depth, func() {
+		if call := ws.depthCallback.Get(); call != nil {
+			call(depth)
+		}
+		ws.depthRoutes.Emit(key, depth)
+	})
+}
 
+func (ws *FuturesWs) onTicker(key string, raw []byte, ticker *FutureTicker) {
+	ws.dispatch(key, raw, ticker, func() {
+		if call := ws.tickerCallback.Get(); call != nil {
+			call(ticker)
+		}
+		ws.tickerRoutes.Emit(key, ticker)
+	})
+}
+
+func (ws *FuturesWs) onTrade(key string, raw []byte, trade *Trade, contract string) {
+	ws.dispatch(key, raw, trade, func() {
+		if call := ws.tradeCallback.Get(); call != nil {
+			call(trade, contract)
+		}
+		ws.tradeRoutes.Emit(key, trade)
+		ws.tradeFuncs.Emit(key, common.ContractTrade{Trade: trade, Contract: contract})
+	})
+}
+
+func (ws *FuturesWs) onKline(key string, raw []byte, kline *FutureKline, period int, contract string) {
+	ws.dispatch(key, raw, kline, func() {
+		if call := ws.klineCallback.Get(); call != nil {
+			call(kline, period, contract)
+		}
+		ws.klineRoutes.Emit(key, kline)
+		ws.klineFuncs.Emit(key, common.ContractKline{Kline: kline, Period: period, Contract: contract})
+	})
+}
+
+func (ws *FuturesWs) handle(raw []byte, resp WsResponse) error {
+	if resp.Status == "error" {
+		return &common.ServerError{Code: resp.ErrCode, Msg: resp.ErrMsg}
+	}
 	if resp.Ch == "" {
-		//logger.Warnf("[%s] ch == \"\" , msg=%s", ws.wsConn.WsUrl, string(msg))
 		return nil
 	}
 
 	pair, contract, err := ws.parseCurrencyAndContract(resp.Ch)
 	if err != nil {
-		//logger.Errorf("[%s] parse currency and contract err=%s", ws.wsConn.WsUrl, err)
-		return err
+		return &common.UnknownChannelError{Channel: resp.Ch}
 	}
 
 	if strings.Contains(resp.Ch, ".depth.") {
 		var depResp DepthResponse
 		err := json.Unmarshal(resp.Tick, &depResp)
 		if err != nil {
-			return err
+			return &common.ParseError{Raw: resp.Tick, Err: err}
 		}
 
 		dep := ParseDepthFromResponse(depResp)
 		dep.ContractType = contract
 		dep.Pair = pair
 		dep.UTime = time.Unix(0, resp.Ts*int64(time.Millisecond))
+		common.TruncateDepth(&dep, ws.depthSizes.get(resp.Ch))
 
-		ws.depthCallback(&dep)
+		ws.onDepth(routeKey(resp.Ch), raw, &dep)
+		return nil
+	}
+
+	if strings.Contains(resp.Ch, ".kline.") {
+		var klineResp KlineResponse
+		err := json.Unmarshal(resp.Tick, &klineResp)
+		if err != nil {
+			return &common.ParseError{Raw: resp.Tick, Err: err}
+		}
+		period := resp.Ch[strings.LastIndex(resp.Ch, ".")+1:]
+		ws.onKline(routeKey(resp.Ch), raw, &FutureKline{
+			Kline: &Kline{
+				Pair:      pair,
+				Timestamp: klineResp.Id,
+				Open:      klineResp.Open,
+				Close:     klineResp.Close,
+				High:      klineResp.High,
+				Low:       klineResp.Low,
+				Vol:       klineResp.Vol,
+			},
+			Vol2: klineResp.Amount,
+		}, _INERNAL_KLINE_PERIOD_REVERTER[period], contract)
 		return nil
 	}
 
@@ -144,12 +437,12 @@ func (ws *FuturesWs) handle(msg []byte) error {
 		var tradeResp TradeResponse
 		err := json.Unmarshal(resp.Tick, &tradeResp)
 		if err != nil {
-			return err
+			return &common.ParseError{Raw: resp.Tick, Err: err}
 		}
 		trades := ws.parseTrade(tradeResp)
 		for _, v := range trades {
 			v.Pair = pair
-			ws.tradeCallback(&v, contract)
+			ws.onTrade(routeKey(resp.Ch), raw, &v, contract)
 		}
 		return nil
 	}
@@ -158,18 +451,16 @@ func (ws *FuturesWs) handle(msg []byte) error {
 		var detail DetailResponse
 		err := json.Unmarshal(resp.Tick, &detail)
 		if err != nil {
-			return err
+			return &common.ParseError{Raw: resp.Tick, Err: err}
 		}
 		ticker := ws.parseTicker(detail)
 		ticker.ContractType = contract
 		ticker.Pair = pair
-		ws.tickerCallback(&ticker)
+		ws.onTicker(routeKey(resp.Ch), raw, &ticker)
 		return nil
 	}
 
-	//logger.Errorf("[%s] unknown message, msg=%s", ws.wsConn.WsUrl, string(msg))
-
-	return nil
+	return &common.UnknownChannelError{Channel: resp.Ch}
 }
 
 func (ws *FuturesWs) parseTicker(r DetailResponse) FutureTicker {
@@ -182,14 +473,21 @@ func (ws *FuturesWs) parseCurrencyAndContract(ch string) (CurrencyPair, string,
 		return UNKNOWN_PAIR, "", errors.New(ch)
 	}
 	cs := strings.Split(el[1], "_")
+	if len(cs) < 2 {
+		return UNKNOWN_PAIR, "", errors.New(ch)
+	}
 	contract := ""
 	switch cs[1] {
 	case "CQ":
 		contract = QUARTER_CONTRACT
+	case "NQ":
+		contract = BI_QUARTER_CONTRACT
 	case "NW":
 		contract = NEXT_WEEK_CONTRACT
 	case "CW":
 		contract = THIS_WEEK_CONTRACT
+	default:
+		return UNKNOWN_PAIR, "", errors.New(ch)
 	}
 	return NewCurrencyPair(NewCurrency(cs[0], ""), USD), contract, nil
 }
@@ -207,11 +505,17 @@ func (ws *FuturesWs) parseTrade(r TradeResponse) []Trade {
 	return trades
 }
 
+// contractSymbol is the symbol of the contract in channel names, BTC_CQ.
+func (ws *FuturesWs) contractSymbol(pair CurrencyPair, contract string) string {
+	return pair.CurrencyA.Symbol + "_" + ws.adaptContractSymbol(contract)
+}
+
 func (ws *FuturesWs) adaptContractSymbol(contract string) string {
-	//log.Println(contract)
 	switch contract {
 	case QUARTER_CONTRACT:
 		return "CQ"
+	case BI_QUARTER_CONTRACT:
+		return "NQ"
 	case NEXT_WEEK_CONTRACT:
 		return "NW"
 	case THIS_WEEK_CONTRACT:
@@ -220,6 +524,14 @@ func (ws *FuturesWs) adaptContractSymbol(contract string) string {
 	return ""
 }
 
+// checkContract rejects the contract types the socket has no symbol for.
+func (ws *FuturesWs) checkContract(contract string) error {
+	if ws.adaptContractSymbol(contract) == "" {
+		return fmt.Errorf("unsupported contract type %s in huobi futures", contract)
+	}
+	return nil
+}
+
 func (ws *FuturesWs) adaptTime(tm string) int64 {
 	format := "2006-01-02 15:04:05"
 	day := time.Now().Format("2006-01-02")
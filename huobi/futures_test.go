@@ -1 +1,47 @@
 package huobi
+
+import (
+	"github.com/nntaoli-project/goex"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewFutureWs(t *testing.T) {
+	os.Setenv("HTTPS_PROXY", "socks5://127.0.0.1:1080")
+	futuresWs := NewFutureWs()
+	futuresWs.KlineCallback(func(kline *goex.FutureKline, period int, contract string) {
+		t.Log(contract, period, kline.Kline, kline.Vol2)
+	})
+	futuresWs.SubscribeKline(goex.BTC_USD, goex.KLINE_PERIOD_1MIN, goex.QUARTER_CONTRACT)
+	time.Sleep(time.Minute)
+}
+
+func TestFuturesWs_Contract(t *testing.T) {
+	ws := NewFutureWs()
+	defer ws.Close()
+	ws.TickerCallback(func(ticker *goex.FutureTicker) {})
+	if err := ws.SubscribeTicker(goex.BTC_USD, goex.SWAP_CONTRACT); err == nil {
+		t.Error("swap subscribed as a futures contract")
+	}
+	if err := ws.UnsubscribeTicker(goex.BTC_USD, goex.SWAP_CONTRACT); err == nil {
+		t.Error("swap unsubscribed as a futures contract")
+	}
+
+	for ch, want := range map[string]string{
+		"market.BTC_CW.detail":       goex.THIS_WEEK_CONTRACT,
+		"market.BTC_NW.detail":       goex.NEXT_WEEK_CONTRACT,
+		"market.BTC_CQ.detail":       goex.QUARTER_CONTRACT,
+		"market.BTC_NQ.trade.detail": goex.BI_QUARTER_CONTRACT,
+	} {
+		pair, contract, err := ws.parseCurrencyAndContract(ch)
+		if err != nil || pair != goex.BTC_USD || contract != want {
+			t.Errorf("%s: got %v %s %v, want %s", ch, pair, contract, err, want)
+		}
+	}
+	for _, ch := range []string{"market.BTC.detail", "market.BTC_XX.detail", "market"} {
+		if _, _, err := ws.parseCurrencyAndContract(ch); err == nil {
+			t.Errorf("%s parsed", ch)
+		}
+	}
+}
@@ -3,14 +3,81 @@ package huobi
 import (
 	json2 "encoding/json"
 	"fmt"
+	"github.com/goex-top/goexws/common"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/nntaoli-project/goex"
 	"sort"
 	"strings"
+	"sync"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+var (
+	// spotDepthSizes are the mbp.refresh channels (5, 10, 20) and the
+	// incremental mbp books (150, 400)
+	spotDepthSizes = []int{5, 10, 20, 150, 400}
+	// futuresDepthSizes are the depth.size_20 and depth.size_150 channels
+	futuresDepthSizes = []int{20, 150}
+)
+
+// channelType tells the watchdog what kind of data a channel such as
+// market.btcusdt.kline.1min carries.
+func channelType(ch string) common.ChannelType {
+	switch {
+	case strings.Contains(ch, ".depth."), strings.Contains(ch, ".mbp."):
+		return common.DepthChannel
+	case strings.Contains(ch, ".kline."):
+		return common.KlineChannel
+	case strings.HasSuffix(ch, ".trade.detail"):
+		return common.TradeChannel
+	case strings.HasSuffix(ch, ".detail"):
+		return common.TickerChannel
+	}
+	return ""
+}
+
+// routeKey is the key the data of ch is handed to channel subscribers under,
+// the symbol of the channel followed by the period for klines. The symbol is
+// used as is since the pair parsed back from it is a guess.
+func routeKey(ch string) string {
+	el := strings.Split(ch, ".")
+	if len(el) < 2 {
+		return ch
+	}
+	if len(el) > 3 && el[2] == "kline" {
+		return el[1] + "/" + el[3]
+	}
+	return el[1]
+}
+
+// depthSizes remembers how many levels were asked for on each depth channel.
+type depthSizes struct {
+	sync.Mutex
+	sizes map[string]int
+}
+
+func (d *depthSizes) set(ch string, size int) {
+	d.Lock()
+	defer d.Unlock()
+	if d.sizes == nil {
+		d.sizes = make(map[string]int)
+	}
+	d.sizes[ch] = size
+}
+
+func (d *depthSizes) get(ch string) int {
+	d.Lock()
+	defer d.Unlock()
+	return d.sizes[ch]
+}
+
+func (d *depthSizes) remove(ch string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.sizes, ch)
+}
+
 type DepthResponse struct {
 	Bids [][]float64
 	Asks [][]float64
@@ -18,9 +85,17 @@ type DepthResponse struct {
 }
 
 type WsResponse struct {
-	Ch   string
-	Ts   int64
-	Tick json2.RawMessage
+	Id       string
+	Subbed   string
+	Unsubbed string
+	Ch       string
+	Ts       int64
+	Tick     json2.RawMessage
+	Rep      string
+	Status   string
+	ErrCode  string `json:"err-code"`
+	ErrMsg   string `json:"err-msg"`
+	Data     json2.RawMessage
 }
 
 type TradeResponse struct {
@@ -35,6 +110,21 @@ type TradeResponse struct {
 	}
 }
 
+// SpotTradeResponse is the tick of the spot trade.detail channel, the id of
+// a spot trade is a number too large for an int64 so the trade id is read
+// from tradeId and the id is left out.
+type SpotTradeResponse struct {
+	Id   int64
+	Ts   int64
+	Data []struct {
+		TradeId   int64 `json:"tradeId"`
+		Amount    float64
+		Price     float64
+		Direction string
+		Ts        int64
+	}
+}
+
 type DetailResponse struct {
 	Id     int64
 	Open   float64
@@ -44,6 +134,46 @@ type DetailResponse struct {
 	Amount float64
 	Vol    float64
 	Count  int64
+	Ask    []float64
+	Bid    []float64
+}
+
+type KlineResponse struct {
+	Id     int64
+	Open   float64
+	Close  float64
+	High   float64
+	Low    float64
+	Amount float64
+	Vol    float64
+	Count  int64
+}
+
+var _INERNAL_KLINE_PERIOD_REVERTER = map[string]int{
+	"1min":  goex.KLINE_PERIOD_1MIN,
+	"5min":  goex.KLINE_PERIOD_5MIN,
+	"15min": goex.KLINE_PERIOD_15MIN,
+	"30min": goex.KLINE_PERIOD_30MIN,
+	"60min": goex.KLINE_PERIOD_60MIN,
+	"4hour": goex.KLINE_PERIOD_4H,
+	"1day":  goex.KLINE_PERIOD_1DAY,
+	"1week": goex.KLINE_PERIOD_1WEEK,
+	"1mon":  goex.KLINE_PERIOD_1MONTH,
+	"1year": goex.KLINE_PERIOD_1YEAR,
+}
+
+var _INERNAL_KLINE_PERIOD_CONVERTER = map[int]string{
+	goex.KLINE_PERIOD_1MIN:   "1min",
+	goex.KLINE_PERIOD_5MIN:   "5min",
+	goex.KLINE_PERIOD_15MIN:  "15min",
+	goex.KLINE_PERIOD_30MIN:  "30min",
+	goex.KLINE_PERIOD_60MIN:  "60min",
+	goex.KLINE_PERIOD_1H:     "60min",
+	goex.KLINE_PERIOD_4H:     "4hour",
+	goex.KLINE_PERIOD_1DAY:   "1day",
+	goex.KLINE_PERIOD_1WEEK:  "1week",
+	goex.KLINE_PERIOD_1MONTH: "1mon",
+	goex.KLINE_PERIOD_1YEAR:  "1year", // spot only
 }
 
 func ParseDepthFromResponse(r DepthResponse) goex.Depth {
@@ -64,7 +194,6 @@ func ParseDepthFromResponse(r DepthResponse) goex.Depth {
 func ParseCurrencyPairFromSpotWsCh(ch string) goex.CurrencyPair {
 	meta := strings.Split(ch, ".")
 	if len(meta) < 2 {
-		//logger.Errorf("parse error, ch=%s", ch)
 		return goex.UNKNOWN_PAIR
 	}
 
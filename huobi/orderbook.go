@@ -0,0 +1,140 @@
+package huobi
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nntaoli-project/goex"
+)
+
+// maxBufferedTicks bounds the updates kept while waiting for a snapshot.
+const maxBufferedTicks = 1000
+
+type MbpTick struct {
+	SeqNum     int64       `json:"seqNum"`
+	PrevSeqNum int64       `json:"prevSeqNum"`
+	Bids       [][]float64 `json:"bids"`
+	Asks       [][]float64 `json:"asks"`
+}
+
+// mbpBook keeps a local book from the incremental market.$symbol.mbp.$levels
+// feed. Updates are buffered until the snapshot requested over the same
+// socket ("req") comes back, updates already covered by the snapshot are
+// dropped and from there on every update's prevSeqNum must match the seqNum
+// of the one before. On a gap the book is cleared and a new snapshot is
+// requested.
+type mbpBook struct {
+	pair goex.CurrencyPair
+	size int
+
+	requested bool
+	synced    bool
+	seqNum    int64
+	buffer    []MbpTick
+	bids      map[float64]float64
+	asks      map[float64]float64
+}
+
+func newMbpBook(pair goex.CurrencyPair, size int) *mbpBook {
+	return &mbpBook{pair: pair, size: size}
+}
+
+// update feeds one incremental tick into the book. It returns the book once
+// in sync, and whether a snapshot has to be requested.
+func (b *mbpBook) update(tick MbpTick, ts time.Time) (dep *goex.Depth, request bool, err error) {
+	if !b.synced {
+		b.buffer = append(b.buffer, tick)
+		if len(b.buffer) > maxBufferedTicks {
+			b.buffer = b.buffer[len(b.buffer)-maxBufferedTicks:]
+		}
+		request = !b.requested
+		b.requested = true
+		return nil, request, nil
+	}
+
+	if err := b.apply(tick); err != nil {
+		b.resync(tick)
+		return nil, true, err
+	}
+	return b.depth(ts), false, nil
+}
+
+// load installs the snapshot and replays the buffered ticks on top of it.
+func (b *mbpBook) load(snapshot MbpTick, ts time.Time) (dep *goex.Depth, request bool, err error) {
+	b.bids = make(map[float64]float64, len(snapshot.Bids))
+	b.asks = make(map[float64]float64, len(snapshot.Asks))
+	b.merge(b.bids, snapshot.Bids)
+	b.merge(b.asks, snapshot.Asks)
+	b.seqNum = snapshot.SeqNum
+	b.synced = true
+	b.requested = false
+
+	buffer := b.buffer
+	b.buffer = nil
+	for _, tick := range buffer {
+		if err := b.apply(tick); err != nil {
+			b.resync(buffer[len(buffer)-1])
+			return nil, true, err
+		}
+	}
+	return b.depth(ts), false, nil
+}
+
+// reject re-arms the snapshot request after the server refused one.
+func (b *mbpBook) reject() {
+	b.requested = false
+}
+
+func (b *mbpBook) apply(tick MbpTick) error {
+	if tick.SeqNum <= b.seqNum {
+		return nil
+	}
+	if tick.PrevSeqNum != b.seqNum {
+		return fmt.Errorf("huobi %s mbp out of sync: expected prevSeqNum %d, got %d",
+			b.pair.ToSymbol(""), b.seqNum, tick.PrevSeqNum)
+	}
+	b.merge(b.bids, tick.Bids)
+	b.merge(b.asks, tick.Asks)
+	b.seqNum = tick.SeqNum
+	return nil
+}
+
+func (b *mbpBook) merge(side map[float64]float64, levels [][]float64) {
+	for _, l := range levels {
+		if l[1] == 0 {
+			delete(side, l[0])
+			continue
+		}
+		side[l[0]] = l[1]
+	}
+}
+
+func (b *mbpBook) resync(tick MbpTick) {
+	b.synced = false
+	b.requested = true
+	b.bids, b.asks = nil, nil
+	b.buffer = append(b.buffer[:0], tick)
+}
+
+// depth returns the best size levels (the whole book when size is 0), both
+// sides sorted from the highest price down like ParseDepthFromResponse.
+func (b *mbpBook) depth(ts time.Time) *goex.Depth {
+	dep := &goex.Depth{Pair: b.pair, UTime: ts}
+	for price, amount := range b.bids {
+		dep.BidList = append(dep.BidList, goex.DepthRecord{Price: price, Amount: amount})
+	}
+	for price, amount := range b.asks {
+		dep.AskList = append(dep.AskList, goex.DepthRecord{Price: price, Amount: amount})
+	}
+	sort.Sort(sort.Reverse(dep.BidList))
+	sort.Sort(dep.AskList)
+	if b.size > 0 && len(dep.BidList) > b.size {
+		dep.BidList = dep.BidList[:b.size]
+	}
+	if b.size > 0 && len(dep.AskList) > b.size {
+		dep.AskList = dep.AskList[:b.size]
+	}
+	sort.Sort(sort.Reverse(dep.AskList))
+	return dep
+}
@@ -0,0 +1,58 @@
+package huobi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nntaoli-project/goex"
+)
+
+func TestMbpBook(t *testing.T) {
+	book := newMbpBook(goex.BTC_USDT, 2)
+	now := time.Now()
+
+	dep, request, err := book.update(MbpTick{SeqNum: 10, PrevSeqNum: 9, Bids: [][]float64{{100, 9}}}, now)
+	if dep != nil || !request || err != nil {
+		t.Fatalf("first tick: %v %v %v", dep, request, err)
+	}
+	dep, request, err = book.update(MbpTick{SeqNum: 12, PrevSeqNum: 10, Asks: [][]float64{{102, 0}}}, now)
+	if dep != nil || request || err != nil {
+		t.Fatalf("buffered tick: %v %v %v", dep, request, err)
+	}
+
+	snapshot := MbpTick{
+		SeqNum: 10,
+		Bids:   [][]float64{{100, 1}, {99, 2}, {98, 3}},
+		Asks:   [][]float64{{101, 1}, {102, 2}, {103, 3}},
+	}
+	dep, request, err = book.load(snapshot, now)
+	if err != nil || request {
+		t.Fatalf("load: %v %v", request, err)
+	}
+	if len(dep.BidList) != 2 || dep.BidList[0] != (goex.DepthRecord{Price: 100, Amount: 1}) || dep.BidList[1].Price != 99 {
+		t.Errorf("bids %v", dep.BidList)
+	}
+	if len(dep.AskList) != 2 || dep.AskList[0].Price != 103 || dep.AskList[1].Price != 101 {
+		t.Errorf("asks %v", dep.AskList)
+	}
+
+	dep, _, err = book.update(MbpTick{SeqNum: 13, PrevSeqNum: 12, Bids: [][]float64{{100.5, 4}}}, now)
+	if err != nil || dep.BidList[0].Price != 100.5 {
+		t.Errorf("sequential update: %v %v", dep, err)
+	}
+
+	dep, request, err = book.update(MbpTick{SeqNum: 15, PrevSeqNum: 14}, now)
+	if err == nil || !request || dep != nil {
+		t.Fatalf("gap: %v %v %v", dep, request, err)
+	}
+
+	// the snapshot is older than the buffered tick, so it is requested again
+	dep, request, err = book.load(MbpTick{SeqNum: 13}, now)
+	if err == nil || !request || dep != nil {
+		t.Fatalf("stale snapshot: %v %v %v", dep, request, err)
+	}
+	dep, request, err = book.load(MbpTick{SeqNum: 14, Bids: [][]float64{{1, 1}}}, now)
+	if err != nil || request || len(dep.BidList) != 1 {
+		t.Errorf("resynced: %v %v %v", dep, request, err)
+	}
+}
@@ -0,0 +1,158 @@
+package huobi
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/common"
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/nntaoli-project/goex"
+)
+
+func TestSpotWs_Resubscribe(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {
+		var req struct {
+			Id  string `json:"id"`
+			Sub string `json:"sub"`
+		}
+		json.Unmarshal([]byte(msg), &req)
+		if req.Sub == "" {
+			return
+		}
+		// the trade channel is refused
+		if strings.HasSuffix(req.Sub, ".trade.detail") {
+			c.Send(`{"id":"` + req.Id + `","status":"error","err-code":"bad-request","err-msg":"invalid topic"}`)
+			return
+		}
+		c.Send(`{"id":"` + req.Id + `","status":"ok","subbed":"` + req.Sub + `"}`)
+	})
+	defer srv.Close()
+
+	var (
+		lock   sync.Mutex
+		failed []string
+		resubs [][]string
+	)
+	ws := NewSpotWs()
+	ws.SetBaseUrl(srv.URL)
+	ws.SubscribeFailedCallback(func(channel string, err error) {
+		lock.Lock()
+		failed = append(failed, channel+": "+err.Error())
+		lock.Unlock()
+	})
+	ws.LifecycleCallback(func(event *common.Event) {
+		if event.Type == common.Resubscribed {
+			lock.Lock()
+			resubs = append(resubs, event.Failed)
+			lock.Unlock()
+		}
+	})
+	ws.TickerCallback(func(ticker *goex.Ticker) {})
+	ws.TradeCallback(func(trade *goex.Trade) {})
+	ws.SubscribeTicker(goex.BTC_USDT)
+	ws.SubscribeTrade(goex.BTC_USDT)
+
+	subs := func() int {
+		n := 0
+		for _, msg := range srv.Received() {
+			if strings.Contains(msg, `"sub"`) {
+				n++
+			}
+		}
+		return n
+	}
+	if !wstest.Eventually(time.Second, func() bool { return subs() == 2 }) {
+		t.Fatalf("got %v, want 2 subscriptions", srv.Received())
+	}
+	srv.Drop()
+	if !wstest.Eventually(3*time.Second, func() bool { return subs() == 4 }) {
+		t.Fatalf("got %v, want both subscriptions replayed", srv.Received())
+	}
+
+	wstest.Eventually(time.Second, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(failed) == 2 && len(resubs) == 2
+	})
+	lock.Lock()
+	defer lock.Unlock()
+	want := "market.btcusdt.trade.detail: server error bad-request: invalid topic"
+	if len(failed) != 2 || failed[0] != want || failed[1] != want {
+		t.Errorf("failed %v, want %q on both connections", failed, want)
+	}
+	// the first connection may have come up before or after the subscribe
+	// calls, only the replay after the drop is known to carry both
+	if len(resubs) != 2 || len(resubs[1]) != 1 || resubs[1][0] != "market.btcusdt.trade.detail" {
+		t.Errorf("resubscribed %v, want the trade channel failed after the drop", resubs)
+	}
+}
+
+func TestSpotWs_Errors(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {
+		var req struct {
+			Id  string `json:"id"`
+			Sub string `json:"sub"`
+		}
+		json.Unmarshal([]byte(msg), &req)
+		if strings.HasSuffix(req.Sub, ".trade.detail") {
+			c.Send(`{"id":"` + req.Id + `","status":"error","err-code":"bad-request","err-msg":"invalid topic"}`)
+			return
+		}
+		c.Send(`{"id":"` + req.Id + `","status":"ok","subbed":"` + req.Sub + `"}`)
+		c.Send(`not json`)
+		c.Send(`{"ch":"market.btcusdt.bbo","ts":1,"tick":{}}`)
+		c.Send(`{"id":"x","status":"error","err-code":"too-many-request","err-msg":"slow down"}`)
+	})
+	defer srv.Close()
+
+	errs := make(chan error, 16)
+	ws := NewSpotWs()
+	ws.SetBaseUrl(srv.URL)
+	ws.ErrorCallback(func(err error) {
+		errs <- err
+	})
+	ws.TickerCallback(func(ticker *goex.Ticker) {})
+	ws.TradeCallback(func(trade *goex.Trade) {})
+	ws.SubscribeTicker(goex.BTC_USDT)
+	ws.SubscribeTrade(goex.BTC_USDT)
+
+	var (
+		parseErr   *common.ParseError
+		unknownErr *common.UnknownChannelError
+		serverErr  *common.ServerError
+		subErr     *common.SubscribeError
+	)
+	for parseErr == nil || unknownErr == nil || serverErr == nil || subErr == nil {
+		select {
+		case err := <-errs:
+			switch err := err.(type) {
+			case *common.ParseError:
+				parseErr = err
+			case *common.UnknownChannelError:
+				unknownErr = err
+			case *common.ServerError:
+				serverErr = err
+			case *common.SubscribeError:
+				subErr = err
+			default:
+				t.Errorf("untyped error %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got parse %v, unknown %v, server %v, subscribe %v", parseErr, unknownErr, serverErr, subErr)
+		}
+	}
+	if string(parseErr.Raw) != "not json" {
+		t.Errorf("parse error raw %q", parseErr.Raw)
+	}
+	if unknownErr.Channel != "market.btcusdt.bbo" || !strings.Contains(string(unknownErr.Raw), `"tick"`) {
+		t.Errorf("unknown channel %v", unknownErr)
+	}
+	if serverErr.Code != "too-many-request" {
+		t.Errorf("server error %v", serverErr)
+	}
+	if e, ok := subErr.Err.(*common.ServerError); subErr.Channel != "market.btcusdt.trade.detail" || !ok || e.Code != "bad-request" {
+		t.Errorf("subscribe error %v", subErr)
+	}
+}
@@ -1,9 +1,10 @@
 package huobi
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/goex-top/goexws/common"
 	. "github.com/nntaoli-project/goex"
 	"strings"
 	"sync"
@@ -11,94 +12,456 @@ import (
 )
 
 type SpotWs struct {
-	*WsBuilder
-	sync.Once
-	wsConn *WsConn
+	conn  *marketConn
+	hooks *hooks
 
-	tickerCallback func(*Ticker)
-	depthCallback  func(*Depth)
-	tradeCallback  func(*Trade)
-	klineCallback  func(*Kline, int)
+	books      map[string]*mbpBook
+	booksLock  sync.Mutex
+	depthSizes depthSizes
+
+	tickerCallback common.Callback[func(*Ticker)]
+	depthCallback  common.Callback[func(*Depth)]
+	tradeCallback  common.Callback[func(*Trade)]
+	klineCallback  common.Callback[func(*Kline, int)]
+	dispatcher     common.Dispatcher
+	depthRoutes    common.Fanout[*Depth]
+	tickerRoutes   common.Fanout[*Ticker]
+	tradeRoutes    common.Fanout[*Trade]
+	klineRoutes    common.Fanout[*Kline]
 }
 
 func NewSpotWs() *SpotWs {
 	ws := &SpotWs{
-		WsBuilder: NewWsBuilder(),
+		books: make(map[string]*mbpBook),
+		hooks: newHooks(),
 	}
-	ws.WsBuilder = ws.WsBuilder.
-		WsUrl("wss://api.huobi.pro/ws").
-		AutoReconnect().
-		DecompressFunc(GzipDecompress).
-		ProtoHandleFunc(ws.handle)
+	ws.conn = newMarketConn("wss://api.huobi.pro/ws", ws.hooks, ws.handle)
+	ws.conn.onDisconnected = ws.resetBooks
+	ws.dispatcher = common.SerialPerSymbol(0)
 	return ws
 }
 
+// SetBaseUrl sets the websocket url, it has to be called before subscribing.
+func (ws *SpotWs) SetBaseUrl(baseURL string) {
+	ws.conn.wsURL = baseURL
+}
+
+func (ws *SpotWs) ProxyUrl(proxyUrl string) {
+	ws.conn.proxyUrl = proxyUrl
+}
+
+// SetDispatcher sets how the data callbacks are run, they are serial per
+// symbol by default. It has to be called before subscribing.
+func (ws *SpotWs) SetDispatcher(dispatcher common.Dispatcher) {
+	ws.dispatcher = dispatcher
+}
+
+// Close closes the connection and the subscription channels, no callback is
+// called once it returns. It must not be called from a callback.
+func (ws *SpotWs) Close() error {
+	err := ws.conn.close()
+	ws.dispatcher.Close()
+	ws.depthRoutes.Close()
+	ws.tickerRoutes.Close()
+	ws.tradeRoutes.Close()
+	ws.klineRoutes.Close()
+	return err
+}
+
+// SubscribeFailedCallback is told about every channel the server rejected or
+// did not acknowledge in time, be it on the first subscription or on the
+// replay after a reconnect.
+func (ws *SpotWs) SubscribeFailedCallback(call func(channel string, err error)) {
+	ws.hooks.setFailed(call)
+}
+
+// ErrorCallback gets the messages that could not be parsed or handled, the
+// error replies of the server and the failed subscriptions.
+func (ws *SpotWs) ErrorCallback(call func(err error)) {
+	ws.hooks.errs.SetCallback(call)
+}
+
+// SetLogger sets where the connection lifecycle and the message errors are
+// logged, nothing is logged by default.
+func (ws *SpotWs) SetLogger(logger common.Logger) {
+	ws.hooks.log.SetLogger(logger)
+}
+
+// LogFrames turns debug logging of every raw frame sent and received on or
+// off, it takes effect right away.
+func (ws *SpotWs) LogFrames(on bool) {
+	ws.hooks.log.SetFrames(on)
+}
+
+// RecoverPanics turns the recovery of panics in the callbacks and message
+// handling on or off, it is on by default. A recovered panic is handed to the
+// error callback as a *common.PanicError and the connection stays up.
+func (ws *SpotWs) RecoverPanics(on bool) {
+	ws.hooks.panics.SetRecover(on)
+}
+
+// LifecycleCallback is told whenever the connection comes up, drops, is
+// redialed and has its subscriptions restored.
+func (ws *SpotWs) LifecycleCallback(call func(event *common.Event)) {
+	ws.hooks.events.SetCallback(call)
+}
+
+// SetMaxSilence sets how long channels of channelType may go without data
+// before the connection is dropped and redialed, 0 turns the check off. It
+// applies to the channels subscribed afterwards.
+func (ws *SpotWs) SetMaxSilence(channelType common.ChannelType, maxSilence time.Duration) {
+	ws.hooks.maxSilence.Set(channelType, maxSilence)
+}
+
 func (ws *SpotWs) DepthCallback(call func(depth *Depth)) {
-	ws.depthCallback = call
+	ws.depthCallback.Set(call)
 }
 
 func (ws *SpotWs) TickerCallback(call func(ticker *Ticker)) {
-	ws.tickerCallback = call
+	ws.tickerCallback.Set(call)
 }
 
 func (ws *SpotWs) TradeCallback(call func(trade *Trade)) {
-	ws.tradeCallback = call
+	ws.tradeCallback.Set(call)
 }
 
 func (ws *SpotWs) KlineCallback(call func(*Kline, int)) {
-	ws.klineCallback = call
+	ws.klineCallback.Set(call)
 }
 
-func (ws *SpotWs) connectWs() {
-	ws.Do(func() {
-		ws.wsConn = ws.WsBuilder.Build()
-	})
+func (ws *SpotWs) subscribe(ch string) error {
+	return ws.conn.subscribe(ch)
 }
 
-func (ws *SpotWs) subscribe(sub map[string]interface{}) error {
-	ws.connectWs()
-	return ws.wsConn.Subscribe(sub)
+// unsubscribe unsubs whichever of channels are subscribed and drops their
+// books.
+func (ws *SpotWs) unsubscribe(channels ...string) error {
+	for _, ch := range channels {
+		ok, err := ws.conn.unsubscribe(ch)
+		if !ok {
+			continue
+		}
+		ws.depthSizes.remove(ch)
+		ws.booksLock.Lock()
+		delete(ws.books, ch)
+		ws.booksLock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// resetBooks starts every incremental book over, they are resynced from a
+// new snapshot once the connection is back.
+func (ws *SpotWs) resetBooks() {
+	ws.booksLock.Lock()
+	for ch, book := range ws.books {
+		ws.books[ch] = newMbpBook(book.pair, book.size)
+	}
+	ws.booksLock.Unlock()
+}
+
+// SubscribeDepth picks mbp.refresh.5/10/20 for up to 20 levels and keeps an
+// incremental mbp.150/400 book for more.
 func (ws *SpotWs) SubscribeDepth(pair CurrencyPair, size int) error {
-	if ws.depthCallback == nil {
+	if ws.depthCallback.Get() == nil {
 		return errors.New("please set depth callback func")
 	}
-	return ws.subscribe(map[string]interface{}{
-		"id":  "spot.depth",
-		"sub": fmt.Sprintf("market.%s.mbp.refresh.20", pair.ToLower().ToSymbol(""))})
+	return ws.subscribeDepth(pair, size)
+}
+
+func (ws *SpotWs) subscribeDepth(pair CurrencyPair, size int) error {
+	channelSize, err := common.DepthChannelSize("huobi", size, spotDepthSizes)
+	if err != nil {
+		return err
+	}
+	if channelSize > 20 {
+		return ws.subscribeMbp(pair, channelSize, size)
+	}
+
+	ch := fmt.Sprintf("market.%s.mbp.refresh.%d", pair.ToLower().ToSymbol(""), channelSize)
+	ws.depthSizes.set(ch, size)
+	return ws.subscribe(ch)
+}
+
+// SubscribeIncrementalDepth maintains a local book of 5, 20, 150 or 400 levels
+// from the incremental market.$symbol.mbp.$levels feed, snapshots are
+// requested over the same socket. DepthCallback gets the whole book after
+// every update once it is in sync.
+func (ws *SpotWs) SubscribeIncrementalDepth(pair CurrencyPair, levels int) error {
+	if ws.depthCallback.Get() == nil {
+		return errors.New("please set depth callback func")
+	}
+	if levels != 5 && levels != 20 && levels != 150 && levels != 400 {
+		return errors.New("please set mbp levels as 5 / 20 / 150 / 400")
+	}
+	return ws.subscribeMbp(pair, levels, levels)
+}
+
+// subscribeMbp subscribes the incremental book of levels and passes on the
+// best size levels of it.
+func (ws *SpotWs) subscribeMbp(pair CurrencyPair, levels, size int) error {
+	ch := fmt.Sprintf("market.%s.mbp.%d", pair.ToLower().ToSymbol(""), levels)
+	ws.booksLock.Lock()
+	ws.books[ch] = newMbpBook(pair, size)
+	ws.booksLock.Unlock()
+
+	return ws.subscribe(ch)
+}
+
+func (ws *SpotWs) requestMbpSnapshot(ch string) {
+	ws.conn.send(map[string]interface{}{
+		"id":  ch,
+		"req": ch})
+}
+
+func (ws *SpotWs) handleMbp(raw []byte, resp WsResponse) error {
+	ch := resp.Ch
+	if ch == "" {
+		ch = resp.Rep
+	}
+
+	ws.booksLock.Lock()
+	book := ws.books[ch]
+	ws.booksLock.Unlock()
+	if book == nil {
+		return nil
+	}
+
+	var (
+		tick    MbpTick
+		dep     *Depth
+		request bool
+		err     error
+		ts      = time.Unix(0, resp.Ts*int64(time.Millisecond))
+	)
+	if resp.Rep != "" {
+		if resp.Status != "ok" {
+			book.reject()
+			return &common.ServerError{Code: resp.ErrCode, Msg: ch + " snapshot: " + resp.ErrMsg}
+		}
+		err = json.Unmarshal(resp.Data, &tick)
+		if err != nil {
+			return &common.ParseError{Raw: resp.Data, Err: err}
+		}
+		dep, request, err = book.load(tick, ts)
+	} else {
+		err = json.Unmarshal(resp.Tick, &tick)
+		if err != nil {
+			return &common.ParseError{Raw: resp.Tick, Err: err}
+		}
+		dep, request, err = book.update(tick, ts)
+	}
+
+	if request {
+		ws.requestMbpSnapshot(ch)
+	}
+	if dep != nil {
+		ws.onDepth(routeKey(ch), raw, dep)
+	}
+	return err
 }
 
 func (ws *SpotWs) SubscribeTicker(pair CurrencyPair) error {
-	if ws.tickerCallback == nil {
+	if ws.tickerCallback.Get() == nil {
 		return errors.New("please set ticker call back func")
 	}
-	return ws.subscribe(map[string]interface{}{
-		"id":  "spot.ticker",
-		"sub": fmt.Sprintf("market.%s.detail", pair.ToLower().ToSymbol("")),
-	})
-	return nil
+	return ws.subscribeTicker(pair)
+}
+
+func (ws *SpotWs) subscribeTicker(pair CurrencyPair) error {
+	return ws.subscribe(fmt.Sprintf("market.%s.detail", pair.ToLower().ToSymbol("")))
 }
 
 func (ws *SpotWs) SubscribeTrade(pair CurrencyPair) error {
-	return nil
+	if ws.tradeCallback.Get() == nil {
+		return errors.New("please set trade call back func")
+	}
+	return ws.subscribeTrade(pair)
+}
+
+func (ws *SpotWs) subscribeTrade(pair CurrencyPair) error {
+	return ws.subscribe(fmt.Sprintf("market.%s.trade.detail", pair.ToLower().ToSymbol("")))
 }
 
 func (ws *SpotWs) SubscribeKline(pair CurrencyPair, period int) error {
-	return nil
+	if ws.klineCallback.Get() == nil {
+		return errors.New("please set kline call back func")
+	}
+	return ws.subscribeKline(pair, period)
 }
 
-func (ws *SpotWs) handle(msg []byte) error {
-	if bytes.Contains(msg, []byte("ping")) {
-		pong := bytes.ReplaceAll(msg, []byte("ping"), []byte("pong"))
-		ws.wsConn.SendMessage(pong)
-		return nil
+func (ws *SpotWs) subscribeKline(pair CurrencyPair, period int) error {
+	periodS, isOk := _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	if !isOk {
+		return fmt.Errorf("unsupported kline period %d in huobi", period)
 	}
+	return ws.subscribe(fmt.Sprintf("market.%s.kline.%s", pair.ToLower().ToSymbol(""), periodS))
+}
 
-	var resp WsResponse
-	err := json.Unmarshal(msg, &resp)
-	if err != nil {
-		return err
+// UnsubscribeDepth stops the depth of pair, whichever size it was subscribed
+// with, including a SubscribeIncrementalDepth book.
+func (ws *SpotWs) UnsubscribeDepth(pair CurrencyPair) error {
+	symbol := pair.ToLower().ToSymbol("")
+	ws.depthRoutes.Unhandle(symbol)
+	var channels []string
+	for _, levels := range []int{5, 10, 20} {
+		channels = append(channels, fmt.Sprintf("market.%s.mbp.refresh.%d", symbol, levels))
+	}
+	for _, levels := range []int{5, 20, 150, 400} {
+		channels = append(channels, fmt.Sprintf("market.%s.mbp.%d", symbol, levels))
+	}
+	return ws.unsubscribe(channels...)
+}
+
+func (ws *SpotWs) UnsubscribeTicker(pair CurrencyPair) error {
+	ws.tickerRoutes.Unhandle(pair.ToLower().ToSymbol(""))
+	return ws.unsubscribe(fmt.Sprintf("market.%s.detail", pair.ToLower().ToSymbol("")))
+}
+
+func (ws *SpotWs) UnsubscribeTrade(pair CurrencyPair) error {
+	ws.tradeRoutes.Unhandle(pair.ToLower().ToSymbol(""))
+	return ws.unsubscribe(fmt.Sprintf("market.%s.trade.detail", pair.ToLower().ToSymbol("")))
+}
+
+func (ws *SpotWs) UnsubscribeKline(pair CurrencyPair, period int) error {
+	key := pair.ToLower().ToSymbol("") + "/" + _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	ws.klineRoutes.Unhandle(key)
+	periodS, isOk := _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	if !isOk {
+		return fmt.Errorf("unsupported kline period %d in huobi", period)
+	}
+	return ws.unsubscribe(fmt.Sprintf("market.%s.kline.%s", pair.ToLower().ToSymbol(""), periodS))
+}
+
+// SubscribeDepthChan subscribes to the depth of pair and delivers it on the
+// returned channel, alongside the depth callback if one is set. The channel
+// is closed once ctx is done, the pair stays subscribed until
+// UnsubscribeDepth.
+func (ws *SpotWs) SubscribeDepthChan(ctx context.Context, pair CurrencyPair, size int, opts ...common.StreamOption) (<-chan *Depth, error) {
+	return common.SubscribeChan(ctx, &ws.depthRoutes, pair.ToLower().ToSymbol(""), func() error {
+		return ws.subscribeDepth(pair, size)
+	}, opts...)
+}
+
+func (ws *SpotWs) SubscribeTickerChan(ctx context.Context, pair CurrencyPair, opts ...common.StreamOption) (<-chan *Ticker, error) {
+	return common.SubscribeChan(ctx, &ws.tickerRoutes, pair.ToLower().ToSymbol(""), func() error {
+		return ws.subscribeTicker(pair)
+	}, opts...)
+}
+
+func (ws *SpotWs) SubscribeTradeChan(ctx context.Context, pair CurrencyPair, opts ...common.StreamOption) (<-chan *Trade, error) {
+	return common.SubscribeChan(ctx, &ws.tradeRoutes, pair.ToLower().ToSymbol(""), func() error {
+		return ws.subscribeTrade(pair)
+	}, opts...)
+}
+
+func (ws *SpotWs) SubscribeKlineChan(ctx context.Context, pair CurrencyPair, period int, opts ...common.StreamOption) (<-chan *Kline, error) {
+	key := pair.ToLower().ToSymbol("") + "/" + _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	return common.SubscribeChan(ctx, &ws.klineRoutes, key, func() error {
+		return ws.subscribeKline(pair, period)
+	}, opts...)
+}
+
+// SubscribeDepthFunc subscribes to the depth of pair and hands it to call,
+// besides the depth callback if one is set. call replaces the one it was
+// subscribed with before and is dropped by UnsubscribeDepth.
+func (ws *SpotWs) SubscribeDepthFunc(pair CurrencyPair, size int, call func(depth *Depth)) error {
+	if call == nil {
+		return errors.New("please set depth callback func")
+	}
+	return common.SubscribeFunc(&ws.depthRoutes, pair.ToLower().ToSymbol(""), call, func() error {
+		return ws.subscribeDepth(pair, size)
+	})
+}
+
+func (ws *SpotWs) SubscribeTickerFunc(pair CurrencyPair, call func(ticker *Ticker)) error {
+	if call == nil {
+		return errors.New("please set ticker callback func")
+	}
+	return common.SubscribeFunc(&ws.tickerRoutes, pair.ToLower().ToSymbol(""), call, func() error {
+		return ws.subscribeTicker(pair)
+	})
+}
+
+func (ws *SpotWs) SubscribeTradeFunc(pair CurrencyPair, call func(trade *Trade)) error {
+	if call == nil {
+		return errors.New("please set trade callback func")
+	}
+	return common.SubscribeFunc(&ws.tradeRoutes, pair.ToLower().ToSymbol(""), call, func() error {
+		return ws.subscribeTrade(pair)
+	})
+}
+
+func (ws *SpotWs) SubscribeKlineFunc(pair CurrencyPair, period int, call func(kline *Kline)) error {
+	if call == nil {
+		return errors.New("please set kline callback func")
+	}
+	key := pair.ToLower().ToSymbol("") + "/" + _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	return common.SubscribeFunc(&ws.klineRoutes, key, call, func() error {
+		return ws.subscribeKline(pair, period)
+	})
+}
+
+// dispatch runs call through the dispatcher, a panic is recovered and
+// reported with the frame raw and data.
+func (ws *SpotWs) dispatch(key string, raw []byte, data interface{}, call func()) {
+	ws.dispatcher.Dispatch(key, func() {
+		ws.hooks.panics.Run(ws.hooks.errs.Report, raw, data, call)
+	})
+}
+
+func (ws *SpotWs) onDepth(key string, raw []byte, depth *Depth) {
+	ws.dispatch(key, raw, depth, func() {
+		if call := ws.depthCallback.Get(); call != nil {
+			call(depth)
+		}
+		ws.depthRoutes.Emit(key, depth)
+	})
+}
+
+func (ws *SpotWs) onTicker(key string, raw []byte, ticker *Ticker) {
+	ws.dispatch(key, raw, ticker, func() {
+		if call := ws.tickerCallback.Get(); call != nil {
+			call(ticker)
+		}
+		ws.tickerRoutes.Emit(key, ticker)
+	})
+}
+
+func (ws *SpotWs) onTrade(key string, raw []byte, trade *Trade) {
+	ws.dispatch(key, raw, trade, func() {
+		if call := ws.tradeCallback.Get(); call != nil {
+			call(trade)
+		}
+		ws.tradeRoutes.Emit(key, trade)
+	})
+}
+
+func (ws *SpotWs) onKline(key string, raw []byte, kline *Kline, period int) {
+	ws.dispatch(key, raw, kline, func() {
+		if call := ws.klineCallback.Get(); call != nil {
+			call(kline, period)
+		}
+		ws.klineRoutes.Emit(key, kline)
+	})
+}
+
+func (ws *SpotWs) handle(raw []byte, resp WsResponse) error {
+	if resp.Status == "error" && resp.Rep == "" {
+		// a refused snapshot request comes back with its id only
+		ws.booksLock.Lock()
+		book := ws.books[resp.Id]
+		ws.booksLock.Unlock()
+		if book != nil {
+			book.reject()
+		}
+		return &common.ServerError{Code: resp.ErrCode, Msg: resp.Id + ": " + resp.ErrMsg}
+	}
+
+	if strings.Contains(resp.Ch+resp.Rep, ".mbp.") && !strings.Contains(resp.Ch, "mbp.refresh") {
+		return ws.handleMbp(raw, resp)
 	}
 
 	currencyPair := ParseCurrencyPairFromSpotWsCh(resp.Ch)
@@ -109,24 +472,63 @@ func (ws *SpotWs) handle(msg []byte) error {
 
 		err := json.Unmarshal(resp.Tick, &depthResp)
 		if err != nil {
-			return err
+			return &common.ParseError{Raw: resp.Tick, Err: err}
 		}
 
 		dep := ParseDepthFromResponse(depthResp)
 		dep.Pair = currencyPair
 		dep.UTime = time.Unix(0, resp.Ts*int64(time.Millisecond))
-		ws.depthCallback(&dep)
+		common.TruncateDepth(&dep, ws.depthSizes.get(resp.Ch))
+		ws.onDepth(routeKey(resp.Ch), raw, &dep)
 
 		return nil
 	}
 
+	if strings.HasSuffix(resp.Ch, ".trade.detail") {
+		var tradeResp SpotTradeResponse
+		err := json.Unmarshal(resp.Tick, &tradeResp)
+		if err != nil {
+			return &common.ParseError{Raw: resp.Tick, Err: err}
+		}
+		for _, v := range tradeResp.Data {
+			ws.onTrade(routeKey(resp.Ch), raw, &Trade{
+				Tid:    v.TradeId,
+				Type:   AdaptTradeSide(v.Direction),
+				Amount: v.Amount,
+				Price:  v.Price,
+				Date:   v.Ts,
+				Pair:   currencyPair,
+			})
+		}
+		return nil
+	}
+
+	if strings.Contains(resp.Ch, ".kline.") {
+		var klineResp KlineResponse
+		err := json.Unmarshal(resp.Tick, &klineResp)
+		if err != nil {
+			return &common.ParseError{Raw: resp.Tick, Err: err}
+		}
+		period := resp.Ch[strings.LastIndex(resp.Ch, ".")+1:]
+		ws.onKline(routeKey(resp.Ch), raw, &Kline{
+			Pair:      currencyPair,
+			Timestamp: klineResp.Id,
+			Open:      klineResp.Open,
+			Close:     klineResp.Close,
+			High:      klineResp.High,
+			Low:       klineResp.Low,
+			Vol:       klineResp.Amount,
+		}, _INERNAL_KLINE_PERIOD_REVERTER[period])
+		return nil
+	}
+
 	if strings.Contains(resp.Ch, ".detail") {
 		var tickerResp DetailResponse
 		err := json.Unmarshal(resp.Tick, &tickerResp)
 		if err != nil {
-			return err
+			return &common.ParseError{Raw: resp.Tick, Err: err}
 		}
-		ws.tickerCallback(&Ticker{
+		ws.onTicker(routeKey(resp.Ch), raw, &Ticker{
 			Pair: currencyPair,
 			Last: tickerResp.Close,
 			High: tickerResp.High,
@@ -137,7 +539,5 @@ func (ws *SpotWs) handle(msg []byte) error {
 		return nil
 	}
 
-	//fmt.Errorf("[%s] unknown message ch , msg=%s", ws.wsConn.WsUrl, string(msg))
-
-	return nil
+	return &common.UnknownChannelError{Channel: resp.Ch}
 }
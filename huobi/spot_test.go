@@ -1,6 +1,7 @@
 package huobi
 
 import (
+	"github.com/goex-top/goexws/internal/wstest"
 	"github.com/nntaoli-project/goex"
 	"os"
 	"testing"
@@ -17,6 +18,12 @@ func TestNewSpotWs(t *testing.T) {
 	spotWs.TickerCallback(func(ticker *goex.Ticker) {
 		t.Log(ticker)
 	})
+	spotWs.TradeCallback(func(trade *goex.Trade) {
+		t.Log(trade)
+	})
+	spotWs.KlineCallback(func(kline *goex.Kline, period int) {
+		t.Log(period, kline)
+	})
 	spotWs.SubscribeTicker(goex.NewCurrencyPair2("BTC_USDT"))
 	spotWs.SubscribeTicker(goex.NewCurrencyPair2("USDT_HUSD"))
 	spotWs.SubscribeTicker(goex.NewCurrencyPair2("LTC_BTC"))
@@ -24,5 +31,42 @@ func TestNewSpotWs(t *testing.T) {
 	spotWs.SubscribeTicker(goex.NewCurrencyPair2("LTC_HT"))
 	spotWs.SubscribeTicker(goex.NewCurrencyPair2("BTT_TRX"))
 	//spotWs.SubscribeDepth(goex.BTC_USDT)
+	spotWs.SubscribeIncrementalDepth(goex.BTC_USDT, 150)
+	spotWs.SubscribeTrade(goex.BTC_USDT)
+	spotWs.SubscribeKline(goex.BTC_USDT, goex.KLINE_PERIOD_1MIN)
 	time.Sleep(time.Minute)
 }
+
+func TestSpotWs_Trade(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	ws := NewSpotWs()
+	ws.SetBaseUrl(srv.URL)
+	defer ws.Close()
+	errs := make(chan error, 1)
+	ws.ErrorCallback(func(err error) { errs <- err })
+	trades := make(chan *goex.Trade, 1)
+	ws.TradeCallback(func(trade *goex.Trade) { trades <- trade })
+	if err := ws.SubscribeTrade(goex.BTC_USDT); err != nil {
+		t.Fatal(err)
+	}
+	if !wstest.Eventually(time.Second, func() bool { return srv.Connected() == 1 }) {
+		t.Fatal("not connected")
+	}
+
+	// the id of a spot trade does not fit an int64
+	srv.Broadcast(`{"ch":"market.btcusdt.trade.detail","ts":1630994963175,"tick":{"id":137005445109,"ts":1630994963173,` +
+		`"data":[{"id":137005445109359286410323766,"ts":1630994963173,"tradeId":102523573486,"amount":0.006754,"price":52648.62,"direction":"buy"}]}}`)
+	select {
+	case trade := <-trades:
+		if trade.Tid != 102523573486 || trade.Price != 52648.62 || trade.Amount != 0.006754 ||
+			trade.Type != goex.BUY || trade.Date != 1630994963173 {
+			t.Errorf("got %+v", trade)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("no trade")
+	}
+}
@@ -1 +1,511 @@
 package huobi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/goex-top/goexws/common"
+	. "github.com/nntaoli-project/goex"
+	"strings"
+	"time"
+)
+
+// SwapWs streams Huobi perpetual swaps. Coin-margined contracts (BTC-USD) are
+// served by /swap-ws and USDT-margined ones (BTC-USDT) by /linear-swap-ws, the
+// socket is picked from the quote currency of the pair.
+type SwapWs struct {
+	coinWs   *marketConn
+	linearWs *marketConn
+	hooks    *hooks
+
+	tickerCallback common.Callback[func(*FutureTicker)]
+	depthCallback  common.Callback[func(*Depth)]
+	depthSizes     depthSizes
+	tradeCallback  common.Callback[func(*Trade, string)]
+	klineCallback  common.Callback[func(*FutureKline, int, string)]
+	dispatcher     common.Dispatcher
+	depthRoutes    common.Fanout[*Depth]
+	tickerRoutes   common.Fanout[*FutureTicker]
+	tradeRoutes    common.Fanout[*Trade]
+	klineRoutes    common.Fanout[*FutureKline]
+	tradeFuncs     common.Fanout[common.ContractTrade]
+	klineFuncs     common.Fanout[common.ContractKline]
+}
+
+func NewSwapWs() *SwapWs {
+	ws := &SwapWs{hooks: newHooks()}
+	ws.coinWs = newMarketConn("wss://api.hbdm.com/swap-ws", ws.hooks, ws.handle)
+	ws.linearWs = newMarketConn("wss://api.hbdm.com/linear-swap-ws", ws.hooks, ws.handle)
+	ws.dispatcher = common.SerialPerSymbol(0)
+	return ws
+}
+
+// SetBaseUrl sets the host both sockets are opened on, e.g.
+// wss://api.hbdm.com, it has to be called before subscribing.
+func (ws *SwapWs) SetBaseUrl(baseURL string) {
+	ws.coinWs.wsURL = baseURL + "/swap-ws"
+	ws.linearWs.wsURL = baseURL + "/linear-swap-ws"
+}
+
+func (ws *SwapWs) ProxyUrl(proxyUrl string) {
+	ws.coinWs.proxyUrl = proxyUrl
+	ws.linearWs.proxyUrl = proxyUrl
+}
+
+// SetDispatcher sets how the data callbacks are run, they are serial per
+// symbol by default. It has to be called before subscribing.
+func (ws *SwapWs) SetDispatcher(dispatcher common.Dispatcher) {
+	ws.dispatcher = dispatcher
+}
+
+// Close closes both connections and the subscription channels, no callback
+// is called once it returns. It must not be called from a callback.
+func (ws *SwapWs) Close() error {
+	err := ws.coinWs.close()
+	if e := ws.linearWs.close(); err == nil {
+		err = e
+	}
+	ws.dispatcher.Close()
+	ws.depthRoutes.Close()
+	ws.tickerRoutes.Close()
+	ws.tradeRoutes.Close()
+	ws.klineRoutes.Close()
+	ws.tradeFuncs.Close()
+	ws.klineFuncs.Close()
+	return err
+}
+
+// SubscribeFailedCallback is told about every channel the server rejected or
+// did not acknowledge in time, be it on the first subscription or on the
+// replay after a reconnect.
+func (ws *SwapWs) SubscribeFailedCallback(call func(channel string, err error)) {
+	ws.hooks.setFailed(call)
+}
+
+// ErrorCallback gets the messages that could not be parsed or handled, the
+// error replies of the server and the failed subscriptions.
+func (ws *SwapWs) ErrorCallback(call func(err error)) {
+	ws.hooks.errs.SetCallback(call)
+}
+
+// SetLogger sets where the connection lifecycle and the message errors are
+// logged, nothing is logged by default.
+func (ws *SwapWs) SetLogger(logger common.Logger) {
+	ws.hooks.log.SetLogger(logger)
+}
+
+// LogFrames turns debug logging of every raw frame sent and received on or
+// off, it takes effect right away.
+func (ws *SwapWs) LogFrames(on bool) {
+	ws.hooks.log.SetFrames(on)
+}
+
+// RecoverPanics turns the recovery of panics in the callbacks and message
+// handling on or off, it is on by default. A recovered panic is handed to the
+// error callback as a *common.PanicError and the connection stays up.
+func (ws *SwapWs) RecoverPanics(on bool) {
+	ws.hooks.panics.SetRecover(on)
+}
+
+// LifecycleCallback is told whenever the connection comes up, drops, is
+// redialed and has its subscriptions restored.
+func (ws *SwapWs) LifecycleCallback(call func(event *common.Event)) {
+	ws.hooks.events.SetCallback(call)
+}
+
+// SetMaxSilence sets how long channels of channelType may go without data
+// before the connection is dropped and redialed, 0 turns the check off. It
+// applies to the channels subscribed afterwards.
+func (ws *SwapWs) SetMaxSilence(channelType common.ChannelType, maxSilence time.Duration) {
+	ws.hooks.maxSilence.Set(channelType, maxSilence)
+}
+
+func (ws *SwapWs) SetCallbacks(tickerCallback func(*FutureTicker),
+	depthCallback func(*Depth),
+	tradeCallback func(*Trade, string),
+	klineCallback func(*FutureKline, int, string)) {
+	ws.tickerCallback.Set(tickerCallback)
+	ws.depthCallback.Set(depthCallback)
+	ws.tradeCallback.Set(tradeCallback)
+	ws.klineCallback.Set(klineCallback)
+}
+
+func (ws *SwapWs) TickerCallback(call func(ticker *FutureTicker)) {
+	ws.tickerCallback.Set(call)
+}
+
+func (ws *SwapWs) TradeCallback(call func(trade *Trade, contract string)) {
+	ws.tradeCallback.Set(call)
+}
+
+func (ws *SwapWs) DepthCallback(call func(depth *Depth)) {
+	ws.depthCallback.Set(call)
+}
+
+func (ws *SwapWs) KlineCallback(call func(*FutureKline, int, string)) {
+	ws.klineCallback.Set(call)
+}
+
+func (ws *SwapWs) SubscribeTicker(pair CurrencyPair, contract string) error {
+	if ws.tickerCallback.Get() == nil {
+		return errors.New("please set ticker callback func")
+	}
+	return ws.subscribeTicker(pair, contract)
+}
+
+func (ws *SwapWs) subscribeTicker(pair CurrencyPair, contract string) error {
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	return ws.subscribe(pair, fmt.Sprintf("market.%s.detail", ws.adaptContractCode(pair)))
+}
+
+func (ws *SwapWs) SubscribeDepth(pair CurrencyPair, size int, contract string) error {
+	if ws.depthCallback.Get() == nil {
+		return errors.New("please set depth callback func")
+	}
+	return ws.subscribeDepth(pair, size, contract)
+}
+
+func (ws *SwapWs) subscribeDepth(pair CurrencyPair, size int, contract string) error {
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	channelSize, err := common.DepthChannelSize("huobi", size, futuresDepthSizes)
+	if err != nil {
+		return err
+	}
+
+	ch := fmt.Sprintf("market.%s.depth.size_%d.high_freq", ws.adaptContractCode(pair), channelSize)
+	ws.depthSizes.set(ch, size)
+	return ws.subscribe(pair, ch)
+}
+
+func (ws *SwapWs) SubscribeTrade(pair CurrencyPair, contract string) error {
+	if ws.tradeCallback.Get() == nil {
+		return errors.New("please set trade callback func")
+	}
+	return ws.subscribeTrade(pair, contract)
+}
+
+func (ws *SwapWs) subscribeTrade(pair CurrencyPair, contract string) error {
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	return ws.subscribe(pair, fmt.Sprintf("market.%s.trade.detail", ws.adaptContractCode(pair)))
+}
+
+func (ws *SwapWs) SubscribeKline(pair CurrencyPair, period int, contract string) error {
+	if ws.klineCallback.Get() == nil {
+		return errors.New("place set kline callback func")
+	}
+	return ws.subscribeKline(pair, period, contract)
+}
+
+func (ws *SwapWs) subscribeKline(pair CurrencyPair, period int, contract string) error {
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	periodS, isOk := _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	if !isOk || period == KLINE_PERIOD_1YEAR {
+		return fmt.Errorf("unsupported kline period %d in huobi swap", period)
+	}
+	return ws.subscribe(pair, fmt.Sprintf("market.%s.kline.%s", ws.adaptContractCode(pair), periodS))
+}
+
+func (ws *SwapWs) conn(pair CurrencyPair) *marketConn {
+	if pair.CurrencyB.Symbol == USDT.Symbol {
+		return ws.linearWs
+	}
+	return ws.coinWs
+}
+
+func (ws *SwapWs) subscribe(pair CurrencyPair, ch string) error {
+	return ws.conn(pair).subscribe(ch)
+}
+
+// unsubscribe unsubs whichever of channels are subscribed.
+func (ws *SwapWs) unsubscribe(pair CurrencyPair, channels ...string) error {
+	for _, ch := range channels {
+		ok, err := ws.conn(pair).unsubscribe(ch)
+		if !ok {
+			continue
+		}
+		ws.depthSizes.remove(ch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnsubscribeDepth stops the depth of pair, whichever size it was subscribed
+// with.
+func (ws *SwapWs) UnsubscribeDepth(pair CurrencyPair, contract string) error {
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	ws.depthRoutes.Unhandle(ws.adaptContractCode(pair))
+	var channels []string
+	for _, size := range futuresDepthSizes {
+		channels = append(channels, fmt.Sprintf("market.%s.depth.size_%d.high_freq", ws.adaptContractCode(pair), size))
+	}
+	return ws.unsubscribe(pair, channels...)
+}
+
+func (ws *SwapWs) UnsubscribeTicker(pair CurrencyPair, contract string) error {
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	ws.tickerRoutes.Unhandle(ws.adaptContractCode(pair))
+	return ws.unsubscribe(pair, fmt.Sprintf("market.%s.detail", ws.adaptContractCode(pair)))
+}
+
+func (ws *SwapWs) UnsubscribeTrade(pair CurrencyPair, contract string) error {
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	ws.tradeFuncs.Unhandle(ws.adaptContractCode(pair))
+	return ws.unsubscribe(pair, fmt.Sprintf("market.%s.trade.detail", ws.adaptContractCode(pair)))
+}
+
+func (ws *SwapWs) UnsubscribeKline(pair CurrencyPair, period int, contract string) error {
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	key := ws.adaptContractCode(pair) + "/" + _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	ws.klineFuncs.Unhandle(key)
+	periodS, isOk := _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	if !isOk || period == KLINE_PERIOD_1YEAR {
+		return fmt.Errorf("unsupported kline period %d in huobi swap", period)
+	}
+	return ws.unsubscribe(pair, fmt.Sprintf("market.%s.kline.%s", ws.adaptContractCode(pair), periodS))
+}
+
+// SubscribeDepthChan subscribes to the depth of pair and delivers it on the
+// returned channel, alongside the depth callback if one is set. The channel
+// is closed once ctx is done, the pair stays subscribed until
+// UnsubscribeDepth.
+func (ws *SwapWs) SubscribeDepthChan(ctx context.Context, pair CurrencyPair, size int, contract string, opts ...common.StreamOption) (<-chan *Depth, error) {
+	return common.SubscribeChan(ctx, &ws.depthRoutes, ws.adaptContractCode(pair), func() error {
+		return ws.subscribeDepth(pair, size, contract)
+	}, opts...)
+}
+
+func (ws *SwapWs) SubscribeTickerChan(ctx context.Context, pair CurrencyPair, contract string, opts ...common.StreamOption) (<-chan *FutureTicker, error) {
+	return common.SubscribeChan(ctx, &ws.tickerRoutes, ws.adaptContractCode(pair), func() error {
+		return ws.subscribeTicker(pair, contract)
+	}, opts...)
+}
+
+func (ws *SwapWs) SubscribeTradeChan(ctx context.Context, pair CurrencyPair, contract string, opts ...common.StreamOption) (<-chan *Trade, error) {
+	return common.SubscribeChan(ctx, &ws.tradeRoutes, ws.adaptContractCode(pair), func() error {
+		return ws.subscribeTrade(pair, contract)
+	}, opts...)
+}
+
+func (ws *SwapWs) SubscribeKlineChan(ctx context.Context, pair CurrencyPair, period int, contract string, opts ...common.StreamOption) (<-chan *FutureKline, error) {
+	key := ws.adaptContractCode(pair) + "/" + _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	return common.SubscribeChan(ctx, &ws.klineRoutes, key, func() error {
+		return ws.subscribeKline(pair, period, contract)
+	}, opts...)
+}
+
+// SubscribeDepthFunc subscribes to the depth of pair and hands it to call,
+// besides the depth callback if one is set. call replaces the one it was
+// subscribed with before and is dropped by UnsubscribeDepth.
+func (ws *SwapWs) SubscribeDepthFunc(pair CurrencyPair, size int, contract string, call func(depth *Depth)) error {
+	if call == nil {
+		return errors.New("please set depth callback func")
+	}
+	return common.SubscribeFunc(&ws.depthRoutes, ws.adaptContractCode(pair), call, func() error {
+		return ws.subscribeDepth(pair, size, contract)
+	})
+}
+
+func (ws *SwapWs) SubscribeTickerFunc(pair CurrencyPair, contract string, call func(ticker *FutureTicker)) error {
+	if call == nil {
+		return errors.New("please set ticker callback func")
+	}
+	return common.SubscribeFunc(&ws.tickerRoutes, ws.adaptContractCode(pair), call, func() error {
+		return ws.subscribeTicker(pair, contract)
+	})
+}
+
+func (ws *SwapWs) SubscribeTradeFunc(pair CurrencyPair, contract string, call func(trade *Trade, contract string)) error {
+	if call == nil {
+		return errors.New("please set trade callback func")
+	}
+	return common.SubscribeFunc(&ws.tradeFuncs, ws.adaptContractCode(pair), func(t common.ContractTrade) {
+		call(t.Trade, t.Contract)
+	}, func() error {
+		return ws.subscribeTrade(pair, contract)
+	})
+}
+
+func (ws *SwapWs) SubscribeKlineFunc(pair CurrencyPair, period int, contract string, call func(kline *FutureKline, period int, contract string)) error {
+	if call == nil {
+		return errors.New("please set kline callback func")
+	}
+	key := ws.adaptContractCode(pair) + "/" + _INERNAL_KLINE_PERIOD_CONVERTER[period]
+	return common.SubscribeFunc(&ws.klineFuncs, key, func(k common.ContractKline) {
+		call(k.Kline, k.Period, k.Contract)
+	}, func() error {
+		return ws.subscribeKline(pair, period, contract)
+	})
+}
+
+// dispatch runs call through the dispatcher, a panic is recovered and
+// reported with the frame raw and data.
+func (ws *SwapWs) dispatch(key string, raw []byte, data interface{}, call func()) {
+	ws.dispatcher.Dispatch(key, func() {
+		ws.hooks.panics.Run(ws.hooks.errs.Report, raw, data, call)
+	})
+}
+
+func (ws *SwapWs) onDepth(key string, raw []byte, depth *Depth) {
+	ws.dispatch(key, raw, depth, func() {
+		if call := ws.depthCallback.Get(); call != nil {
+			call(depth)
+		}
+		ws.depthRoutes.Emit(key, depth)
+	})
+}
+
+func (ws *SwapWs) onTicker(key string, raw []byte, ticker *FutureTicker) {
+	ws.dispatch(key, raw, ticker, func() {
+		if call := ws.tickerCallback.Get(); call != nil {
+			call(ticker)
+		}
+		ws.tickerRoutes.Emit(key, ticker)
+	})
+}
+
+func (ws *SwapWs) onTrade(key string, raw []byte, trade *Trade, contract string) {
+	ws.dispatch(key, raw, trade, func() {
+		if call := ws.tradeCallback.Get(); call != nil {
+			call(trade, contract)
+		}
+		ws.tradeRoutes.Emit(key, trade)
+		ws.tradeFuncs.Emit(key, common.ContractTrade{Trade: trade, Contract: contract})
+	})
+}
+
+func (ws *SwapWs) onKline(key string, raw []byte, kline *FutureKline, period int, contract string) {
+	ws.dispatch(key, raw, kline, func() {
+		if call := ws.klineCallback.Get(); call != nil {
+			call(kline, period, contract)
+		}
+		ws.klineRoutes.Emit(key, kline)
+		ws.klineFuncs.Emit(key, common.ContractKline{Kline: kline, Period: period, Contract: contract})
+	})
+}
+
+func (ws *SwapWs) handle(raw []byte, resp WsResponse) error {
+	if resp.Status == "error" {
+		return &common.ServerError{Code: resp.ErrCode, Msg: resp.ErrMsg}
+	}
+	if resp.Ch == "" {
+		return nil
+	}
+
+	el := strings.Split(resp.Ch, ".")
+	if len(el) < 3 {
+		return &common.UnknownChannelError{Channel: resp.Ch}
+	}
+	pair := NewCurrencyPair3(el[1], "-")
+
+	if strings.Contains(resp.Ch, ".depth.") {
+		var depResp DepthResponse
+		err := json.Unmarshal(resp.Tick, &depResp)
+		if err != nil {
+			return &common.ParseError{Raw: resp.Tick, Err: err}
+		}
+
+		dep := ParseDepthFromResponse(depResp)
+		dep.ContractType = SWAP_CONTRACT
+		dep.Pair = pair
+		dep.UTime = time.Unix(0, resp.Ts*int64(time.Millisecond))
+		common.TruncateDepth(&dep, ws.depthSizes.get(resp.Ch))
+
+		ws.onDepth(routeKey(resp.Ch), raw, &dep)
+		return nil
+	}
+
+	if strings.Contains(resp.Ch, ".kline.") {
+		var klineResp KlineResponse
+		err := json.Unmarshal(resp.Tick, &klineResp)
+		if err != nil {
+			return &common.ParseError{Raw: resp.Tick, Err: err}
+		}
+		ws.onKline(routeKey(resp.Ch), raw, &FutureKline{
+			Kline: &Kline{
+				Pair:      pair,
+				Timestamp: klineResp.Id,
+				Open:      klineResp.Open,
+				Close:     klineResp.Close,
+				High:      klineResp.High,
+				Low:       klineResp.Low,
+				Vol:       klineResp.Vol,
+			},
+			Vol2: klineResp.Amount,
+		}, _INERNAL_KLINE_PERIOD_REVERTER[el[len(el)-1]], SWAP_CONTRACT)
+		return nil
+	}
+
+	if strings.HasSuffix(resp.Ch, "trade.detail") {
+		var tradeResp TradeResponse
+		err := json.Unmarshal(resp.Tick, &tradeResp)
+		if err != nil {
+			return &common.ParseError{Raw: resp.Tick, Err: err}
+		}
+		for _, v := range tradeResp.Data {
+			ws.onTrade(routeKey(resp.Ch), raw, &Trade{
+				Tid:    v.Id,
+				Price:  v.Price,
+				Amount: v.Amount,
+				Type:   AdaptTradeSide(v.Direction),
+				Date:   v.Ts,
+				Pair:   pair}, SWAP_CONTRACT)
+		}
+		return nil
+	}
+
+	if strings.HasSuffix(resp.Ch, ".detail") {
+		var detail DetailResponse
+		err := json.Unmarshal(resp.Tick, &detail)
+		if err != nil {
+			return &common.ParseError{Raw: resp.Tick, Err: err}
+		}
+		ticker := &Ticker{
+			Pair: pair,
+			Last: detail.Close,
+			High: detail.High,
+			Low:  detail.Low,
+			Vol:  detail.Amount,
+			Date: uint64(resp.Ts)}
+		if len(detail.Bid) > 0 {
+			ticker.Buy = detail.Bid[0]
+		}
+		if len(detail.Ask) > 0 {
+			ticker.Sell = detail.Ask[0]
+		}
+		ws.onTicker(routeKey(resp.Ch), raw, &FutureTicker{Ticker: ticker, ContractType: SWAP_CONTRACT})
+		return nil
+	}
+
+	return &common.UnknownChannelError{Channel: resp.Ch}
+}
+
+// checkContract rejects the contract types other than SWAP_CONTRACT, the
+// socket only serves perpetual swaps.
+func checkContract(contract string) error {
+	if contract != SWAP_CONTRACT {
+		return fmt.Errorf("unsupported contract type %s in huobi swap", contract)
+	}
+	return nil
+}
+
+func (ws *SwapWs) adaptContractCode(pair CurrencyPair) string {
+	return strings.ToUpper(pair.ToSymbol("-"))
+}
@@ -1 +1,45 @@
 package huobi
+
+import (
+	"github.com/nntaoli-project/goex"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewSwapWs(t *testing.T) {
+	os.Setenv("HTTPS_PROXY", "socks5://127.0.0.1:1080")
+	swapWs := NewSwapWs()
+	swapWs.DepthCallback(func(depth *goex.Depth) {
+		t.Log(depth.Pair, "asks=", depth.AskList)
+		t.Log(depth.Pair, "bids=", depth.BidList)
+	})
+	swapWs.TickerCallback(func(ticker *goex.FutureTicker) {
+		t.Log(ticker.Ticker)
+	})
+	swapWs.TradeCallback(func(trade *goex.Trade, contract string) {
+		t.Log(contract, trade)
+	})
+	swapWs.KlineCallback(func(kline *goex.FutureKline, period int, contract string) {
+		t.Log(contract, period, kline.Kline, kline.Vol2)
+	})
+	swapWs.SubscribeTicker(goex.BTC_USD, goex.SWAP_CONTRACT)
+	swapWs.SubscribeDepth(goex.BTC_USDT, 20, goex.SWAP_CONTRACT)
+	swapWs.SubscribeTrade(goex.ETH_USD, goex.SWAP_CONTRACT)
+	swapWs.SubscribeKline(goex.ETH_USDT, goex.KLINE_PERIOD_1MIN, goex.SWAP_CONTRACT)
+	time.Sleep(time.Minute)
+}
+
+func TestSwapWs_Contract(t *testing.T) {
+	ws := NewSwapWs()
+	defer ws.Close()
+	ws.TickerCallback(func(ticker *goex.FutureTicker) {})
+	for _, contract := range []string{goex.THIS_WEEK_CONTRACT, goex.QUARTER_CONTRACT} {
+		if err := ws.SubscribeTicker(goex.BTC_USD, contract); err == nil {
+			t.Errorf("%s subscribed as a swap", contract)
+		}
+		if err := ws.UnsubscribeTicker(goex.BTC_USD, contract); err == nil {
+			t.Errorf("%s unsubscribed as a swap", contract)
+		}
+	}
+}
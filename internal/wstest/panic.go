@@ -0,0 +1,51 @@
+package wstest
+
+import (
+	"testing"
+	"time"
+)
+
+// Panic is an adapter under CheckPanic.
+type Panic struct {
+	// Subscribe subscribes the adapter to the server over one connection,
+	// its errors go to Errors.
+	Subscribe func() error
+	// Frames are broadcast once the adapter is connected, the callback
+	// panics on the first and signals the second on Calls.
+	Frames [2]string
+	Calls  chan struct{}
+	Errors chan error
+}
+
+// CheckPanic feeds the adapter on srv a frame its callback panics on and a
+// frame after it. The panic has to be reported and the second frame handled
+// over the same connection, the error the panic was reported with is
+// returned.
+func CheckPanic(t *testing.T, srv *Server, p Panic) error {
+	t.Helper()
+	if err := p.Subscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if !Eventually(time.Second, func() bool { return srv.Connected() == 1 }) {
+		t.Fatal("not connected")
+	}
+
+	srv.Broadcast(p.Frames[0])
+	srv.Broadcast(p.Frames[1])
+	var err error
+	select {
+	case err = <-p.Errors:
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+	// the feed carries on
+	select {
+	case <-p.Calls:
+	case <-time.After(time.Second):
+		t.Fatal("no callback after the panic")
+	}
+	if srv.Accepted() != 1 {
+		t.Fatalf("%d connections, want 1", srv.Accepted())
+	}
+	return err
+}
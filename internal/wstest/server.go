@@ -0,0 +1,141 @@
+// Package wstest runs a local websocket server for the adapter tests.
+package wstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is the server side of one client connection.
+type Conn struct {
+	ws   *websocket.Conn
+	lock sync.Mutex
+}
+
+func (c *Conn) Send(msg string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ws.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+func (c *Conn) Close() error {
+	return c.ws.Close()
+}
+
+// Server accepts websocket clients and hands every message they send to
+// handle, which may answer on the connection.
+type Server struct {
+	*httptest.Server
+	// URL is the ws:// address of the server.
+	URL string
+
+	handle   func(c *Conn, msg string)
+	upgrader websocket.Upgrader
+
+	lock     sync.Mutex
+	conns    []*Conn
+	accepted int
+	received []string
+}
+
+func NewServer(handle func(c *Conn, msg string)) *Server {
+	s := &Server{handle: handle}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = "ws" + strings.TrimPrefix(s.Server.URL, "http")
+	return s
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &Conn{ws: ws}
+	s.lock.Lock()
+	s.conns = append(s.conns, c)
+	s.accepted++
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		for i, conn := range s.conns {
+			if conn == c {
+				s.conns = append(s.conns[:i], s.conns[i+1:]...)
+				break
+			}
+		}
+		s.lock.Unlock()
+		ws.Close()
+	}()
+
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.received = append(s.received, string(msg))
+		s.lock.Unlock()
+		if s.handle != nil {
+			s.handle(c, string(msg))
+		}
+	}
+}
+
+// Broadcast sends msg to every connected client.
+func (s *Server) Broadcast(msg string) {
+	s.lock.Lock()
+	conns := append([]*Conn(nil), s.conns...)
+	s.lock.Unlock()
+	for _, c := range conns {
+		c.Send(msg)
+	}
+}
+
+// Drop closes every client connection, the clients see a network error.
+func (s *Server) Drop() {
+	s.lock.Lock()
+	conns := append([]*Conn(nil), s.conns...)
+	s.lock.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// Accepted returns how many connections were accepted so far.
+func (s *Server) Accepted() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.accepted
+}
+
+// Connected returns how many clients are connected right now.
+func (s *Server) Connected() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.conns)
+}
+
+// Received returns every message received so far.
+func (s *Server) Received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.received...)
+}
+
+// Eventually polls cond until it holds or timeout passes.
+func Eventually(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
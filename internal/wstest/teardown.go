@@ -0,0 +1,80 @@
+package wstest
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Teardown is an adapter under CheckTeardown.
+type Teardown struct {
+	// Subscribe subscribes the adapter to the server, its callbacks signal
+	// on Calls.
+	Subscribe func() error
+	// Conns is how many connections Subscribe opens.
+	Conns int
+	// Frames are broadcast once the adapter is connected, each one is
+	// expected to reach a callback.
+	Frames []string
+	Calls  chan struct{}
+	Close  func() error
+	// Closed checks the adapter once it is closed, e.g. that it refuses to
+	// subscribe and closed its subscription channels.
+	Closed func() error
+}
+
+// CheckTeardown subscribes the adapter on srv, feeds it the frames and
+// closes it. Once Close returns no callback may fire and every goroutine the
+// adapter started has to be gone.
+func CheckTeardown(t *testing.T, srv *Server, td Teardown) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+
+	if err := td.Subscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if !Eventually(time.Second, func() bool { return srv.Connected() == td.Conns }) {
+		t.Fatalf("%d connections, want %d", srv.Connected(), td.Conns)
+	}
+	for _, frame := range td.Frames {
+		srv.Broadcast(frame)
+		select {
+		case <-td.Calls:
+		case <-time.After(time.Second):
+			t.Fatalf("no callback for %s", frame)
+		}
+	}
+
+	if err := td.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for len(td.Calls) > 0 {
+		<-td.Calls
+	}
+	if td.Closed != nil {
+		if err := td.Closed(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !Eventually(time.Second, func() bool { return runtime.NumGoroutine() <= before }) {
+		t.Fatalf("%d goroutines left, want %d", runtime.NumGoroutine(), before)
+	}
+	if len(td.Calls) != 0 {
+		t.Fatal("callback called after the close")
+	}
+}
+
+// Closed checks a closed adapter: ch, one of its subscription channels, has
+// to be closed and subscribeErr, what subscribing returned after the close,
+// has to be want.
+func Closed[T any](ch <-chan T, subscribeErr, want error) error {
+	if _, ok := <-ch; ok {
+		return errors.New("subscription channel open after the close")
+	}
+	if subscribeErr != want {
+		return fmt.Errorf("subscribe after the close got %v, want %v", subscribeErr, want)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/goex-top/goexws/common"
 	. "github.com/nntaoli-project/goex"
 )
 
@@ -15,39 +16,70 @@ type wsResp struct {
 	Event     string `json:"event"`
 	Channel   string `json:"channel"`
 	Table     string `json:"table"`
+	Action    string `json:"action"`
+	Message   string `json:"message"`
 	Data      json.RawMessage
 	Success   bool        `json:"success"`
 	ErrorCode interface{} `json:"errorCode"`
 }
 
 type baseWs struct {
-	*WsBuilder
+	wsURL      string
+	proxyUrl   string
 	once       *sync.Once
-	WsConn     *WsConn
-	respHandle func(channel string, data json.RawMessage) error
+	conn       *common.Conn
+	subs       *common.Subscriptions
+	events     common.Events
+	errs       common.Errors
+	log        common.Log
+	panics     common.Panics
+	maxSilence *common.MaxSilence
+	failedLock sync.Mutex
+	failed     func(channel string, err error)
+	clock      common.Clock
+	respHandle func(channel string, raw []byte, data json.RawMessage) error
+
+	// full depth books and requested depth sizes, keyed by channel
+	books          map[string]*depthBook
+	depthSizes     map[string]int
+	booksLock      sync.Mutex
+	bookHandle     func(table string, instrumentId string, raw []byte, depth *Depth)
+	resyncCallback common.Callback[func(channel string, err error)]
 }
 
-func NewOKExV3Ws(handle func(channel string, data json.RawMessage) error) *baseWs {
+func NewOKExV3Ws(handle func(channel string, raw []byte, data json.RawMessage) error) *baseWs {
 	okV3Ws := &baseWs{
+		wsURL:      "wss://real.okex.com:8443/ws/v3",
 		once:       new(sync.Once),
 		respHandle: handle,
+		books:      make(map[string]*depthBook),
+		depthSizes: make(map[string]int),
+		maxSilence: common.NewMaxSilence(),
 	}
-	okV3Ws.WsBuilder = NewWsBuilder().
-		WsUrl("wss://real.okex.com:8443/ws/v3").
-		ReconnectInterval(time.Second).
-		AutoReconnect().
-		Heartbeat(func() []byte { return []byte("ping") }, 28*time.Second).
-		DecompressFunc(FlateDecompress).ProtoHandleFunc(okV3Ws.handle)
+	okV3Ws.subs = common.NewSubscriptions(func(msg interface{}) error {
+		return okV3Ws.conn.SendJSON(msg)
+	})
+	okV3Ws.subs.FailedCallback(okV3Ws.subscribeFailed)
+	okV3Ws.subs.ResubscribedCallback(func(failed []string) {
+		okV3Ws.events.Emit(common.Event{Type: common.Resubscribed, URL: okV3Ws.wsURL, Failed: failed})
+	})
 	return okV3Ws
 }
 
-func (okV3Ws *baseWs) clearChan(c chan wsResp) {
-	for {
-		if len(c) > 0 {
-			<-c
-		} else {
-			break
-		}
+func (okV3Ws *baseWs) setFailedCallback(call func(channel string, err error)) {
+	okV3Ws.failedLock.Lock()
+	okV3Ws.failed = call
+	okV3Ws.failedLock.Unlock()
+}
+
+// subscribeFailed reports channel to both the error and the failed callback.
+func (okV3Ws *baseWs) subscribeFailed(channel string, err error) {
+	okV3Ws.errs.Report(&common.SubscribeError{Channel: channel, Err: err})
+	okV3Ws.failedLock.Lock()
+	call := okV3Ws.failed
+	okV3Ws.failedLock.Unlock()
+	if call != nil {
+		call(channel, err)
 	}
 }
 
@@ -60,10 +92,53 @@ func (okV3Ws *baseWs) getTablePrefix(currencyPair CurrencyPair, contractType str
 
 func (okV3Ws *baseWs) ConnectWs() {
 	okV3Ws.once.Do(func() {
-		okV3Ws.WsConn = okV3Ws.WsBuilder.Build()
+		okV3Ws.conn = common.NewConn(common.ConnConfig{
+			URL:               okV3Ws.wsURL,
+			ProxyURL:          okV3Ws.proxyUrl,
+			ReconnectInterval: time.Second,
+			Heartbeat:         func() []byte { return []byte("ping") },
+			HeartbeatInterval: 28 * time.Second,
+			Decompress:        FlateDecompress,
+			Handle:            okV3Ws.handle,
+			OnConnected:       okV3Ws.connected,
+			OnDisconnected:    okV3Ws.disconnected,
+			OnEvent:           okV3Ws.events.Emit,
+			OnError:           okV3Ws.errs.Report,
+			Log:               &okV3Ws.log,
+			Panics:            &okV3Ws.panics,
+			Clock:             okV3Ws.clock,
+		})
+		okV3Ws.conn.Start()
 	})
 }
 
+// Close closes the connection and stops the ack timers, no callback is called
+// once it returns.
+func (okV3Ws *baseWs) Close() error {
+	// no connection is made from now on
+	okV3Ws.once.Do(func() {})
+	var err error
+	if okV3Ws.conn != nil {
+		err = okV3Ws.conn.Close()
+	}
+	okV3Ws.subs.Close()
+	return err
+}
+
+// connected replays every subscription on a fresh connection.
+func (okV3Ws *baseWs) connected() {
+	okV3Ws.subs.Connected()
+}
+
+// disconnected drops the depth books, the replayed subscriptions start them
+// over from a partial.
+func (okV3Ws *baseWs) disconnected(err error) {
+	okV3Ws.subs.Disconnected()
+	okV3Ws.booksLock.Lock()
+	okV3Ws.books = make(map[string]*depthBook)
+	okV3Ws.booksLock.Unlock()
+}
+
 func (okV3Ws *baseWs) parseChannel(channel string) (string, error) {
 	metas := strings.Split(channel, "/")
 	if len(metas) != 2 {
@@ -82,7 +157,6 @@ func (okV3Ws *baseWs) getKlinePeriodFormChannel(channel string) int {
 }
 
 func (okV3Ws *baseWs) handle(msg []byte) error {
-	//logger.Debug("[ws] [response] ", string(msg))
 	if string(msg) == "pong" {
 		return nil
 	}
@@ -90,40 +164,117 @@ func (okV3Ws *baseWs) handle(msg []byte) error {
 	var wsResp wsResp
 	err := json.Unmarshal(msg, &wsResp)
 	if err != nil {
-		//logger.Error(err)
-		return err
+		return &common.ParseError{Raw: msg, Err: err}
 	}
 
 	if wsResp.ErrorCode != nil {
-		//logger.Error(string(msg))
-		return fmt.Errorf("%s", string(msg))
+		serverErr := &common.ServerError{Code: fmt.Sprint(wsResp.ErrorCode), Msg: wsResp.Message}
+		// the error event does not name the channel, only its message does
+		rejected := false
+		for _, ch := range okV3Ws.subs.Channels() {
+			if strings.Contains(wsResp.Message, ch) && okV3Ws.subs.Reject(ch, serverErr) {
+				rejected = true
+			}
+		}
+		if rejected {
+			return nil
+		}
+		return serverErr
 	}
 
 	if wsResp.Event != "" {
 		switch wsResp.Event {
 		case "subscribe":
-			//logger.Info("subscribed:", wsResp.Channel)
+			okV3Ws.log.Logger().Debug("okex subscribed", "channel", wsResp.Channel)
+			okV3Ws.subs.Ack(wsResp.Channel)
+			return nil
+		case "unsubscribe":
 			return nil
-		case "error":
-			//logger.Errorf(string(msg))
-		default:
-			//logger.Info(string(msg))
 		}
-		return fmt.Errorf("unknown websocket message: %v", wsResp)
+		return &common.UnknownChannelError{Channel: wsResp.Channel, Raw: msg}
 	}
 
 	if wsResp.Table != "" {
-		err = okV3Ws.respHandle(wsResp.Table, wsResp.Data)
-		if err != nil {
-			//logger.Error("handle ws data error:", err)
-		}
-		return err
+		okV3Ws.feed(wsResp.Table, wsResp.Data)
 	}
 
-	return fmt.Errorf("unknown websocket message: %v", wsResp)
+	if wsResp.Table != "" && wsResp.Action != "" {
+		return okV3Ws.handleBook(wsResp.Table, wsResp.Action, msg, wsResp.Data)
+	}
+
+	if wsResp.Table != "" {
+		return okV3Ws.respHandle(wsResp.Table, msg, wsResp.Data)
+	}
+
+	return &common.UnknownChannelError{Raw: msg}
+}
+
+// feed tells the watchdog that table has data for the instruments in data.
+func (okV3Ws *baseWs) feed(table string, data json.RawMessage) {
+	var instruments []struct {
+		InstrumentId string `json:"instrument_id"`
+	}
+	if json.Unmarshal(data, &instruments) != nil {
+		return
+	}
+	for _, inst := range instruments {
+		okV3Ws.conn.Feed(table + ":" + inst.InstrumentId)
+	}
+}
+
+func (okV3Ws *baseWs) setDepthSize(channel string, size int) {
+	okV3Ws.booksLock.Lock()
+	okV3Ws.depthSizes[channel] = size
+	okV3Ws.booksLock.Unlock()
+}
+
+// depthSize returns how many levels were asked for on channel, 0 if the
+// whole book should be passed on.
+func (okV3Ws *baseWs) depthSize(channel string) int {
+	okV3Ws.booksLock.Lock()
+	defer okV3Ws.booksLock.Unlock()
+	return okV3Ws.depthSizes[channel]
 }
 
+// Subscribe registers every channel in sub's args, they are sent right away
+// when connected and replayed after every reconnect.
 func (okV3Ws *baseWs) Subscribe(sub map[string]interface{}) error {
 	okV3Ws.ConnectWs()
-	return okV3Ws.WsConn.Subscribe(sub)
+	if okV3Ws.conn == nil {
+		return common.ErrClosed
+	}
+	args, _ := sub["args"].([]string)
+	for _, ch := range args {
+		okV3Ws.conn.Watch(ch, okV3Ws.maxSilence.Get(channelType(ch)))
+		err := okV3Ws.subs.Subscribe(ch, map[string]interface{}{
+			"op":   "subscribe",
+			"args": []string{ch}})
+		// a connection that just dropped replays it on the way back
+		if err != nil && err != common.ErrNotConnected {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unsubscribe drops whichever of channels are subscribed along with their
+// depth books.
+func (okV3Ws *baseWs) Unsubscribe(channels ...string) error {
+	for _, ch := range channels {
+		okV3Ws.booksLock.Lock()
+		delete(okV3Ws.books, ch)
+		delete(okV3Ws.depthSizes, ch)
+		okV3Ws.booksLock.Unlock()
+		if okV3Ws.conn != nil {
+			okV3Ws.conn.Unwatch(ch)
+		}
+
+		_, err := okV3Ws.subs.Unsubscribe(ch, map[string]interface{}{
+			"op":   "unsubscribe",
+			"args": []string{ch}})
+		if err != nil && err != common.ErrNotConnected {
+			return err
+		}
+	}
+	return nil
 }
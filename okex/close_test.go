@@ -0,0 +1,96 @@
+package okex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goex-top/goexws/common"
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/nntaoli-project/goex"
+)
+
+func TestSpotWs_Close(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	ws := NewSpotWs()
+	ws.SetBaseUrl(srv.URL)
+	calls := make(chan struct{}, 16)
+	ws.TickerCallback(func(ticker *goex.Ticker) { calls <- struct{}{} })
+	var trades <-chan *goex.Trade
+	wstest.CheckTeardown(t, srv, wstest.Teardown{
+		Subscribe: func() (err error) {
+			if err = ws.SubscribeTicker(goex.BTC_USDT); err != nil {
+				return err
+			}
+			trades, err = ws.SubscribeTradeChan(context.Background(), goex.BTC_USDT)
+			return err
+		},
+		Conns:  1,
+		Frames: []string{`{"table":"spot/ticker","data":[{"instrument_id":"BTC-USDT","last":"1"}]}`},
+		Calls:  calls,
+		Close:  ws.Close,
+		Closed: func() error {
+			return wstest.Closed(trades, ws.SubscribeTicker(goex.ETH_USDT), common.ErrClosed)
+		},
+	})
+}
+
+// TestFuturesWs_Close subscribes a delivery contract, which starts the loop
+// following the rollovers.
+func TestFuturesWs_Close(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	ws := NewFuturesWs()
+	ws.SetBaseUrl(srv.URL)
+	ws.SetInstrumentSource(func() ([]FuturesInstrument, error) {
+		return []FuturesInstrument{{InstrumentId: "BTC-USD-991231", Underlying: "BTC-USD", Alias: goex.QUARTER_CONTRACT, Delivery: "2099-12-31"}}, nil
+	})
+	calls := make(chan struct{}, 16)
+	ws.TickerCallback(func(ticker *goex.FutureTicker) { calls <- struct{}{} })
+	var trades <-chan *goex.Trade
+	wstest.CheckTeardown(t, srv, wstest.Teardown{
+		Subscribe: func() (err error) {
+			if err = ws.SubscribeTicker(goex.BTC_USD, goex.QUARTER_CONTRACT); err != nil {
+				return err
+			}
+			trades, err = ws.SubscribeTradeChan(context.Background(), goex.BTC_USD, goex.QUARTER_CONTRACT)
+			return err
+		},
+		Conns:  1,
+		Frames: []string{`{"table":"futures/ticker","data":[{"instrument_id":"BTC-USD-991231","last":"1"}]}`},
+		Calls:  calls,
+		Close:  ws.Close,
+		Closed: func() error {
+			return wstest.Closed(trades, ws.SubscribeTicker(goex.ETH_USD, goex.QUARTER_CONTRACT), common.ErrClosed)
+		},
+	})
+}
+
+func TestSwapWs_Close(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	ws := NewSwapWs()
+	ws.SetBaseUrl(srv.URL)
+	calls := make(chan struct{}, 16)
+	ws.TickerCallback(func(ticker *goex.FutureTicker) { calls <- struct{}{} })
+	var trades <-chan *goex.Trade
+	wstest.CheckTeardown(t, srv, wstest.Teardown{
+		Subscribe: func() (err error) {
+			if err = ws.SubscribeTicker(goex.BTC_USDT, goex.SWAP_CONTRACT); err != nil {
+				return err
+			}
+			trades, err = ws.SubscribeTradeChan(context.Background(), goex.BTC_USDT, goex.SWAP_CONTRACT)
+			return err
+		},
+		Conns:  1,
+		Frames: []string{`{"table":"swap/ticker","data":[{"instrument_id":"BTC-USDT-SWAP","last":"1"}]}`},
+		Calls:  calls,
+		Close:  ws.Close,
+		Closed: func() error {
+			return wstest.Closed(trades, ws.SubscribeTicker(goex.ETH_USDT, goex.SWAP_CONTRACT), common.ErrClosed)
+		},
+	})
+}
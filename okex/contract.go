@@ -0,0 +1,137 @@
+package okex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/nntaoli-project/goex"
+)
+
+type FuturesInstrument struct {
+	InstrumentId string `json:"instrument_id"`
+	Underlying   string `json:"underlying"`
+	Alias        string `json:"alias"`
+	Delivery     string `json:"delivery"`
+}
+
+// InstrumentSource lists the delivery futures instruments currently trading.
+type InstrumentSource func() ([]FuturesInstrument, error)
+
+// RestInstrumentSource reads the instruments from the OKEx v3 REST api,
+// e.g. https://www.okex.com/api/futures/v3/instruments
+func RestInstrumentSource(client *http.Client, url string) InstrumentSource {
+	return func() ([]FuturesInstrument, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("get instruments: %s %s", resp.Status, string(body))
+		}
+
+		var instruments []FuturesInstrument
+		err = json.Unmarshal(body, &instruments)
+		return instruments, err
+	}
+}
+
+// okexDeliveryHour is the UTC hour on Fridays at which OKEx settles and
+// rolls the this_week/next_week/quarter aliases over.
+const okexDeliveryHour = 8
+
+// contractResolver maps a pair and goex contract type to the live instrument
+// id, e.g. BTC_USD + quarter -> BTC-USD-211231. The mapping is cached until
+// the next weekly settlement.
+type contractResolver struct {
+	mu     sync.Mutex
+	source InstrumentSource
+	now    func() time.Time
+	ids    map[string]string
+	expire time.Time
+}
+
+func newContractResolver(source InstrumentSource) *contractResolver {
+	return &contractResolver{source: source, now: time.Now}
+}
+
+// setSource swaps the lookup of the instruments, the ids resolved with the
+// old one are dropped.
+func (r *contractResolver) setSource(source InstrumentSource) {
+	r.mu.Lock()
+	r.source = source
+	r.ids = nil
+	r.mu.Unlock()
+}
+
+func (r *contractResolver) key(pair CurrencyPair, contractType string) string {
+	return strings.ToUpper(pair.ToSymbol("-")) + ":" + contractType
+}
+
+func (r *contractResolver) Resolve(pair CurrencyPair, contractType string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ids == nil || !r.now().Before(r.expire) {
+		if err := r.refresh(); err != nil {
+			return "", err
+		}
+	}
+
+	id, ok := r.ids[r.key(pair, contractType)]
+	if !ok {
+		return "", fmt.Errorf("no %s contract for %s in okex", contractType, pair.ToSymbol("-"))
+	}
+	return id, nil
+}
+
+// Expire returns the time at which the cached instrument ids go stale.
+func (r *contractResolver) Expire() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.expire
+}
+
+func (r *contractResolver) refresh() error {
+	instruments, err := r.source()
+	if err != nil {
+		return err
+	}
+
+	now := r.now()
+	r.expire = nextSettlement(now)
+
+	ids := make(map[string]string, len(instruments))
+	for _, ins := range instruments {
+		ids[strings.ToUpper(ins.Underlying)+":"+ins.Alias] = ins.InstrumentId
+		// the api lags a little behind settlement, keep polling until the
+		// delivered contracts are gone
+		delivery, err := time.Parse("2006-01-02", ins.Delivery)
+		if err == nil && !delivery.Add(okexDeliveryHour*time.Hour).After(now) {
+			r.expire = now.Add(time.Minute)
+		}
+	}
+	r.ids = ids
+	return nil
+}
+
+// nextSettlement returns the first Friday settlement strictly after t.
+func nextSettlement(t time.Time) time.Time {
+	t = t.UTC()
+	d := time.Date(t.Year(), t.Month(), t.Day(), okexDeliveryHour, 0, 0, 0, time.UTC)
+	d = d.AddDate(0, 0, (int(time.Friday)-int(d.Weekday())+7)%7)
+	if !d.After(t) {
+		d = d.AddDate(0, 0, 7)
+	}
+	return d
+}
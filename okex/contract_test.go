@@ -0,0 +1,175 @@
+package okex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nntaoli-project/goex"
+)
+
+func TestRestInstrumentSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"instrument_id":"BTC-USD-211231","underlying":"BTC-USD","alias":"quarter","delivery":"2021-12-31"}]`))
+	}))
+	defer srv.Close()
+
+	instruments, err := RestInstrumentSource(srv.Client(), srv.URL)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FuturesInstrument{{InstrumentId: "BTC-USD-211231", Underlying: "BTC-USD", Alias: "quarter", Delivery: "2021-12-31"}}
+	if !reflect.DeepEqual(instruments, want) {
+		t.Errorf("got %v, want %v", instruments, want)
+	}
+}
+
+func TestFuturesWs_rollover(t *testing.T) {
+	var (
+		now    = time.Date(2021, 12, 29, 0, 0, 0, 0, time.UTC)
+		before = []FuturesInstrument{
+			{InstrumentId: "BTC-USD-211231", Underlying: "BTC-USD", Alias: goex.THIS_WEEK_CONTRACT, Delivery: "2021-12-31"},
+			{InstrumentId: "BTC-USD-220107", Underlying: "BTC-USD", Alias: goex.NEXT_WEEK_CONTRACT, Delivery: "2022-01-07"},
+			{InstrumentId: "BTC-USD-220325", Underlying: "BTC-USD", Alias: goex.QUARTER_CONTRACT, Delivery: "2022-03-25"},
+		}
+		after = []FuturesInstrument{
+			{InstrumentId: "BTC-USD-220107", Underlying: "BTC-USD", Alias: goex.THIS_WEEK_CONTRACT, Delivery: "2022-01-07"},
+			{InstrumentId: "BTC-USD-220114", Underlying: "BTC-USD", Alias: goex.NEXT_WEEK_CONTRACT, Delivery: "2022-01-14"},
+			{InstrumentId: "BTC-USD-220325", Underlying: "BTC-USD", Alias: goex.QUARTER_CONTRACT, Delivery: "2022-03-25"},
+		}
+		instruments = before
+		fetches     int
+	)
+
+	ws := NewFuturesWs()
+	ws.SetInstrumentSource(func() ([]FuturesInstrument, error) {
+		fetches++
+		return instruments, nil
+	})
+	ws.contracts.now = func() time.Time { return now }
+	ws.rolloverOnce.Do(func() {}) // keep the background loop out of the test
+
+	for _, contractType := range []string{goex.THIS_WEEK_CONTRACT, goex.NEXT_WEEK_CONTRACT, goex.QUARTER_CONTRACT} {
+		channel, err := ws.channel("depth5", goex.BTC_USD, contractType, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.track(futuresSub{table: "depth5", pair: goex.BTC_USD, contractType: contractType, channel: channel, size: 5})
+	}
+	if fetches != 1 {
+		t.Errorf("instruments fetched %d times, want 1", fetches)
+	}
+	if _, err := ws.channel("depth5", goex.BTC_USD, goex.BI_QUARTER_CONTRACT, 5); err == nil {
+		t.Error("expected an error for a missing contract")
+	}
+
+	if want := time.Date(2021, 12, 31, 8, 0, 0, 0, time.UTC); !ws.contracts.Expire().Equal(want) {
+		t.Errorf("expire %s, want %s", ws.contracts.Expire(), want)
+	}
+
+	// settlement passed but the api has not rolled yet
+	now = time.Date(2021, 12, 31, 8, 0, 1, 0, time.UTC)
+	unsubscribe, subscribe, failed := ws.rollover()
+	if len(failed) != 0 || len(unsubscribe) != 0 || len(subscribe) != 0 {
+		t.Errorf("stale rollover: %v %v %v", unsubscribe, subscribe, failed)
+	}
+	if want := now.Add(time.Minute); !ws.contracts.Expire().Equal(want) {
+		t.Errorf("expire %s, want %s", ws.contracts.Expire(), want)
+	}
+
+	now = now.Add(time.Minute)
+	instruments = after
+	unsubscribe, subscribe, failed = ws.rollover()
+	if len(failed) != 0 {
+		t.Fatal(failed)
+	}
+	if want := []string{"futures/depth5:BTC-USD-211231"}; !reflect.DeepEqual(unsubscribe, want) {
+		t.Errorf("unsubscribe %v, want %v", unsubscribe, want)
+	}
+	if want := []string{"futures/depth5:BTC-USD-220114"}; !reflect.DeepEqual(subscribe, want) {
+		t.Errorf("subscribe %v, want %v", subscribe, want)
+	}
+	if want := time.Date(2022, 1, 7, 8, 0, 0, 0, time.UTC); !ws.contracts.Expire().Equal(want) {
+		t.Errorf("expire %s, want %s", ws.contracts.Expire(), want)
+	}
+}
+
+// TestFuturesWs_rolloverFailed checks that a contract which fails to resolve
+// keeps its channel without holding back the others.
+func TestFuturesWs_rolloverFailed(t *testing.T) {
+	var (
+		now         = time.Date(2021, 12, 29, 0, 0, 0, 0, time.UTC)
+		instruments = []FuturesInstrument{
+			{InstrumentId: "BTC-USD-211231", Underlying: "BTC-USD", Alias: goex.THIS_WEEK_CONTRACT, Delivery: "2021-12-31"},
+			{InstrumentId: "BTC-USD-220107", Underlying: "BTC-USD", Alias: goex.NEXT_WEEK_CONTRACT, Delivery: "2022-01-07"},
+		}
+	)
+	ws := NewFuturesWs()
+	ws.SetInstrumentSource(func() ([]FuturesInstrument, error) { return instruments, nil })
+	ws.contracts.now = func() time.Time { return now }
+	ws.rolloverOnce.Do(func() {})
+	for _, contractType := range []string{goex.THIS_WEEK_CONTRACT, goex.NEXT_WEEK_CONTRACT} {
+		channel, err := ws.channel("trade", goex.BTC_USD, contractType, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.track(futuresSub{table: "trade", pair: goex.BTC_USD, contractType: contractType, channel: channel})
+	}
+
+	// next_week is not listed yet after the settlement
+	now = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	instruments = []FuturesInstrument{
+		{InstrumentId: "BTC-USD-220107", Underlying: "BTC-USD", Alias: goex.THIS_WEEK_CONTRACT, Delivery: "2022-01-07"},
+	}
+	unsubscribe, subscribe, failed := ws.rollover()
+	if len(failed) != 1 {
+		t.Fatalf("got %v, want next_week to fail", failed)
+	}
+	if len(unsubscribe) != 1 || unsubscribe[0] != "futures/trade:BTC-USD-211231" || len(subscribe) != 0 {
+		t.Errorf("unsubscribe %v, subscribe %v", unsubscribe, subscribe)
+	}
+	if sub := ws.subs["trade:BTC-USD:"+goex.NEXT_WEEK_CONTRACT]; sub.channel != "futures/trade:BTC-USD-220107" {
+		t.Errorf("next_week moved to %s", sub.channel)
+	}
+}
+
+func TestFuturesWs_subscribeFailed(t *testing.T) {
+	ws := NewFuturesWs()
+	ws.SetInstrumentSource(func() ([]FuturesInstrument, error) {
+		return []FuturesInstrument{{InstrumentId: "BTC-USD-211231", Underlying: "BTC-USD", Alias: goex.QUARTER_CONTRACT, Delivery: "2099-12-31"}}, nil
+	})
+	ws.Close()
+	ws.TradeCallback(func(*goex.Trade, string) {})
+	if err := ws.SubscribeTrade(goex.BTC_USD, goex.QUARTER_CONTRACT); err == nil {
+		t.Fatal("subscribed after the close")
+	}
+	if len(ws.subs) != 0 {
+		t.Errorf("failed subscription tracked: %v", ws.subs)
+	}
+}
+
+// TestFuturesWs_SetInstrumentSource swaps the source while contracts are
+// being resolved, which must not race.
+func TestFuturesWs_SetInstrumentSource(t *testing.T) {
+	source := func() ([]FuturesInstrument, error) {
+		return []FuturesInstrument{{InstrumentId: "BTC-USD-991231", Underlying: "BTC-USD", Alias: goex.QUARTER_CONTRACT, Delivery: "2099-12-31"}}, nil
+	}
+	ws := NewFuturesWs()
+	ws.SetInstrumentSource(source)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if _, err := ws.channel("trade", goex.BTC_USD, goex.QUARTER_CONTRACT, 0); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		ws.SetInstrumentSource(source)
+	}
+	<-done
+}
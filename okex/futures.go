@@ -1,123 +1,603 @@
 package okex
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/goex-top/goexws/common"
 	. "github.com/nntaoli-project/goex"
+	"net/http"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type FuturesWs struct {
 	v3Ws           *baseWs
-	tickerCallback func(*FutureTicker)
-	depthCallback  func(*Depth)
-	tradeCallback  func(*Trade, string)
-	klineCallback  func(*FutureKline, int, string)
+	contracts      *contractResolver
+	rolloverOnce   sync.Once
+	rolloverWg     sync.WaitGroup
+	stop           chan struct{}
+	closeOnce      sync.Once
+	subsLock       sync.Mutex
+	subs           map[string]futuresSub
+	tickerCallback common.Callback[func(*FutureTicker)]
+	depthCallback  common.Callback[func(*Depth)]
+	tradeCallback  common.Callback[func(*Trade, string)]
+	klineCallback  common.Callback[func(*FutureKline, int, string)]
+	dispatcher     common.Dispatcher
+	depthRoutes    common.Fanout[*Depth]
+	tickerRoutes   common.Fanout[*FutureTicker]
+	tradeRoutes    common.Fanout[*Trade]
+	klineRoutes    common.Fanout[*FutureKline]
+	tradeFuncs     common.Fanout[common.ContractTrade]
+	klineFuncs     common.Fanout[common.ContractKline]
 }
 
+// futuresSub remembers which delivery contract a channel was resolved to, so
+// it can be moved to the new instrument when the aliases roll over.
+type futuresSub struct {
+	table        string
+	pair         CurrencyPair
+	contractType string
+	channel      string
+	size         int
+}
+
+// instrumentsTimeout bounds the REST lookup of the delivery contracts.
+const instrumentsTimeout = 10 * time.Second
+
 func NewFuturesWs() *FuturesWs {
-	ws := &FuturesWs{}
+	ws := &FuturesWs{subs: make(map[string]futuresSub), stop: make(chan struct{})}
 	ws.v3Ws = NewOKExV3Ws(ws.handle)
+	ws.v3Ws.bookHandle = ws.handleBook
+	ws.contracts = newContractResolver(RestInstrumentSource(&http.Client{Timeout: instrumentsTimeout}, "https://www.okex.com/api/futures/v3/instruments"))
+	ws.dispatcher = common.SerialPerSymbol(0)
 	return ws
 }
 
+// SetInstrumentSource replaces the REST lookup used to resolve this_week,
+// next_week, quarter and bi_quarter into instrument ids, it has to be called
+// before subscribing.
+func (ws *FuturesWs) SetInstrumentSource(source InstrumentSource) {
+	ws.contracts.setSource(source)
+}
+
+// SetBaseUrl sets the websocket url, it has to be called before subscribing.
+func (ws *FuturesWs) SetBaseUrl(baseURL string) {
+	ws.v3Ws.wsURL = baseURL
+}
+
+func (ws *FuturesWs) ProxyUrl(proxyUrl string) {
+	ws.v3Ws.proxyUrl = proxyUrl
+}
+
+// SetDispatcher sets how the data callbacks are run, they are serial per
+// symbol by default. It has to be called before subscribing.
+func (ws *FuturesWs) SetDispatcher(dispatcher common.Dispatcher) {
+	ws.dispatcher = dispatcher
+}
+
+// Close stops following the rollovers, closes the connection and the
+// subscription channels, no callback is called once it returns. It must not
+// be called from a callback.
+func (ws *FuturesWs) Close() error {
+	ws.closeOnce.Do(func() {
+		// no rollover loop is started from now on
+		ws.rolloverOnce.Do(func() {})
+		close(ws.stop)
+	})
+	ws.rolloverWg.Wait()
+	err := ws.v3Ws.Close()
+	ws.dispatcher.Close()
+	ws.depthRoutes.Close()
+	ws.tickerRoutes.Close()
+	ws.tradeRoutes.Close()
+	ws.klineRoutes.Close()
+	ws.tradeFuncs.Close()
+	ws.klineFuncs.Close()
+	return err
+}
+
+// SubscribeFailedCallback is told about every channel the server rejected or
+// did not acknowledge in time, be it on the first subscription or on the
+// replay after a reconnect.
+func (ws *FuturesWs) SubscribeFailedCallback(call func(channel string, err error)) {
+	ws.v3Ws.setFailedCallback(call)
+}
+
+// ErrorCallback gets the messages that could not be parsed or handled, the
+// error replies of the server and the failed subscriptions.
+func (ws *FuturesWs) ErrorCallback(call func(err error)) {
+	ws.v3Ws.errs.SetCallback(call)
+}
+
+// SetLogger sets where the connection lifecycle and the message errors are
+// logged, nothing is logged by default.
+func (ws *FuturesWs) SetLogger(logger common.Logger) {
+	ws.v3Ws.log.SetLogger(logger)
+}
+
+// LogFrames turns debug logging of every raw frame sent and received on or
+// off, it takes effect right away.
+func (ws *FuturesWs) LogFrames(on bool) {
+	ws.v3Ws.log.SetFrames(on)
+}
+
+// RecoverPanics turns the recovery of panics in the callbacks and message
+// handling on or off, it is on by default. A recovered panic is handed to the
+// error callback as a *common.PanicError and the connection stays up.
+func (ws *FuturesWs) RecoverPanics(on bool) {
+	ws.v3Ws.panics.SetRecover(on)
+}
+
+// LifecycleCallback is told whenever the connection comes up, drops, is
+// redialed and has its subscriptions restored.
+func (ws *FuturesWs) LifecycleCallback(call func(event *common.Event)) {
+	ws.v3Ws.events.SetCallback(call)
+}
+
+// SetMaxSilence sets how long channels of channelType may go without data
+// before the connection is dropped and redialed, 0 turns the check off. It
+// applies to the channels subscribed afterwards.
+func (ws *FuturesWs) SetMaxSilence(channelType common.ChannelType, maxSilence time.Duration) {
+	ws.v3Ws.maxSilence.Set(channelType, maxSilence)
+}
+
+// ResyncCallback is told whenever a full depth book is thrown away and
+// resubscribed, e.g. on a checksum mismatch.
+func (ws *FuturesWs) ResyncCallback(call func(channel string, err error)) {
+	ws.v3Ws.resyncCallback.Set(call)
+}
+
 func (ws *FuturesWs) TickerCallback(tickerCallback func(*FutureTicker)) {
-	ws.tickerCallback = tickerCallback
+	ws.tickerCallback.Set(tickerCallback)
 }
 
 func (ws *FuturesWs) DepthCallback(depthCallback func(*Depth)) {
-	ws.depthCallback = depthCallback
+	ws.depthCallback.Set(depthCallback)
 }
 
 func (ws *FuturesWs) TradeCallback(tradeCallback func(*Trade, string)) {
-	ws.tradeCallback = tradeCallback
+	ws.tradeCallback.Set(tradeCallback)
 }
 
 func (ws *FuturesWs) KlineCallback(klineCallback func(*FutureKline, int, string)) {
-	ws.klineCallback = klineCallback
+	ws.klineCallback.Set(klineCallback)
 }
 
 func (ws *FuturesWs) SetCallbacks(tickerCallback func(*FutureTicker),
 	depthCallback func(*Depth),
 	tradeCallback func(*Trade, string),
 	klineCallback func(*FutureKline, int, string)) {
-	ws.tickerCallback = tickerCallback
-	ws.depthCallback = depthCallback
-	ws.tradeCallback = tradeCallback
-	ws.klineCallback = klineCallback
+	ws.tickerCallback.Set(tickerCallback)
+	ws.depthCallback.Set(depthCallback)
+	ws.tradeCallback.Set(tradeCallback)
+	ws.klineCallback.Set(klineCallback)
 }
 
-func (ws *FuturesWs) getChannelName(currencyPair CurrencyPair, contractType string) string {
-	var (
-		prefix      string
-		contractId  string
-		channelName string
-	)
-
+func (ws *FuturesWs) getChannelName(currencyPair CurrencyPair, contractType string) (string, error) {
 	if contractType == SWAP_CONTRACT {
-		prefix = "swap"
-		contractId = fmt.Sprintf("%s-SWAP", currencyPair.ToSymbol("-"))
-	} else {
-		prefix = "futures"
-		//contractId = ws.base.OKExFuture.GetFutureContractId(currencyPair, contractType)
-		//logger.Info("contractid=", contractId)
+		return "swap/%s:" + fmt.Sprintf("%s-SWAP", currencyPair.ToSymbol("-")), nil
+	}
+
+	contractId, err := ws.contracts.Resolve(currencyPair, contractType)
+	if err != nil {
+		return "", err
 	}
+	return "futures/%s:" + contractId, nil
+}
 
-	channelName = prefix + "/%s:" + contractId
+// channel resolves the channel of table for the pair and contract. size is
+// the number of depth levels asked for on depth tables, 0 otherwise.
+func (ws *FuturesWs) channel(table string, currencyPair CurrencyPair, contractType string, size int) (string, error) {
+	chName, err := ws.getChannelName(currencyPair, contractType)
+	if err != nil {
+		return "", err
+	}
+	channel := fmt.Sprintf(chName, table)
+	if size > 0 {
+		ws.v3Ws.setDepthSize(channel, size)
+	}
+	return channel, nil
+}
 
-	return channelName
+// track records a delivery contract subscription so that it follows the
+// contract across rollovers.
+func (ws *FuturesWs) track(sub futuresSub) {
+	ws.subsLock.Lock()
+	ws.subs[sub.table+":"+sub.pair.ToSymbol("-")+":"+sub.contractType] = sub
+	ws.subsLock.Unlock()
+	ws.rolloverOnce.Do(func() {
+		ws.rolloverWg.Add(1)
+		go ws.rolloverLoop()
+	})
+}
+
+// subscribe subscribes the channel of table, a delivery contract is only
+// tracked once the subscription went out.
+func (ws *FuturesWs) subscribe(table string, currencyPair CurrencyPair, contractType string, size int) error {
+	// no contract is looked up once closed
+	select {
+	case <-ws.stop:
+		return common.ErrClosed
+	default:
+	}
+	channel, err := ws.channel(table, currencyPair, contractType, size)
+	if err != nil {
+		return err
+	}
+	err = ws.v3Ws.Subscribe(map[string]interface{}{
+		"op":   "subscribe",
+		"args": []string{channel}})
+	if err != nil || contractType == SWAP_CONTRACT {
+		return err
+	}
+	ws.track(futuresSub{
+		table:        table,
+		pair:         currencyPair,
+		contractType: contractType,
+		channel:      channel,
+		size:         size,
+	})
+	return nil
 }
 
 func (ws *FuturesWs) SubscribeDepth(pair CurrencyPair, size int, contract string) error {
-	if ws.depthCallback == nil {
+	if ws.depthCallback.Get() == nil {
 		return errors.New("please set depth callback func")
 	}
+	return ws.subscribeDepth(pair, size, contract)
+}
 
-	chName := ws.getChannelName(pair, contract)
+func (ws *FuturesWs) subscribeDepth(pair CurrencyPair, size int, contract string) error {
+	channelSize, err := common.DepthChannelSize("okex", size, depthSizes)
+	if err != nil {
+		return err
+	}
 
-	return ws.v3Ws.Subscribe(map[string]interface{}{
-		"op":   "subscribe",
-		"args": []string{fmt.Sprintf(chName, "depth5")}})
+	table := "depth5"
+	if channelSize > 5 {
+		table = "depth"
+	}
+	return ws.subscribe(table, pair, contract, size)
+}
+
+// SubscribeFullDepth keeps a local 400 level book from the depth channel, or
+// from depth_l2_tbt when tickByTick is set, verifying the checksum of every
+// update. DepthCallback gets the whole book after each update.
+func (ws *FuturesWs) SubscribeFullDepth(pair CurrencyPair, contractType string, tickByTick bool) error {
+	if ws.depthCallback.Get() == nil {
+		return errors.New("please set depth callback func")
+	}
+
+	table := "depth"
+	if tickByTick {
+		table = "depth_l2_tbt"
+	}
+	return ws.subscribe(table, pair, contractType, 0)
 }
 
 func (ws *FuturesWs) SubscribeTicker(currencyPair CurrencyPair, contractType string) error {
-	if ws.tickerCallback == nil {
+	if ws.tickerCallback.Get() == nil {
 		return errors.New("please set ticker callback func")
 	}
-	chName := ws.getChannelName(currencyPair, contractType)
-	return ws.v3Ws.Subscribe(map[string]interface{}{
-		"op":   "subscribe",
-		"args": []string{fmt.Sprintf(chName, "ticker")}})
+	return ws.subscribeTicker(currencyPair, contractType)
+}
+
+func (ws *FuturesWs) subscribeTicker(currencyPair CurrencyPair, contractType string) error {
+	return ws.subscribe("ticker", currencyPair, contractType, 0)
 }
 
 func (ws *FuturesWs) SubscribeTrade(currencyPair CurrencyPair, contractType string) error {
-	if ws.tradeCallback == nil {
+	if ws.tradeCallback.Get() == nil {
 		return errors.New("please set trade callback func")
 	}
-	chName := ws.getChannelName(currencyPair, contractType)
-	return ws.v3Ws.Subscribe(map[string]interface{}{
-		"op":   "subscribe",
-		"args": []string{fmt.Sprintf(chName, "trade")}})
+	return ws.subscribeTrade(currencyPair, contractType)
+}
+
+func (ws *FuturesWs) subscribeTrade(currencyPair CurrencyPair, contractType string) error {
+	return ws.subscribe("trade", currencyPair, contractType, 0)
 }
 
 func (ws *FuturesWs) SubscribeKline(currencyPair CurrencyPair, period int, contractType string) error {
-	if ws.klineCallback == nil {
+	if ws.klineCallback.Get() == nil {
 		return errors.New("place set kline callback func")
 	}
+	return ws.subscribeKline(currencyPair, period, contractType)
+}
+
+func (ws *FuturesWs) subscribeKline(currencyPair CurrencyPair, period int, contractType string) error {
 
 	seconds := adaptKLinePeriod(period)
 	if seconds == -1 {
 		return fmt.Errorf("unsupported kline period %d in okex", period)
 	}
 
-	chName := ws.getChannelName(currencyPair, contractType)
-	return ws.v3Ws.Subscribe(map[string]interface{}{
-		"op":   "subscribe",
-		"args": []string{fmt.Sprintf(chName, fmt.Sprintf("candle%ds", seconds))}})
+	return ws.subscribe(fmt.Sprintf("candle%ds", seconds), currencyPair, contractType, 0)
+}
+
+// unsubscribe drops the subscriptions of tables for the pair and contract,
+// delivery contracts are looked up among the tracked ones since the alias may
+// resolve to another instrument by now.
+func (ws *FuturesWs) unsubscribe(currencyPair CurrencyPair, contractType string, tables ...string) error {
+	var channels []string
+	if contractType == SWAP_CONTRACT {
+		chName, _ := ws.getChannelName(currencyPair, contractType)
+		for _, table := range tables {
+			channels = append(channels, fmt.Sprintf(chName, table))
+		}
+		return ws.v3Ws.Unsubscribe(channels...)
+	}
+
+	ws.subsLock.Lock()
+	for _, table := range tables {
+		key := table + ":" + currencyPair.ToSymbol("-") + ":" + contractType
+		if sub, ok := ws.subs[key]; ok {
+			channels = append(channels, sub.channel)
+			delete(ws.subs, key)
+		}
+	}
+	ws.subsLock.Unlock()
+	return ws.v3Ws.Unsubscribe(channels...)
+}
+
+// UnsubscribeDepth stops the depth of the contract, whichever size it was
+// subscribed with, including a SubscribeFullDepth book.
+func (ws *FuturesWs) UnsubscribeDepth(pair CurrencyPair, contractType string) error {
+	ws.depthRoutes.Unhandle(common.RouteKey(pair, contractType))
+	return ws.unsubscribe(pair, contractType, "depth5", "depth", "depth_l2_tbt")
+}
+
+func (ws *FuturesWs) UnsubscribeTicker(currencyPair CurrencyPair, contractType string) error {
+	ws.tickerRoutes.Unhandle(common.RouteKey(currencyPair, contractType))
+	return ws.unsubscribe(currencyPair, contractType, "ticker")
+}
+
+func (ws *FuturesWs) UnsubscribeTrade(currencyPair CurrencyPair, contractType string) error {
+	ws.tradeFuncs.Unhandle(common.RouteKey(currencyPair, contractType))
+	return ws.unsubscribe(currencyPair, contractType, "trade")
+}
+
+func (ws *FuturesWs) UnsubscribeKline(currencyPair CurrencyPair, period int, contractType string) error {
+	ws.klineFuncs.Unhandle(common.RouteKey(currencyPair, contractType, adaptKLinePeriod(period)))
+	seconds := adaptKLinePeriod(period)
+	if seconds == -1 {
+		return fmt.Errorf("unsupported kline period %d in okex", period)
+	}
+	return ws.unsubscribe(currencyPair, contractType, fmt.Sprintf("candle%ds", seconds))
+}
+
+// rollover re-resolves every delivery contract subscription and returns the
+// channels that have to be dropped and added. Aliases shift onto each other
+// at settlement (next_week becomes this_week), so the diff is done on the
+// whole channel set rather than per subscription. The contracts are resolved
+// without holding subsLock, a subscription that fails to resolve keeps its
+// channel and its error is returned in failed.
+func (ws *FuturesWs) rollover() (unsubscribe, subscribe []string, failed []error) {
+	ws.subsLock.Lock()
+	subs := make(map[string]futuresSub, len(ws.subs))
+	for key, sub := range ws.subs {
+		subs[key] = sub
+	}
+	ws.subsLock.Unlock()
+
+	resolved := make(map[string]futuresSub, len(subs))
+	for key, sub := range subs {
+		contractId, err := ws.contracts.Resolve(sub.pair, sub.contractType)
+		if err != nil {
+			failed = append(failed, fmt.Errorf("roll %s over: %w", sub.channel, err))
+			continue
+		}
+		sub.channel = fmt.Sprintf("futures/%s:%s", sub.table, contractId)
+		if sub.size > 0 {
+			ws.v3Ws.setDepthSize(sub.channel, sub.size)
+		}
+		resolved[key] = sub
+	}
+
+	ws.subsLock.Lock()
+	defer ws.subsLock.Unlock()
+	oldChannels := make(map[string]bool, len(ws.subs))
+	newChannels := make(map[string]bool, len(ws.subs))
+	for key, sub := range ws.subs {
+		oldChannels[sub.channel] = true
+		// leave alone what was unsubscribed or subscribed anew meanwhile
+		if next, ok := resolved[key]; ok && sub == subs[key] {
+			sub = next
+			ws.subs[key] = sub
+		}
+		newChannels[sub.channel] = true
+	}
+
+	for ch := range oldChannels {
+		if !newChannels[ch] {
+			unsubscribe = append(unsubscribe, ch)
+		}
+	}
+	for ch := range newChannels {
+		if !oldChannels[ch] {
+			subscribe = append(subscribe, ch)
+		}
+	}
+	sort.Strings(unsubscribe)
+	sort.Strings(subscribe)
+	return unsubscribe, subscribe, failed
+}
+
+func (ws *FuturesWs) rolloverLoop() {
+	defer ws.rolloverWg.Done()
+	for {
+		wait := time.Until(ws.contracts.Expire())
+		if wait < time.Second {
+			wait = time.Second
+		}
+		select {
+		case <-ws.stop:
+			return
+		case <-time.After(wait):
+		}
+
+		unsubscribe, subscribe, failed := ws.rollover()
+		if len(unsubscribe) > 0 {
+			ws.v3Ws.Unsubscribe(unsubscribe...)
+		}
+		if len(subscribe) > 0 {
+			ws.v3Ws.Subscribe(map[string]interface{}{
+				"op":   "subscribe",
+				"args": subscribe})
+		}
+		if len(failed) > 0 {
+			for _, err := range failed {
+				ws.v3Ws.errs.Report(err)
+			}
+			select {
+			case <-ws.stop:
+				return
+			case <-time.After(time.Minute):
+			}
+		}
+	}
+}
+
+// SubscribeDepthChan subscribes to the depth of the contract and delivers it
+// on the returned channel, alongside the depth callback if one is set. The
+// channel is closed once ctx is done, the contract stays subscribed until
+// UnsubscribeDepth and follows the rollovers like any other subscription.
+func (ws *FuturesWs) SubscribeDepthChan(ctx context.Context, pair CurrencyPair, size int, contractType string, opts ...common.StreamOption) (<-chan *Depth, error) {
+	return common.SubscribeChan(ctx, &ws.depthRoutes, common.RouteKey(pair, contractType), func() error {
+		return ws.subscribeDepth(pair, size, contractType)
+	}, opts...)
+}
+
+func (ws *FuturesWs) SubscribeTickerChan(ctx context.Context, pair CurrencyPair, contractType string, opts ...common.StreamOption) (<-chan *FutureTicker, error) {
+	return common.SubscribeChan(ctx, &ws.tickerRoutes, common.RouteKey(pair, contractType), func() error {
+		return ws.subscribeTicker(pair, contractType)
+	}, opts...)
+}
+
+func (ws *FuturesWs) SubscribeTradeChan(ctx context.Context, pair CurrencyPair, contractType string, opts ...common.StreamOption) (<-chan *Trade, error) {
+	return common.SubscribeChan(ctx, &ws.tradeRoutes, common.RouteKey(pair, contractType), func() error {
+		return ws.subscribeTrade(pair, contractType)
+	}, opts...)
+}
+
+func (ws *FuturesWs) SubscribeKlineChan(ctx context.Context, pair CurrencyPair, period int, contractType string, opts ...common.StreamOption) (<-chan *FutureKline, error) {
+	return common.SubscribeChan(ctx, &ws.klineRoutes, common.RouteKey(pair, contractType, adaptKLinePeriod(period)), func() error {
+		return ws.subscribeKline(pair, period, contractType)
+	}, opts...)
+}
+
+// SubscribeDepthFunc subscribes to the depth of the contract and hands it to
+// call, besides the depth callback if one is set. call replaces the one it
+// was subscribed with before and is dropped by UnsubscribeDepth.
+func (ws *FuturesWs) SubscribeDepthFunc(pair CurrencyPair, size int, contractType string, call func(depth *Depth)) error {
+	if call == nil {
+		return errors.New("please set depth callback func")
+	}
+	return common.SubscribeFunc(&ws.depthRoutes, common.RouteKey(pair, contractType), call, func() error {
+		return ws.subscribeDepth(pair, size, contractType)
+	})
+}
+
+func (ws *FuturesWs) SubscribeTickerFunc(pair CurrencyPair, contractType string, call func(ticker *FutureTicker)) error {
+	if call == nil {
+		return errors.New("please set ticker callback func")
+	}
+	return common.SubscribeFunc(&ws.tickerRoutes, common.RouteKey(pair, contractType), call, func() error {
+		return ws.subscribeTicker(pair, contractType)
+	})
+}
+
+func (ws *FuturesWs) SubscribeTradeFunc(pair CurrencyPair, contractType string, call func(trade *Trade, contract string)) error {
+	if call == nil {
+		return errors.New("please set trade callback func")
+	}
+	return common.SubscribeFunc(&ws.tradeFuncs, common.RouteKey(pair, contractType), func(t common.ContractTrade) {
+		call(t.Trade, t.Contract)
+	}, func() error {
+		return ws.subscribeTrade(pair, contractType)
+	})
+}
+
+func (ws *FuturesWs) SubscribeKlineFunc(pair CurrencyPair, period int, contractType string, call func(kline *FutureKline, period int, contract string)) error {
+	if call == nil {
+		return errors.New("please set kline callback func")
+	}
+	return common.SubscribeFunc(&ws.klineFuncs, common.RouteKey(pair, contractType, adaptKLinePeriod(period)), func(k common.ContractKline) {
+		call(k.Kline, k.Period, k.Contract)
+	}, func() error {
+		return ws.subscribeKline(pair, period, contractType)
+	})
+}
+
+// routeKey is the key data of instrumentId is routed under. The data only
+// names the instrument, delivery contracts are mapped back to the contract
+// type they were subscribed as.
+func (ws *FuturesWs) routeKey(pair CurrencyPair, instrumentId string, parts ...interface{}) string {
+	contractType := instrumentId
+	if strings.HasSuffix(instrumentId, "-SWAP") {
+		contractType = SWAP_CONTRACT
+	} else {
+		ws.subsLock.Lock()
+		for _, sub := range ws.subs {
+			if strings.HasSuffix(sub.channel, ":"+instrumentId) {
+				contractType = sub.contractType
+				break
+			}
+		}
+		ws.subsLock.Unlock()
+	}
+	return common.RouteKey(pair, append([]interface{}{contractType}, parts...)...)
+}
+
+// dispatch runs call through the dispatcher, a panic is recovered and
+// reported with the frame raw and data.
+func (ws *FuturesWs) dispatch(key string, raw []byte, data interface{}, call func()) {
+	ws.dispatcher.Dispatch(key, func() {
+		ws.v3Ws.panics.Run(ws.v3Ws.errs.Report, raw, data, call)
+	})
+}
+
+func (ws *FuturesWs) onDepth(key string, raw []byte, depth *Depth) {
+	ws.dispatch(key, raw, depth, func() {
+		if call := ws.depthCallback.Get(); call != nil {
+			call(depth)
+		}
+		ws.depthRoutes.Emit(key, depth)
+	})
+}
+
+func (ws *FuturesWs) onTicker(key string, raw []byte, ticker *FutureTicker) {
+	ws.dispatch(key, raw, ticker, func() {
+		if call := ws.tickerCallback.Get(); call != nil {
+			call(ticker)
+		}
+		ws.tickerRoutes.Emit(key, ticker)
+	})
+}
+
+func (ws *FuturesWs) onTrade(key string, raw []byte, trade *Trade, contract string) {
+	ws.dispatch(key, raw, trade, func() {
+		if call := ws.tradeCallback.Get(); call != nil {
+			call(trade, contract)
+		}
+		ws.tradeRoutes.Emit(key, trade)
+		ws.tradeFuncs.Emit(key, common.ContractTrade{Trade: trade, Contract: contract})
+	})
+}
+
+func (ws *FuturesWs) onKline(key string, raw []byte, kline *FutureKline, period int, contract string) {
+	ws.dispatch(key, raw, kline, func() {
+		if call := ws.klineCallback.Get(); call != nil {
+			call(kline, period, contract)
+		}
+		ws.klineRoutes.Emit(key, kline)
+		ws.klineFuncs.Emit(key, common.ContractKline{Kline: kline, Period: period, Contract: contract})
+	})
 }
 
 func (ws *FuturesWs) getContractAliasAndCurrencyPairFromInstrumentId(instrumentId string) (alias string, pair CurrencyPair) {
@@ -125,7 +605,14 @@ func (ws *FuturesWs) getContractAliasAndCurrencyPairFromInstrumentId(instrumentI
 	return instrumentId, NewCurrencyPair2(fmt.Sprintf("%s_%s", ar[0], ar[1]))
 }
 
-func (ws *FuturesWs) handle(channel string, data json.RawMessage) error {
+func (ws *FuturesWs) handleBook(table string, instrumentId string, raw []byte, depth *Depth) {
+	alias, pair := ws.getContractAliasAndCurrencyPairFromInstrumentId(instrumentId)
+	depth.Pair = pair
+	depth.ContractType = alias
+	ws.onDepth(ws.routeKey(pair, instrumentId), raw, depth)
+}
+
+func (ws *FuturesWs) handle(channel string, raw []byte, data json.RawMessage) error {
 	var (
 		err           error
 		ch            string
@@ -151,8 +638,7 @@ func (ws *FuturesWs) handle(channel string, data json.RawMessage) error {
 	} else {
 		ch, err = ws.v3Ws.parseChannel(channel)
 		if err != nil {
-			//logger.Errorf("[%s] parse channel err=%s ,  originChannel=%s", ws.base.GetExchangeName(), err, ch)
-			return nil
+			return &common.UnknownChannelError{Channel: channel, Raw: data}
 		}
 	}
 
@@ -160,13 +646,13 @@ func (ws *FuturesWs) handle(channel string, data json.RawMessage) error {
 	case "ticker":
 		err = json.Unmarshal(data, &tickers)
 		if err != nil {
-			return err
+			return &common.ParseError{Raw: data, Err: err}
 		}
 
 		for _, t := range tickers {
 			alias, pair := ws.getContractAliasAndCurrencyPairFromInstrumentId(t.InstrumentId)
 			date, _ := time.Parse(time.RFC3339, t.Timestamp)
-			ws.tickerCallback(&FutureTicker{
+			ws.onTicker(ws.routeKey(pair, t.InstrumentId), raw, &FutureTicker{
 				Ticker: &Ticker{
 					Pair: pair,
 					Last: t.Last,
@@ -184,14 +670,15 @@ func (ws *FuturesWs) handle(channel string, data json.RawMessage) error {
 	case "candle":
 		err = json.Unmarshal(data, &klineResponse)
 		if err != nil {
-			return err
+			return &common.ParseError{Raw: data, Err: err}
 		}
 
+		seconds := ToInt(strings.TrimSuffix(strings.TrimPrefix(channel, "futures/candle"), "s"))
 		for _, t := range klineResponse {
 			ali, pair := ws.getContractAliasAndCurrencyPairFromInstrumentId(t.InstrumentId)
 			ts, _ := time.Parse(time.RFC3339, t.Candle[0])
 			//granularity := adaptKLinePeriod(KlinePeriod(period))
-			ws.klineCallback(&FutureKline{
+			ws.onKline(ws.routeKey(pair, t.InstrumentId, seconds), raw, &FutureKline{
 				Kline: &Kline{
 					Pair:      pair,
 					High:      ToFloat64(t.Candle[2]),
@@ -202,14 +689,13 @@ func (ws *FuturesWs) handle(channel string, data json.RawMessage) error {
 					Vol:       ToFloat64(t.Candle[5]),
 				},
 				Vol2: ToFloat64(t.Candle[6]),
-			}, 1, ali)
+			}, adaptSecondsToKlinePeriod(seconds), ali)
 		}
 		return nil
 	case "depth5":
 		err := json.Unmarshal(data, &depthResp)
 		if err != nil {
-			//logger.Error(err)
-			return err
+			return &common.ParseError{Raw: data, Err: err}
 		}
 		if len(depthResp) == 0 {
 			return nil
@@ -229,14 +715,13 @@ func (ws *FuturesWs) handle(channel string, data json.RawMessage) error {
 				Amount: ToFloat64(itm[1])})
 		}
 		sort.Sort(sort.Reverse(dep.AskList))
-		//call back func
-		ws.depthCallback(&dep)
+		common.TruncateDepth(&dep, ws.v3Ws.depthSize(channel+":"+depthResp[0].InstrumentId))
+		ws.onDepth(ws.routeKey(pair, depthResp[0].InstrumentId), raw, &dep)
 		return nil
 	case "trade":
 		err := json.Unmarshal(data, &tradeResponse)
 		if err != nil {
-			//logger.Error("unmarshal error :", err)
-			return err
+			return &common.ParseError{Raw: data, Err: err}
 		}
 
 		for _, resp := range tradeResponse {
@@ -250,10 +735,10 @@ func (ws *FuturesWs) handle(channel string, data json.RawMessage) error {
 
 			t, err := time.Parse(time.RFC3339, resp.Timestamp)
 			if err != nil {
-				//logger.Warn("parse timestamp error:", err)
+				ws.v3Ws.log.Logger().Warn("okex trade timestamp", "timestamp", resp.Timestamp, "err", err)
 			}
 
-			ws.tradeCallback(&Trade{
+			ws.onTrade(ws.routeKey(pair, resp.InstrumentId), raw, &Trade{
 				Tid:    resp.TradeId,
 				Type:   tradeSide,
 				Amount: resp.Qty,
@@ -265,7 +750,7 @@ func (ws *FuturesWs) handle(channel string, data json.RawMessage) error {
 		return nil
 	}
 
-	return fmt.Errorf("[%s] unknown websocket message: %s", ch, string(data))
+	return &common.UnknownChannelError{Channel: ch, Raw: data}
 }
 
 func (ws *FuturesWs) getKlinePeriodFormChannel(channel string) int {
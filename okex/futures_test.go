@@ -1 +1,51 @@
 package okex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/nntaoli-project/goex"
+)
+
+// TestFuturesWs_KlineFunc checks that the kline callback and func are told
+// the period subscribed, not a fixed one.
+func TestFuturesWs_KlineFunc(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+	defer srv.Close()
+
+	ws := NewFuturesWs()
+	ws.SetBaseUrl(srv.URL)
+	ws.SetInstrumentSource(func() ([]FuturesInstrument, error) {
+		return []FuturesInstrument{{InstrumentId: "BTC-USD-991231", Underlying: "BTC-USD", Alias: goex.QUARTER_CONTRACT, Delivery: "2099-12-31"}}, nil
+	})
+	defer ws.Close()
+
+	periods := make(chan int, 4)
+	ws.KlineCallback(func(kline *goex.FutureKline, period int, contract string) { periods <- period })
+	if err := ws.SubscribeKline(goex.BTC_USD, goex.KLINE_PERIOD_5MIN, goex.QUARTER_CONTRACT); err != nil {
+		t.Fatal(err)
+	}
+	err := ws.SubscribeKlineFunc(goex.BTC_USD, goex.KLINE_PERIOD_5MIN, goex.QUARTER_CONTRACT, func(kline *goex.FutureKline, period int, contract string) {
+		periods <- period
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wstest.Eventually(time.Second, func() bool { return srv.Connected() == 1 }) {
+		t.Fatal("not connected")
+	}
+
+	srv.Broadcast(`{"table":"futures/candle300s","data":[{"instrument_id":"BTC-USD-991231",` +
+		`"candle":["2021-12-29T00:00:00.000Z","1","2","0.5","1.5","10","0.1"]}]}`)
+	for i := 0; i < 2; i++ {
+		select {
+		case period := <-periods:
+			if period != goex.KLINE_PERIOD_5MIN {
+				t.Fatalf("got period %d, want %d", period, goex.KLINE_PERIOD_5MIN)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no kline")
+		}
+	}
+}
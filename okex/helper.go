@@ -4,9 +4,15 @@ import "time"
 
 //
 import (
+	"strings"
+
+	"github.com/goex-top/goexws/common"
 	. "github.com/nntaoli-project/goex"
 )
 
+// depthSizes are depth5 and the 400 level depth book.
+var depthSizes = []int{5, 400}
+
 type tickerResponse struct {
 	InstrumentId string  `json:"instrument_id"`
 	Last         float64 `json:"last,string"`
@@ -34,6 +40,7 @@ type depthResponse struct {
 	Asks         [][4]interface{} `json:"asks"`
 	InstrumentId string           `json:"instrument_id"`
 	Timestamp    string           `json:"timestamp"`
+	Checksum     int32            `json:"checksum"`
 }
 
 func adaptKLinePeriod(period int) int {
@@ -101,3 +108,20 @@ func timeStringToInt64(t string) (int64, error) {
 	}
 	return timestamp.UnixNano() / int64(time.Millisecond), nil
 }
+
+// channelType tells the watchdog what kind of data a channel such as
+// spot/candle60s:BTC-USDT carries.
+func channelType(channel string) common.ChannelType {
+	table := strings.SplitN(channel, ":", 2)[0]
+	switch {
+	case strings.Contains(table, "/depth"):
+		return common.DepthChannel
+	case strings.Contains(table, "/candle"):
+		return common.KlineChannel
+	case strings.Contains(table, "/ticker"):
+		return common.TickerChannel
+	case strings.Contains(table, "/trade"):
+		return common.TradeChannel
+	}
+	return ""
+}
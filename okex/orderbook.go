@@ -0,0 +1,164 @@
+package okex
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goex-top/goexws/common"
+	. "github.com/nntaoli-project/goex"
+)
+
+// checksumLevels is how many levels per side OKEx folds into the checksum.
+const checksumLevels = 25
+
+type bookLevel struct {
+	price  float64
+	priceS string
+	sizeS  string
+}
+
+// depthBook is a local copy of a 400 level depth or depth_l2_tbt channel,
+// built from the partial message and merged with every update. Levels are
+// keyed by the price string OKEx sent, the checksum is computed from those
+// strings as is.
+type depthBook struct {
+	bids map[string]bookLevel
+	asks map[string]bookLevel
+
+	// sorted best first, refreshed on every update
+	sortedBids []bookLevel
+	sortedAsks []bookLevel
+}
+
+func newDepthBook() *depthBook {
+	return &depthBook{
+		bids: make(map[string]bookLevel),
+		asks: make(map[string]bookLevel),
+	}
+}
+
+func (b *depthBook) update(r depthResponse) error {
+	b.merge(b.bids, r.Bids)
+	b.merge(b.asks, r.Asks)
+
+	b.sortedBids = b.sort(b.bids, func(i, j float64) bool { return i > j })
+	b.sortedAsks = b.sort(b.asks, func(i, j float64) bool { return i < j })
+
+	if checksum := b.checksum(); checksum != r.Checksum {
+		return fmt.Errorf("%s depth checksum mismatch: got %d, want %d", r.InstrumentId, checksum, r.Checksum)
+	}
+	return nil
+}
+
+func (b *depthBook) merge(side map[string]bookLevel, levels [][4]interface{}) {
+	for _, itm := range levels {
+		price := fmt.Sprint(itm[0])
+		size := fmt.Sprint(itm[1])
+		if ToFloat64(size) == 0 {
+			delete(side, price)
+			continue
+		}
+		side[price] = bookLevel{price: ToFloat64(price), priceS: price, sizeS: size}
+	}
+}
+
+func (b *depthBook) sort(side map[string]bookLevel, better func(i, j float64) bool) []bookLevel {
+	levels := make([]bookLevel, 0, len(side))
+	for _, l := range side {
+		levels = append(levels, l)
+	}
+	sort.Slice(levels, func(i, j int) bool { return better(levels[i].price, levels[j].price) })
+	return levels
+}
+
+// checksum is the signed crc32 of "bid1:bsize1:ask1:asize1:bid2:..." over
+// the best 25 levels, a shorter side is simply skipped.
+func (b *depthBook) checksum() int32 {
+	var fields []string
+	for i := 0; i < checksumLevels; i++ {
+		if i < len(b.sortedBids) {
+			fields = append(fields, b.sortedBids[i].priceS, b.sortedBids[i].sizeS)
+		}
+		if i < len(b.sortedAsks) {
+			fields = append(fields, b.sortedAsks[i].priceS, b.sortedAsks[i].sizeS)
+		}
+	}
+	return int32(crc32.ChecksumIEEE([]byte(strings.Join(fields, ":"))))
+}
+
+// depth returns the book in the same shape as depth5: bids from the highest
+// price down, asks sorted in reverse.
+func (b *depthBook) depth() *Depth {
+	dep := new(Depth)
+	for _, l := range b.sortedBids {
+		dep.BidList = append(dep.BidList, DepthRecord{Price: l.price, Amount: ToFloat64(l.sizeS)})
+	}
+	for i := len(b.sortedAsks) - 1; i >= 0; i-- {
+		l := b.sortedAsks[i]
+		dep.AskList = append(dep.AskList, DepthRecord{Price: l.price, Amount: ToFloat64(l.sizeS)})
+	}
+	return dep
+}
+
+// handleBook merges a depth/depth_l2_tbt message into its book. On any
+// inconsistency (checksum mismatch, update without partial) the book is
+// dropped, the channel is resubscribed to get a fresh partial and the
+// resync callback is told why. Updates still in flight for a channel
+// unsubscribed in the meantime are dropped. raw is the frame data came in.
+func (okV3Ws *baseWs) handleBook(table, action string, raw []byte, data json.RawMessage) error {
+	var depthResp []depthResponse
+	err := json.Unmarshal(data, &depthResp)
+	if err != nil {
+		return &common.ParseError{Raw: data, Err: err}
+	}
+
+	for _, r := range depthResp {
+		channel := table + ":" + r.InstrumentId
+
+		okV3Ws.booksLock.Lock()
+		book := okV3Ws.books[channel]
+		if action == "partial" {
+			book = newDepthBook()
+			okV3Ws.books[channel] = book
+		}
+		if book == nil {
+			err = fmt.Errorf("%s depth update before partial", r.InstrumentId)
+		} else {
+			err = book.update(r)
+		}
+		if err != nil {
+			delete(okV3Ws.books, channel)
+		}
+		okV3Ws.booksLock.Unlock()
+
+		if err != nil {
+			if !okV3Ws.subs.Has(channel) {
+				continue
+			}
+			okV3Ws.resubscribe(channel)
+			if call := okV3Ws.resyncCallback.Get(); call != nil {
+				call(channel, err)
+			}
+			continue
+		}
+
+		dep := book.depth()
+		dep.UTime, _ = time.Parse(time.RFC3339, r.Timestamp)
+		common.TruncateDepth(dep, okV3Ws.depthSize(channel))
+		okV3Ws.bookHandle(table, r.InstrumentId, raw, dep)
+	}
+	return nil
+}
+
+func (okV3Ws *baseWs) resubscribe(channel string) {
+	okV3Ws.conn.SendJSON(map[string]interface{}{
+		"op":   "unsubscribe",
+		"args": []string{channel}})
+	okV3Ws.subs.Subscribe(channel, map[string]interface{}{
+		"op":   "subscribe",
+		"args": []string{channel}})
+}
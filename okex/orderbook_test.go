@@ -0,0 +1,124 @@
+package okex
+
+import (
+	"encoding/json"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/nntaoli-project/goex"
+)
+
+func levels(l ...string) [][4]interface{} {
+	var r [][4]interface{}
+	for i := 0; i < len(l); i += 2 {
+		r = append(r, [4]interface{}{l[i], l[i+1], "0", "1"})
+	}
+	return r
+}
+
+func TestDepthBook_update(t *testing.T) {
+	book := newDepthBook()
+
+	partial := depthResponse{
+		InstrumentId: "BTC-USDT",
+		Bids:         levels("3366.1", "7", "3366", "6"),
+		Asks:         levels("3368", "8", "3366.8", "9"),
+		Checksum:     int32(crc32.ChecksumIEEE([]byte("3366.1:7:3366.8:9:3366:6:3368:8"))),
+	}
+	if err := book.update(partial); err != nil {
+		t.Fatal(err)
+	}
+
+	update := depthResponse{
+		InstrumentId: "BTC-USDT",
+		Bids:         levels("3366.1", "0", "3365.5", "2"),
+		Asks:         levels("3367", "1"),
+		Checksum:     int32(crc32.ChecksumIEEE([]byte("3366:6:3366.8:9:3365.5:2:3367:1:3368:8"))),
+	}
+	if err := book.update(update); err != nil {
+		t.Fatal(err)
+	}
+	dep := book.depth()
+	if len(dep.BidList) != 2 || dep.BidList[0].Price != 3366 || dep.BidList[1].Price != 3365.5 {
+		t.Errorf("bids %v", dep.BidList)
+	}
+	if len(dep.AskList) != 3 || dep.AskList[0].Price != 3368 || dep.AskList[2].Price != 3366.8 {
+		t.Errorf("asks %v", dep.AskList)
+	}
+
+	update = depthResponse{
+		InstrumentId: "BTC-USDT",
+		Asks:         levels("3368", "0"),
+		Checksum:     1,
+	}
+	if err := book.update(update); err == nil {
+		t.Error("expected a checksum mismatch")
+	}
+}
+
+// TestSpotWs_UnsubscribeDepth feeds a depth update that was in flight when
+// the depth got unsubscribed, it must neither bring the channel back nor
+// count as a resync.
+func TestSpotWs_UnsubscribeDepth(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {
+		var req struct {
+			Op   string   `json:"op"`
+			Args []string `json:"args"`
+		}
+		json.Unmarshal([]byte(msg), &req)
+		for _, ch := range req.Args {
+			c.Send(`{"event":"` + req.Op + `","channel":"` + ch + `"}`)
+		}
+	})
+	defer srv.Close()
+
+	ws := NewSpotWs()
+	ws.SetBaseUrl(srv.URL)
+	defer ws.Close()
+	resyncs := make(chan string, 4)
+	ws.ResyncCallback(func(channel string, err error) { resyncs <- channel })
+	tickers := make(chan *goex.Ticker, 4)
+	ws.DepthCallback(func(depth *goex.Depth) {})
+	ws.TickerCallback(func(ticker *goex.Ticker) { tickers <- ticker })
+	if err := ws.SubscribeDepth(goex.BTC_USDT, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SubscribeTicker(goex.BTC_USDT); err != nil {
+		t.Fatal(err)
+	}
+	sent := func(op, channel string) int {
+		n := 0
+		for _, msg := range srv.Received() {
+			if strings.Contains(msg, `"`+op+`"`) && strings.Contains(msg, `"`+channel+`"`) {
+				n++
+			}
+		}
+		return n
+	}
+	if !wstest.Eventually(time.Second, func() bool { return sent("subscribe", "spot/depth:BTC-USDT") == 1 }) {
+		t.Fatalf("got %v, want the depth subscribed", srv.Received())
+	}
+
+	if err := ws.UnsubscribeDepth(goex.BTC_USDT); err != nil {
+		t.Fatal(err)
+	}
+	srv.Broadcast(`{"table":"spot/depth","action":"update","data":[{"instrument_id":"BTC-USDT","asks":[],"bids":[],"checksum":0}]}`)
+	// frames are handled in order, once the ticker is in so is the update
+	srv.Broadcast(`{"table":"spot/ticker","data":[{"instrument_id":"BTC-USDT","last":"1"}]}`)
+	select {
+	case <-tickers:
+	case <-time.After(time.Second):
+		t.Fatal("no ticker")
+	}
+	if n := sent("subscribe", "spot/depth:BTC-USDT"); n != 1 {
+		t.Fatalf("depth subscribed %d times, want once: %v", n, srv.Received())
+	}
+	select {
+	case channel := <-resyncs:
+		t.Fatalf("resync of %s after the unsubscribe", channel)
+	default:
+	}
+}
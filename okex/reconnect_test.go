@@ -0,0 +1,123 @@
+package okex
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goex-top/goexws/common"
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/nntaoli-project/goex"
+)
+
+func TestSpotWs_Resubscribe(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {
+		var req struct {
+			Op   string   `json:"op"`
+			Args []string `json:"args"`
+		}
+		json.Unmarshal([]byte(msg), &req)
+		for _, ch := range req.Args {
+			// the trade channel is never acknowledged
+			if req.Op == "subscribe" && !strings.HasPrefix(ch, "spot/trade") {
+				c.Send(`{"event":"subscribe","channel":"` + ch + `"}`)
+			}
+		}
+	})
+	defer srv.Close()
+
+	var (
+		lock   sync.Mutex
+		failed []string
+	)
+	ws := NewSpotWs()
+	ws.SetBaseUrl(srv.URL)
+	ws.v3Ws.subs.SetAckTimeout(100 * time.Millisecond)
+	ws.SubscribeFailedCallback(func(channel string, err error) {
+		lock.Lock()
+		failed = append(failed, channel)
+		lock.Unlock()
+	})
+	ws.TickerCallback(func(ticker *goex.Ticker) {})
+	ws.TradeCallback(func(trade *goex.Trade) {})
+	ws.SubscribeTicker(goex.BTC_USDT)
+	ws.SubscribeTrade(goex.BTC_USDT)
+
+	subscribes := func() int {
+		n := 0
+		for _, msg := range srv.Received() {
+			if strings.Contains(msg, `"subscribe"`) {
+				n++
+			}
+		}
+		return n
+	}
+	if !wstest.Eventually(time.Second, func() bool { return subscribes() == 2 }) {
+		t.Fatalf("got %v, want 2 subscriptions", srv.Received())
+	}
+
+	srv.Drop()
+	if !wstest.Eventually(3*time.Second, func() bool { return subscribes() == 4 }) {
+		t.Fatalf("got %v, want both subscriptions replayed", srv.Received())
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	// the first connection dropped before the ack timeout, only the replay
+	// is reported
+	if len(failed) != 1 || failed[0] != "spot/trade:BTC-USDT" {
+		t.Errorf("failed %v, want spot/trade:BTC-USDT", failed)
+	}
+}
+
+func TestSpotWs_Errors(t *testing.T) {
+	srv := wstest.NewServer(func(c *wstest.Conn, msg string) {
+		var req struct {
+			Args []string `json:"args"`
+		}
+		json.Unmarshal([]byte(msg), &req)
+		for _, ch := range req.Args {
+			c.Send(`{"event":"error","message":"Channel ` + ch + ` doesn't exist","errorCode":30040}`)
+		}
+		c.Send(`{"table":"spot/bbo","data":[{"instrument_id":"BTC-USDT"}]}`)
+	})
+	defer srv.Close()
+
+	errs := make(chan error, 16)
+	ws := NewSpotWs()
+	ws.SetBaseUrl(srv.URL)
+	ws.ErrorCallback(func(err error) {
+		errs <- err
+	})
+	ws.TickerCallback(func(ticker *goex.Ticker) {})
+	ws.SubscribeTicker(goex.BTC_USDT)
+
+	var (
+		unknownErr *common.UnknownChannelError
+		subErr     *common.SubscribeError
+	)
+	for unknownErr == nil || subErr == nil {
+		select {
+		case err := <-errs:
+			switch err := err.(type) {
+			case *common.UnknownChannelError:
+				unknownErr = err
+			case *common.SubscribeError:
+				subErr = err
+			default:
+				t.Errorf("unexpected error %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got unknown %v, subscribe %v", unknownErr, subErr)
+		}
+	}
+	if unknownErr.Channel != "spot/bbo" {
+		t.Errorf("unknown channel %v", unknownErr)
+	}
+	if e, ok := subErr.Err.(*common.ServerError); subErr.Channel != "spot/ticker:BTC-USDT" || !ok || e.Code != "30040" {
+		t.Errorf("subscribe error %v", subErr)
+	}
+}
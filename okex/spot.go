@@ -1,9 +1,11 @@
 package okex
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/goex-top/goexws/common"
 	. "github.com/nntaoli-project/goex"
 	"sort"
 	"strconv"
@@ -13,80 +15,208 @@ import (
 
 type SpotWs struct {
 	v3Ws           *baseWs
-	tickerCallback func(*Ticker)
-	depthCallback  func(*Depth)
-	tradeCallback  func(*Trade)
-	klineCallback  func(*Kline, int)
+	tickerCallback common.Callback[func(*Ticker)]
+	depthCallback  common.Callback[func(*Depth)]
+	tradeCallback  common.Callback[func(*Trade)]
+	klineCallback  common.Callback[func(*Kline, int)]
+	dispatcher     common.Dispatcher
+	depthRoutes    common.Fanout[*Depth]
+	tickerRoutes   common.Fanout[*Ticker]
+	tradeRoutes    common.Fanout[*Trade]
+	klineRoutes    common.Fanout[*Kline]
 }
 
 func NewSpotWs() *SpotWs {
 	ws := &SpotWs{}
 	ws.v3Ws = NewOKExV3Ws(ws.handle)
+	ws.v3Ws.bookHandle = ws.handleBook
+	ws.dispatcher = common.SerialPerSymbol(0)
 	return ws
 }
 
+// SetBaseUrl sets the websocket url, it has to be called before subscribing.
+func (ws *SpotWs) SetBaseUrl(baseURL string) {
+	ws.v3Ws.wsURL = baseURL
+}
+
+func (ws *SpotWs) ProxyUrl(proxyUrl string) {
+	ws.v3Ws.proxyUrl = proxyUrl
+}
+
+// SetDispatcher sets how the data callbacks are run, they are serial per
+// symbol by default. It has to be called before subscribing.
+func (ws *SpotWs) SetDispatcher(dispatcher common.Dispatcher) {
+	ws.dispatcher = dispatcher
+}
+
+// Close closes the connection and the subscription channels, no callback is
+// called once it returns. It must not be called from a callback.
+func (ws *SpotWs) Close() error {
+	err := ws.v3Ws.Close()
+	ws.dispatcher.Close()
+	ws.depthRoutes.Close()
+	ws.tickerRoutes.Close()
+	ws.tradeRoutes.Close()
+	ws.klineRoutes.Close()
+	return err
+}
+
+// SubscribeFailedCallback is told about every channel the server rejected or
+// did not acknowledge in time, be it on the first subscription or on the
+// replay after a reconnect.
+func (ws *SpotWs) SubscribeFailedCallback(call func(channel string, err error)) {
+	ws.v3Ws.setFailedCallback(call)
+}
+
+// ErrorCallback gets the messages that could not be parsed or handled, the
+// error replies of the server and the failed subscriptions.
+func (ws *SpotWs) ErrorCallback(call func(err error)) {
+	ws.v3Ws.errs.SetCallback(call)
+}
+
+// SetLogger sets where the connection lifecycle and the message errors are
+// logged, nothing is logged by default.
+func (ws *SpotWs) SetLogger(logger common.Logger) {
+	ws.v3Ws.log.SetLogger(logger)
+}
+
+// LogFrames turns debug logging of every raw frame sent and received on or
+// off, it takes effect right away.
+func (ws *SpotWs) LogFrames(on bool) {
+	ws.v3Ws.log.SetFrames(on)
+}
+
+// RecoverPanics turns the recovery of panics in the callbacks and message
+// handling on or off, it is on by default. A recovered panic is handed to the
+// error callback as a *common.PanicError and the connection stays up.
+func (ws *SpotWs) RecoverPanics(on bool) {
+	ws.v3Ws.panics.SetRecover(on)
+}
+
+// LifecycleCallback is told whenever the connection comes up, drops, is
+// redialed and has its subscriptions restored.
+func (ws *SpotWs) LifecycleCallback(call func(event *common.Event)) {
+	ws.v3Ws.events.SetCallback(call)
+}
+
+// SetMaxSilence sets how long channels of channelType may go without data
+// before the connection is dropped and redialed, 0 turns the check off. It
+// applies to the channels subscribed afterwards.
+func (ws *SpotWs) SetMaxSilence(channelType common.ChannelType, maxSilence time.Duration) {
+	ws.v3Ws.maxSilence.Set(channelType, maxSilence)
+}
+
+// ResyncCallback is told whenever a full depth book is thrown away and
+// resubscribed, e.g. on a checksum mismatch.
+func (ws *SpotWs) ResyncCallback(call func(channel string, err error)) {
+	ws.v3Ws.resyncCallback.Set(call)
+}
+
 func (ws *SpotWs) TickerCallback(tickerCallback func(*Ticker)) {
-	ws.tickerCallback = tickerCallback
+	ws.tickerCallback.Set(tickerCallback)
 }
 
 func (ws *SpotWs) DepthCallback(depthCallback func(*Depth)) {
-	ws.depthCallback = depthCallback
+	ws.depthCallback.Set(depthCallback)
 }
 
 func (ws *SpotWs) TradeCallback(tradeCallback func(*Trade)) {
-	ws.tradeCallback = tradeCallback
+	ws.tradeCallback.Set(tradeCallback)
 }
 
 func (ws *SpotWs) KlineCallback(call func(*Kline, int)) {
-	ws.klineCallback = call
+	ws.klineCallback.Set(call)
 }
 
 func (ws *SpotWs) KLineCallback(klineCallback func(kline *Kline, period int)) {
-	ws.klineCallback = klineCallback
+	ws.klineCallback.Set(klineCallback)
 }
 
 func (ws *SpotWs) SetCallbacks(tickerCallback func(*Ticker),
 	depthCallback func(*Depth),
 	tradeCallback func(*Trade),
 	klineCallback func(*Kline, int)) {
-	ws.tickerCallback = tickerCallback
-	ws.depthCallback = depthCallback
-	ws.tradeCallback = tradeCallback
-	ws.klineCallback = klineCallback
+	ws.tickerCallback.Set(tickerCallback)
+	ws.depthCallback.Set(depthCallback)
+	ws.tradeCallback.Set(tradeCallback)
+	ws.klineCallback.Set(klineCallback)
 }
 
 func (ws *SpotWs) SubscribeDepth(currencyPair CurrencyPair, size int) error {
-	if ws.depthCallback == nil {
+	if ws.depthCallback.Get() == nil {
+		return errors.New("please set depth callback func")
+	}
+	return ws.subscribeDepth(currencyPair, size)
+}
+
+func (ws *SpotWs) subscribeDepth(currencyPair CurrencyPair, size int) error {
+	channelSize, err := common.DepthChannelSize("okex", size, depthSizes)
+	if err != nil {
+		return err
+	}
+
+	table := "spot/depth5"
+	if channelSize > 5 {
+		table = "spot/depth"
+	}
+	channel := fmt.Sprintf("%s:%s", table, currencyPair.ToSymbol("-"))
+	ws.v3Ws.setDepthSize(channel, size)
+	return ws.v3Ws.Subscribe(map[string]interface{}{
+		"op":   "subscribe",
+		"args": []string{channel}})
+}
+
+// SubscribeFullDepth keeps a local 400 level book from spot/depth, or from
+// spot/depth_l2_tbt when tickByTick is set, verifying the checksum of every
+// update. DepthCallback gets the whole book after each update.
+func (ws *SpotWs) SubscribeFullDepth(currencyPair CurrencyPair, tickByTick bool) error {
+	if ws.depthCallback.Get() == nil {
 		return errors.New("please set depth callback func")
 	}
 
+	table := "spot/depth"
+	if tickByTick {
+		table = "spot/depth_l2_tbt"
+	}
 	return ws.v3Ws.Subscribe(map[string]interface{}{
 		"op":   "subscribe",
-		"args": []string{fmt.Sprintf("spot/depth5:%s", currencyPair.ToSymbol("-"))}})
+		"args": []string{fmt.Sprintf("%s:%s", table, currencyPair.ToSymbol("-"))}})
 }
 
 func (ws *SpotWs) SubscribeTicker(currencyPair CurrencyPair) error {
-	if ws.tickerCallback == nil {
+	if ws.tickerCallback.Get() == nil {
 		return errors.New("please set ticker callback func")
 	}
+	return ws.subscribeTicker(currencyPair)
+}
+
+func (ws *SpotWs) subscribeTicker(currencyPair CurrencyPair) error {
 	return ws.v3Ws.Subscribe(map[string]interface{}{
 		"op":   "subscribe",
 		"args": []string{fmt.Sprintf("spot/ticker:%s", currencyPair.ToSymbol("-"))}})
 }
 
 func (ws *SpotWs) SubscribeTrade(currencyPair CurrencyPair) error {
-	if ws.tradeCallback == nil {
+	if ws.tradeCallback.Get() == nil {
 		return errors.New("please set trade callback func")
 	}
+	return ws.subscribeTrade(currencyPair)
+}
+
+func (ws *SpotWs) subscribeTrade(currencyPair CurrencyPair) error {
 	return ws.v3Ws.Subscribe(map[string]interface{}{
 		"op":   "subscribe",
 		"args": []string{fmt.Sprintf("spot/trade:%s", currencyPair.ToSymbol("-"))}})
 }
 
 func (ws *SpotWs) SubscribeKline(currencyPair CurrencyPair, period int) error {
-	if ws.klineCallback == nil {
+	if ws.klineCallback.Get() == nil {
 		return errors.New("place set kline callback func")
 	}
+	return ws.subscribeKline(currencyPair, period)
+}
+
+func (ws *SpotWs) subscribeKline(currencyPair CurrencyPair, period int) error {
 
 	seconds := adaptKLinePeriod(period)
 	if seconds == -1 {
@@ -98,11 +228,163 @@ func (ws *SpotWs) SubscribeKline(currencyPair CurrencyPair, period int) error {
 		"args": []string{fmt.Sprintf("spot/candle%ds:%s", seconds, currencyPair.ToSymbol("-"))}})
 }
 
+// UnsubscribeDepth stops the depth of currencyPair, whichever size it was
+// subscribed with, including a SubscribeFullDepth book.
+func (ws *SpotWs) UnsubscribeDepth(currencyPair CurrencyPair) error {
+	ws.depthRoutes.Unhandle(common.RouteKey(currencyPair))
+	instrumentId := currencyPair.ToSymbol("-")
+	return ws.v3Ws.Unsubscribe(
+		"spot/depth5:"+instrumentId,
+		"spot/depth:"+instrumentId,
+		"spot/depth_l2_tbt:"+instrumentId)
+}
+
+func (ws *SpotWs) UnsubscribeTicker(currencyPair CurrencyPair) error {
+	ws.tickerRoutes.Unhandle(common.RouteKey(currencyPair))
+	return ws.v3Ws.Unsubscribe(fmt.Sprintf("spot/ticker:%s", currencyPair.ToSymbol("-")))
+}
+
+func (ws *SpotWs) UnsubscribeTrade(currencyPair CurrencyPair) error {
+	ws.tradeRoutes.Unhandle(common.RouteKey(currencyPair))
+	return ws.v3Ws.Unsubscribe(fmt.Sprintf("spot/trade:%s", currencyPair.ToSymbol("-")))
+}
+
+func (ws *SpotWs) UnsubscribeKline(currencyPair CurrencyPair, period int) error {
+	ws.klineRoutes.Unhandle(common.RouteKey(currencyPair, adaptKLinePeriod(period)))
+	seconds := adaptKLinePeriod(period)
+	if seconds == -1 {
+		return fmt.Errorf("unsupported kline period %d in okex", period)
+	}
+	return ws.v3Ws.Unsubscribe(fmt.Sprintf("spot/candle%ds:%s", seconds, currencyPair.ToSymbol("-")))
+}
+
+// SubscribeDepthChan subscribes to the depth of currencyPair and delivers it
+// on the returned channel, alongside the depth callback if one is set. The
+// channel is closed once ctx is done, the pair stays subscribed until
+// UnsubscribeDepth.
+func (ws *SpotWs) SubscribeDepthChan(ctx context.Context, currencyPair CurrencyPair, size int, opts ...common.StreamOption) (<-chan *Depth, error) {
+	return common.SubscribeChan(ctx, &ws.depthRoutes, common.RouteKey(currencyPair), func() error {
+		return ws.subscribeDepth(currencyPair, size)
+	}, opts...)
+}
+
+func (ws *SpotWs) SubscribeTickerChan(ctx context.Context, currencyPair CurrencyPair, opts ...common.StreamOption) (<-chan *Ticker, error) {
+	return common.SubscribeChan(ctx, &ws.tickerRoutes, common.RouteKey(currencyPair), func() error {
+		return ws.subscribeTicker(currencyPair)
+	}, opts...)
+}
+
+func (ws *SpotWs) SubscribeTradeChan(ctx context.Context, currencyPair CurrencyPair, opts ...common.StreamOption) (<-chan *Trade, error) {
+	return common.SubscribeChan(ctx, &ws.tradeRoutes, common.RouteKey(currencyPair), func() error {
+		return ws.subscribeTrade(currencyPair)
+	}, opts...)
+}
+
+func (ws *SpotWs) SubscribeKlineChan(ctx context.Context, currencyPair CurrencyPair, period int, opts ...common.StreamOption) (<-chan *Kline, error) {
+	return common.SubscribeChan(ctx, &ws.klineRoutes, common.RouteKey(currencyPair, adaptKLinePeriod(period)), func() error {
+		return ws.subscribeKline(currencyPair, period)
+	}, opts...)
+}
+
+// SubscribeDepthFunc subscribes to the depth of currencyPair and hands it to
+// call, besides the depth callback if one is set. call replaces the one it
+// was subscribed with before and is dropped by UnsubscribeDepth.
+func (ws *SpotWs) SubscribeDepthFunc(currencyPair CurrencyPair, size int, call func(depth *Depth)) error {
+	if call == nil {
+		return errors.New("please set depth callback func")
+	}
+	return common.SubscribeFunc(&ws.depthRoutes, common.RouteKey(currencyPair), call, func() error {
+		return ws.subscribeDepth(currencyPair, size)
+	})
+}
+
+func (ws *SpotWs) SubscribeTickerFunc(currencyPair CurrencyPair, call func(ticker *Ticker)) error {
+	if call == nil {
+		return errors.New("please set ticker callback func")
+	}
+	return common.SubscribeFunc(&ws.tickerRoutes, common.RouteKey(currencyPair), call, func() error {
+		return ws.subscribeTicker(currencyPair)
+	})
+}
+
+func (ws *SpotWs) SubscribeTradeFunc(currencyPair CurrencyPair, call func(trade *Trade)) error {
+	if call == nil {
+		return errors.New("please set trade callback func")
+	}
+	return common.SubscribeFunc(&ws.tradeRoutes, common.RouteKey(currencyPair), call, func() error {
+		return ws.subscribeTrade(currencyPair)
+	})
+}
+
+func (ws *SpotWs) SubscribeKlineFunc(currencyPair CurrencyPair, period int, call func(kline *Kline)) error {
+	if call == nil {
+		return errors.New("please set kline callback func")
+	}
+	return common.SubscribeFunc(&ws.klineRoutes, common.RouteKey(currencyPair, adaptKLinePeriod(period)), call, func() error {
+		return ws.subscribeKline(currencyPair, period)
+	})
+}
+
+// dispatch runs call through the dispatcher, a panic is recovered and
+// reported with the frame raw and data.
+func (ws *SpotWs) dispatch(key string, raw []byte, data interface{}, call func()) {
+	ws.dispatcher.Dispatch(key, func() {
+		ws.v3Ws.panics.Run(ws.v3Ws.errs.Report, raw, data, call)
+	})
+}
+
+func (ws *SpotWs) onDepth(raw []byte, depth *Depth) {
+	key := common.RouteKey(depth.Pair)
+	ws.dispatch(key, raw, depth, func() {
+		if call := ws.depthCallback.Get(); call != nil {
+			call(depth)
+		}
+		ws.depthRoutes.Emit(key, depth)
+	})
+}
+
+func (ws *SpotWs) onTicker(raw []byte, ticker *Ticker) {
+	key := common.RouteKey(ticker.Pair)
+	ws.dispatch(key, raw, ticker, func() {
+		if call := ws.tickerCallback.Get(); call != nil {
+			call(ticker)
+		}
+		ws.tickerRoutes.Emit(key, ticker)
+	})
+}
+
+func (ws *SpotWs) onTrade(raw []byte, trade *Trade) {
+	key := common.RouteKey(trade.Pair)
+	ws.dispatch(key, raw, trade, func() {
+		if call := ws.tradeCallback.Get(); call != nil {
+			call(trade)
+		}
+		ws.tradeRoutes.Emit(key, trade)
+	})
+}
+
+// onKline routes by the candle seconds of the channel, several goex periods
+// map onto the same candle.
+func (ws *SpotWs) onKline(raw []byte, kline *Kline, seconds int) {
+	key := common.RouteKey(kline.Pair, seconds)
+	ws.dispatch(key, raw, kline, func() {
+		if call := ws.klineCallback.Get(); call != nil {
+			call(kline, adaptSecondsToKlinePeriod(seconds))
+		}
+		ws.klineRoutes.Emit(key, kline)
+	})
+}
+
 func (ws *SpotWs) getCurrencyPair(instrumentId string) CurrencyPair {
 	return NewCurrencyPair3(instrumentId, "-")
 }
 
-func (ws *SpotWs) handle(ch string, data json.RawMessage) error {
+func (ws *SpotWs) handleBook(table string, instrumentId string, raw []byte, depth *Depth) {
+	depth.Pair = ws.getCurrencyPair(instrumentId)
+	ws.onDepth(raw, depth)
+}
+
+func (ws *SpotWs) handle(ch string, raw []byte, data json.RawMessage) error {
 	var (
 		err           error
 		tickers       []spotTickerResponse
@@ -126,12 +408,12 @@ func (ws *SpotWs) handle(ch string, data json.RawMessage) error {
 	case "spot/ticker":
 		err = json.Unmarshal(data, &tickers)
 		if err != nil {
-			return err
+			return &common.ParseError{Raw: data, Err: err}
 		}
 
 		for _, t := range tickers {
 			date, _ := time.Parse(time.RFC3339, t.Timestamp)
-			ws.tickerCallback(&Ticker{
+			ws.onTicker(raw, &Ticker{
 				Pair: ws.getCurrencyPair(t.InstrumentId),
 				Last: t.Last,
 				Buy:  t.BestBid,
@@ -146,8 +428,7 @@ func (ws *SpotWs) handle(ch string, data json.RawMessage) error {
 	case "spot/depth5":
 		err := json.Unmarshal(data, &depthResp)
 		if err != nil {
-			//logger.Error(err)
-			return err
+			return &common.ParseError{Raw: data, Err: err}
 		}
 		if len(depthResp) == 0 {
 			return nil
@@ -166,14 +447,13 @@ func (ws *SpotWs) handle(ch string, data json.RawMessage) error {
 				Amount: ToFloat64(itm[1])})
 		}
 		sort.Sort(sort.Reverse(dep.AskList))
-		//call back func
-		ws.depthCallback(&dep)
+		common.TruncateDepth(&dep, ws.v3Ws.depthSize(ch+":"+depthResp[0].InstrumentId))
+		ws.onDepth(raw, &dep)
 		return nil
 	case "spot/trade":
 		err := json.Unmarshal(data, &tradeResponse)
 		if err != nil {
-			//logger.Error("unmarshal error :", err)
-			return err
+			return &common.ParseError{Raw: data, Err: err}
 		}
 
 		for _, resp := range tradeResponse {
@@ -185,10 +465,10 @@ func (ws *SpotWs) handle(ch string, data json.RawMessage) error {
 
 			t, err := time.Parse(time.RFC3339, resp.Timestamp)
 			if err != nil {
-				//logger.Warn("parse timestamp error:", err)
+				ws.v3Ws.log.Logger().Warn("okex trade timestamp", "timestamp", resp.Timestamp, "err", err)
 			}
 
-			ws.tradeCallback(&Trade{
+			ws.onTrade(raw, &Trade{
 				Tid:    resp.TradeId,
 				Type:   tradeSide,
 				Amount: resp.Qty,
@@ -202,14 +482,14 @@ func (ws *SpotWs) handle(ch string, data json.RawMessage) error {
 		if strings.HasPrefix(ch, "spot/candle") {
 			err := json.Unmarshal(data, &candleResponse)
 			if err != nil {
-				return err
+				return &common.ParseError{Raw: data, Err: err}
 			}
 			periodMs := strings.TrimPrefix(ch, "spot/candle")
 			periodMs = strings.TrimSuffix(periodMs, "s")
 			for _, k := range candleResponse {
 				pair := ws.getCurrencyPair(k.InstrumentId)
 				tm, _ := time.Parse(time.RFC3339, k.Candle[0])
-				ws.klineCallback(&Kline{
+				ws.onKline(raw, &Kline{
 					Pair:      pair,
 					Timestamp: tm.Unix(),
 					Open:      ToFloat64(k.Candle[1]),
@@ -217,13 +497,13 @@ func (ws *SpotWs) handle(ch string, data json.RawMessage) error {
 					High:      ToFloat64(k.Candle[2]),
 					Low:       ToFloat64(k.Candle[3]),
 					Vol:       ToFloat64(k.Candle[5]),
-				}, adaptSecondsToKlinePeriod(ToInt(periodMs)))
+				}, ToInt(periodMs))
 			}
 			return nil
 		}
 	}
 
-	return fmt.Errorf("unknown websocket message: %s", string(data))
+	return &common.UnknownChannelError{Channel: ch, Raw: data}
 }
 
 func (ws *SpotWs) getKlinePeriodFormChannel(channel string) int {
@@ -1 +1,672 @@
 package okex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/goex-top/goexws/common"
+	. "github.com/nntaoli-project/goex"
+	"sort"
+	"strings"
+	"time"
+)
+
+type FundingRate struct {
+	Pair           CurrencyPair
+	InstrumentId   string
+	FundingRate    float64
+	EstimatedRate  float64
+	InterestRate   float64
+	FundingTime    time.Time
+	SettlementTime time.Time
+}
+
+type MarkPrice struct {
+	Pair         CurrencyPair
+	InstrumentId string
+	MarkPrice    float64
+	Timestamp    time.Time
+}
+
+type SwapWs struct {
+	v3Ws                *baseWs
+	tickerCallback      common.Callback[func(*FutureTicker)]
+	depthCallback       common.Callback[func(*Depth)]
+	tradeCallback       common.Callback[func(*Trade, string)]
+	klineCallback       common.Callback[func(*FutureKline, int, string)]
+	fundingRateCallback common.Callback[func(*FundingRate)]
+	markPriceCallback   common.Callback[func(*MarkPrice)]
+	dispatcher          common.Dispatcher
+	depthRoutes         common.Fanout[*Depth]
+	tickerRoutes        common.Fanout[*FutureTicker]
+	tradeRoutes         common.Fanout[*Trade]
+	klineRoutes         common.Fanout[*FutureKline]
+	tradeFuncs          common.Fanout[common.ContractTrade]
+	klineFuncs          common.Fanout[common.ContractKline]
+}
+
+func NewSwapWs() *SwapWs {
+	ws := &SwapWs{}
+	ws.v3Ws = NewOKExV3Ws(ws.handle)
+	ws.v3Ws.bookHandle = ws.handleBook
+	ws.dispatcher = common.SerialPerSymbol(0)
+	return ws
+}
+
+// SetBaseUrl sets the websocket url, it has to be called before subscribing.
+func (ws *SwapWs) SetBaseUrl(baseURL string) {
+	ws.v3Ws.wsURL = baseURL
+}
+
+func (ws *SwapWs) ProxyUrl(proxyUrl string) {
+	ws.v3Ws.proxyUrl = proxyUrl
+}
+
+// SetDispatcher sets how the data callbacks are run, they are serial per
+// symbol by default. It has to be called before subscribing.
+func (ws *SwapWs) SetDispatcher(dispatcher common.Dispatcher) {
+	ws.dispatcher = dispatcher
+}
+
+// Close closes the connection and the subscription channels, no callback is
+// called once it returns. It must not be called from a callback.
+func (ws *SwapWs) Close() error {
+	err := ws.v3Ws.Close()
+	ws.dispatcher.Close()
+	ws.depthRoutes.Close()
+	ws.tickerRoutes.Close()
+	ws.tradeRoutes.Close()
+	ws.klineRoutes.Close()
+	ws.tradeFuncs.Close()
+	ws.klineFuncs.Close()
+	return err
+}
+
+// SubscribeFailedCallback is told about every channel the server rejected or
+// did not acknowledge in time, be it on the first subscription or on the
+// replay after a reconnect.
+func (ws *SwapWs) SubscribeFailedCallback(call func(channel string, err error)) {
+	ws.v3Ws.setFailedCallback(call)
+}
+
+// ErrorCallback gets the messages that could not be parsed or handled, the
+// error replies of the server and the failed subscriptions.
+func (ws *SwapWs) ErrorCallback(call func(err error)) {
+	ws.v3Ws.errs.SetCallback(call)
+}
+
+// SetLogger sets where the connection lifecycle and the message errors are
+// logged, nothing is logged by default.
+func (ws *SwapWs) SetLogger(logger common.Logger) {
+	ws.v3Ws.log.SetLogger(logger)
+}
+
+// LogFrames turns debug logging of every raw frame sent and received on or
+// off, it takes effect right away.
+func (ws *SwapWs) LogFrames(on bool) {
+	ws.v3Ws.log.SetFrames(on)
+}
+
+// RecoverPanics turns the recovery of panics in the callbacks and message
+// handling on or off, it is on by default. A recovered panic is handed to the
+// error callback as a *common.PanicError and the connection stays up.
+func (ws *SwapWs) RecoverPanics(on bool) {
+	ws.v3Ws.panics.SetRecover(on)
+}
+
+// LifecycleCallback is told whenever the connection comes up, drops, is
+// redialed and has its subscriptions restored.
+func (ws *SwapWs) LifecycleCallback(call func(event *common.Event)) {
+	ws.v3Ws.events.SetCallback(call)
+}
+
+// SetMaxSilence sets how long channels of channelType may go without data
+// before the connection is dropped and redialed, 0 turns the check off. It
+// applies to the channels subscribed afterwards.
+func (ws *SwapWs) SetMaxSilence(channelType common.ChannelType, maxSilence time.Duration) {
+	ws.v3Ws.maxSilence.Set(channelType, maxSilence)
+}
+
+// ResyncCallback is told whenever a full depth book is thrown away and
+// resubscribed, e.g. on a checksum mismatch.
+func (ws *SwapWs) ResyncCallback(call func(channel string, err error)) {
+	ws.v3Ws.resyncCallback.Set(call)
+}
+
+func (ws *SwapWs) TickerCallback(tickerCallback func(*FutureTicker)) {
+	ws.tickerCallback.Set(tickerCallback)
+}
+
+func (ws *SwapWs) DepthCallback(depthCallback func(*Depth)) {
+	ws.depthCallback.Set(depthCallback)
+}
+
+func (ws *SwapWs) TradeCallback(tradeCallback func(*Trade, string)) {
+	ws.tradeCallback.Set(tradeCallback)
+}
+
+func (ws *SwapWs) KlineCallback(klineCallback func(*FutureKline, int, string)) {
+	ws.klineCallback.Set(klineCallback)
+}
+
+func (ws *SwapWs) FundingRateCallback(fundingRateCallback func(*FundingRate)) {
+	ws.fundingRateCallback.Set(fundingRateCallback)
+}
+
+func (ws *SwapWs) MarkPriceCallback(markPriceCallback func(*MarkPrice)) {
+	ws.markPriceCallback.Set(markPriceCallback)
+}
+
+func (ws *SwapWs) SetCallbacks(tickerCallback func(*FutureTicker),
+	depthCallback func(*Depth),
+	tradeCallback func(*Trade, string),
+	klineCallback func(*FutureKline, int, string)) {
+	ws.tickerCallback.Set(tickerCallback)
+	ws.depthCallback.Set(depthCallback)
+	ws.tradeCallback.Set(tradeCallback)
+	ws.klineCallback.Set(klineCallback)
+}
+
+func (ws *SwapWs) getInstrumentId(pair CurrencyPair) string {
+	return fmt.Sprintf("%s-SWAP", pair.ToSymbol("-"))
+}
+
+func (ws *SwapWs) getCurrencyPair(instrumentId string) CurrencyPair {
+	return NewCurrencyPair3(strings.TrimSuffix(instrumentId, "-SWAP"), "-")
+}
+
+// checkContract rejects the contract types other than SWAP_CONTRACT, the
+// socket only serves perpetual swaps.
+func checkContract(contractType string) error {
+	if contractType != SWAP_CONTRACT {
+		return fmt.Errorf("unsupported contract type %s in okex swap", contractType)
+	}
+	return nil
+}
+
+func (ws *SwapWs) SubscribeDepth(pair CurrencyPair, size int, contractType string) error {
+	if ws.depthCallback.Get() == nil {
+		return errors.New("please set depth callback func")
+	}
+	return ws.subscribeDepth(pair, size, contractType)
+}
+
+func (ws *SwapWs) subscribeDepth(pair CurrencyPair, size int, contractType string) error {
+	if err := checkContract(contractType); err != nil {
+		return err
+	}
+	channelSize, err := common.DepthChannelSize("okex", size, depthSizes)
+	if err != nil {
+		return err
+	}
+
+	table := "swap/depth5"
+	if channelSize > 5 {
+		table = "swap/depth"
+	}
+	channel := fmt.Sprintf("%s:%s", table, ws.getInstrumentId(pair))
+	ws.v3Ws.setDepthSize(channel, size)
+	return ws.v3Ws.Subscribe(map[string]interface{}{
+		"op":   "subscribe",
+		"args": []string{channel}})
+}
+
+// SubscribeFullDepth keeps a local 400 level book from swap/depth, or from
+// swap/depth_l2_tbt when tickByTick is set, verifying the checksum of every
+// update. DepthCallback gets the whole book after each update.
+func (ws *SwapWs) SubscribeFullDepth(pair CurrencyPair, contractType string, tickByTick bool) error {
+	if ws.depthCallback.Get() == nil {
+		return errors.New("please set depth callback func")
+	}
+	if err := checkContract(contractType); err != nil {
+		return err
+	}
+
+	table := "swap/depth"
+	if tickByTick {
+		table = "swap/depth_l2_tbt"
+	}
+	return ws.v3Ws.Subscribe(map[string]interface{}{
+		"op":   "subscribe",
+		"args": []string{fmt.Sprintf("%s:%s", table, ws.getInstrumentId(pair))}})
+}
+
+func (ws *SwapWs) SubscribeTicker(pair CurrencyPair, contractType string) error {
+	if ws.tickerCallback.Get() == nil {
+		return errors.New("please set ticker callback func")
+	}
+	return ws.subscribeTicker(pair, contractType)
+}
+
+func (ws *SwapWs) subscribeTicker(pair CurrencyPair, contractType string) error {
+	if err := checkContract(contractType); err != nil {
+		return err
+	}
+	return ws.v3Ws.Subscribe(map[string]interface{}{
+		"op":   "subscribe",
+		"args": []string{fmt.Sprintf("swap/ticker:%s", ws.getInstrumentId(pair))}})
+}
+
+func (ws *SwapWs) SubscribeTrade(pair CurrencyPair, contractType string) error {
+	if ws.tradeCallback.Get() == nil {
+		return errors.New("please set trade callback func")
+	}
+	return ws.subscribeTrade(pair, contractType)
+}
+
+func (ws *SwapWs) subscribeTrade(pair CurrencyPair, contractType string) error {
+	if err := checkContract(contractType); err != nil {
+		return err
+	}
+	return ws.v3Ws.Subscribe(map[string]interface{}{
+		"op":   "subscribe",
+		"args": []string{fmt.Sprintf("swap/trade:%s", ws.getInstrumentId(pair))}})
+}
+
+func (ws *SwapWs) SubscribeKline(pair CurrencyPair, period int, contractType string) error {
+	if ws.klineCallback.Get() == nil {
+		return errors.New("place set kline callback func")
+	}
+	return ws.subscribeKline(pair, period, contractType)
+}
+
+func (ws *SwapWs) subscribeKline(pair CurrencyPair, period int, contractType string) error {
+	if err := checkContract(contractType); err != nil {
+		return err
+	}
+
+	seconds := adaptKLinePeriod(period)
+	if seconds == -1 {
+		return fmt.Errorf("unsupported kline period %d in okex", period)
+	}
+
+	return ws.v3Ws.Subscribe(map[string]interface{}{
+		"op":   "subscribe",
+		"args": []string{fmt.Sprintf("swap/candle%ds:%s", seconds, ws.getInstrumentId(pair))}})
+}
+
+func (ws *SwapWs) SubscribeFundingRate(pair CurrencyPair) error {
+	if ws.fundingRateCallback.Get() == nil {
+		return errors.New("please set funding rate callback func")
+	}
+	return ws.v3Ws.Subscribe(map[string]interface{}{
+		"op":   "subscribe",
+		"args": []string{fmt.Sprintf("swap/funding_rate:%s", ws.getInstrumentId(pair))}})
+}
+
+func (ws *SwapWs) SubscribeMarkPrice(pair CurrencyPair) error {
+	if ws.markPriceCallback.Get() == nil {
+		return errors.New("please set mark price callback func")
+	}
+	return ws.v3Ws.Subscribe(map[string]interface{}{
+		"op":   "subscribe",
+		"args": []string{fmt.Sprintf("swap/mark_price:%s", ws.getInstrumentId(pair))}})
+}
+
+// UnsubscribeDepth stops the depth of pair, whichever size it was subscribed
+// with, including a SubscribeFullDepth book.
+func (ws *SwapWs) UnsubscribeDepth(pair CurrencyPair, contractType string) error {
+	if err := checkContract(contractType); err != nil {
+		return err
+	}
+	ws.depthRoutes.Unhandle(common.RouteKey(pair, SWAP_CONTRACT))
+	instrumentId := ws.getInstrumentId(pair)
+	return ws.v3Ws.Unsubscribe(
+		"swap/depth5:"+instrumentId,
+		"swap/depth:"+instrumentId,
+		"swap/depth_l2_tbt:"+instrumentId)
+}
+
+func (ws *SwapWs) UnsubscribeTicker(pair CurrencyPair, contractType string) error {
+	if err := checkContract(contractType); err != nil {
+		return err
+	}
+	ws.tickerRoutes.Unhandle(common.RouteKey(pair, SWAP_CONTRACT))
+	return ws.v3Ws.Unsubscribe(fmt.Sprintf("swap/ticker:%s", ws.getInstrumentId(pair)))
+}
+
+func (ws *SwapWs) UnsubscribeTrade(pair CurrencyPair, contractType string) error {
+	if err := checkContract(contractType); err != nil {
+		return err
+	}
+	ws.tradeFuncs.Unhandle(common.RouteKey(pair, SWAP_CONTRACT))
+	return ws.v3Ws.Unsubscribe(fmt.Sprintf("swap/trade:%s", ws.getInstrumentId(pair)))
+}
+
+func (ws *SwapWs) UnsubscribeKline(pair CurrencyPair, period int, contractType string) error {
+	if err := checkContract(contractType); err != nil {
+		return err
+	}
+	ws.klineFuncs.Unhandle(common.RouteKey(pair, SWAP_CONTRACT, adaptKLinePeriod(period)))
+	seconds := adaptKLinePeriod(period)
+	if seconds == -1 {
+		return fmt.Errorf("unsupported kline period %d in okex", period)
+	}
+	return ws.v3Ws.Unsubscribe(fmt.Sprintf("swap/candle%ds:%s", seconds, ws.getInstrumentId(pair)))
+}
+
+func (ws *SwapWs) UnsubscribeFundingRate(pair CurrencyPair) error {
+	return ws.v3Ws.Unsubscribe(fmt.Sprintf("swap/funding_rate:%s", ws.getInstrumentId(pair)))
+}
+
+func (ws *SwapWs) UnsubscribeMarkPrice(pair CurrencyPair) error {
+	return ws.v3Ws.Unsubscribe(fmt.Sprintf("swap/mark_price:%s", ws.getInstrumentId(pair)))
+}
+
+// SubscribeDepthChan subscribes to the depth of pair and delivers it on the
+// returned channel, alongside the depth callback if one is set. The channel
+// is closed once ctx is done, the pair stays subscribed until
+// UnsubscribeDepth.
+func (ws *SwapWs) SubscribeDepthChan(ctx context.Context, pair CurrencyPair, size int, contractType string, opts ...common.StreamOption) (<-chan *Depth, error) {
+	return common.SubscribeChan(ctx, &ws.depthRoutes, common.RouteKey(pair, SWAP_CONTRACT), func() error {
+		return ws.subscribeDepth(pair, size, contractType)
+	}, opts...)
+}
+
+func (ws *SwapWs) SubscribeTickerChan(ctx context.Context, pair CurrencyPair, contractType string, opts ...common.StreamOption) (<-chan *FutureTicker, error) {
+	return common.SubscribeChan(ctx, &ws.tickerRoutes, common.RouteKey(pair, SWAP_CONTRACT), func() error {
+		return ws.subscribeTicker(pair, contractType)
+	}, opts...)
+}
+
+func (ws *SwapWs) SubscribeTradeChan(ctx context.Context, pair CurrencyPair, contractType string, opts ...common.StreamOption) (<-chan *Trade, error) {
+	return common.SubscribeChan(ctx, &ws.tradeRoutes, common.RouteKey(pair, SWAP_CONTRACT), func() error {
+		return ws.subscribeTrade(pair, contractType)
+	}, opts...)
+}
+
+func (ws *SwapWs) SubscribeKlineChan(ctx context.Context, pair CurrencyPair, period int, contractType string, opts ...common.StreamOption) (<-chan *FutureKline, error) {
+	return common.SubscribeChan(ctx, &ws.klineRoutes, common.RouteKey(pair, SWAP_CONTRACT, adaptKLinePeriod(period)), func() error {
+		return ws.subscribeKline(pair, period, contractType)
+	}, opts...)
+}
+
+// SubscribeDepthFunc subscribes to the depth of pair and hands it to call,
+// besides the depth callback if one is set. call replaces the one it was
+// subscribed with before and is dropped by UnsubscribeDepth.
+func (ws *SwapWs) SubscribeDepthFunc(pair CurrencyPair, size int, contractType string, call func(depth *Depth)) error {
+	if call == nil {
+		return errors.New("please set depth callback func")
+	}
+	return common.SubscribeFunc(&ws.depthRoutes, common.RouteKey(pair, SWAP_CONTRACT), call, func() error {
+		return ws.subscribeDepth(pair, size, contractType)
+	})
+}
+
+func (ws *SwapWs) SubscribeTickerFunc(pair CurrencyPair, contractType string, call func(ticker *FutureTicker)) error {
+	if call == nil {
+		return errors.New("please set ticker callback func")
+	}
+	return common.SubscribeFunc(&ws.tickerRoutes, common.RouteKey(pair, SWAP_CONTRACT), call, func() error {
+		return ws.subscribeTicker(pair, contractType)
+	})
+}
+
+func (ws *SwapWs) SubscribeTradeFunc(pair CurrencyPair, contractType string, call func(trade *Trade, contract string)) error {
+	if call == nil {
+		return errors.New("please set trade callback func")
+	}
+	return common.SubscribeFunc(&ws.tradeFuncs, common.RouteKey(pair, SWAP_CONTRACT), func(t common.ContractTrade) {
+		call(t.Trade, t.Contract)
+	}, func() error {
+		return ws.subscribeTrade(pair, contractType)
+	})
+}
+
+func (ws *SwapWs) SubscribeKlineFunc(pair CurrencyPair, period int, contractType string, call func(kline *FutureKline, period int, contract string)) error {
+	if call == nil {
+		return errors.New("please set kline callback func")
+	}
+	return common.SubscribeFunc(&ws.klineFuncs, common.RouteKey(pair, SWAP_CONTRACT, adaptKLinePeriod(period)), func(k common.ContractKline) {
+		call(k.Kline, k.Period, k.Contract)
+	}, func() error {
+		return ws.subscribeKline(pair, period, contractType)
+	})
+}
+
+// dispatch runs call through the dispatcher, a panic is recovered and
+// reported with the frame raw and data.
+func (ws *SwapWs) dispatch(key string, raw []byte, data interface{}, call func()) {
+	ws.dispatcher.Dispatch(key, func() {
+		ws.v3Ws.panics.Run(ws.v3Ws.errs.Report, raw, data, call)
+	})
+}
+
+func (ws *SwapWs) onDepth(raw []byte, depth *Depth) {
+	key := common.RouteKey(depth.Pair, SWAP_CONTRACT)
+	ws.dispatch(key, raw, depth, func() {
+		if call := ws.depthCallback.Get(); call != nil {
+			call(depth)
+		}
+		ws.depthRoutes.Emit(key, depth)
+	})
+}
+
+func (ws *SwapWs) onTicker(raw []byte, ticker *FutureTicker) {
+	key := common.RouteKey(ticker.Pair, SWAP_CONTRACT)
+	ws.dispatch(key, raw, ticker, func() {
+		if call := ws.tickerCallback.Get(); call != nil {
+			call(ticker)
+		}
+		ws.tickerRoutes.Emit(key, ticker)
+	})
+}
+
+func (ws *SwapWs) onTrade(raw []byte, trade *Trade) {
+	key := common.RouteKey(trade.Pair, SWAP_CONTRACT)
+	ws.dispatch(key, raw, trade, func() {
+		if call := ws.tradeCallback.Get(); call != nil {
+			call(trade, SWAP_CONTRACT)
+		}
+		ws.tradeRoutes.Emit(key, trade)
+		ws.tradeFuncs.Emit(key, common.ContractTrade{Trade: trade, Contract: SWAP_CONTRACT})
+	})
+}
+
+// onKline routes by the candle seconds of the channel, several goex periods
+// map onto the same candle.
+func (ws *SwapWs) onKline(raw []byte, kline *FutureKline, seconds int) {
+	key := common.RouteKey(kline.Pair, SWAP_CONTRACT, seconds)
+	ws.dispatch(key, raw, kline, func() {
+		if call := ws.klineCallback.Get(); call != nil {
+			call(kline, adaptSecondsToKlinePeriod(seconds), SWAP_CONTRACT)
+		}
+		ws.klineRoutes.Emit(key, kline)
+		ws.klineFuncs.Emit(key, common.ContractKline{Kline: kline, Period: adaptSecondsToKlinePeriod(seconds), Contract: SWAP_CONTRACT})
+	})
+}
+
+func (ws *SwapWs) onFundingRate(raw []byte, rate *FundingRate) {
+	ws.dispatch(common.RouteKey(rate.Pair, SWAP_CONTRACT), raw, rate, func() {
+		if call := ws.fundingRateCallback.Get(); call != nil {
+			call(rate)
+		}
+	})
+}
+
+func (ws *SwapWs) onMarkPrice(raw []byte, price *MarkPrice) {
+	ws.dispatch(common.RouteKey(price.Pair, SWAP_CONTRACT), raw, price, func() {
+		if call := ws.markPriceCallback.Get(); call != nil {
+			call(price)
+		}
+	})
+}
+
+func (ws *SwapWs) handleBook(table string, instrumentId string, raw []byte, depth *Depth) {
+	depth.Pair = ws.getCurrencyPair(instrumentId)
+	depth.ContractType = SWAP_CONTRACT
+	ws.onDepth(raw, depth)
+}
+
+func (ws *SwapWs) handle(ch string, raw []byte, data json.RawMessage) error {
+	var (
+		err           error
+		tickers       []tickerResponse
+		depthResp     []depthResponse
+		dep           Depth
+		tradeResponse []struct {
+			Side         string  `json:"side"`
+			TradeId      int64   `json:"trade_id,string"`
+			Price        float64 `json:"price,string"`
+			Size         float64 `json:"size,string"`
+			InstrumentId string  `json:"instrument_id"`
+			Timestamp    string  `json:"timestamp"`
+		}
+		candleResponse []struct {
+			Candle       []string `json:"candle"`
+			InstrumentId string   `json:"instrument_id"`
+		}
+		fundingRateResponse []struct {
+			InstrumentId   string  `json:"instrument_id"`
+			FundingRate    float64 `json:"funding_rate,string"`
+			EstimatedRate  float64 `json:"estimated_rate,string"`
+			InterestRate   float64 `json:"interest_rate,string"`
+			FundingTime    string  `json:"funding_time"`
+			SettlementTime string  `json:"settlement_time"`
+		}
+		markPriceResponse []struct {
+			InstrumentId string  `json:"instrument_id"`
+			MarkPrice    float64 `json:"mark_price,string"`
+			Timestamp    string  `json:"timestamp"`
+		}
+	)
+
+	switch ch {
+	case "swap/ticker":
+		err = json.Unmarshal(data, &tickers)
+		if err != nil {
+			return &common.ParseError{Raw: data, Err: err}
+		}
+
+		for _, t := range tickers {
+			date, _ := time.Parse(time.RFC3339, t.Timestamp)
+			ws.onTicker(raw, &FutureTicker{
+				Ticker: &Ticker{
+					Pair: ws.getCurrencyPair(t.InstrumentId),
+					Last: t.Last,
+					Buy:  t.BestBid,
+					Sell: t.BestAsk,
+					High: t.High24h,
+					Low:  t.Low24h,
+					Vol:  t.Volume24h,
+					Date: uint64(date.UnixNano() / int64(time.Millisecond)),
+				},
+				ContractType: SWAP_CONTRACT,
+			})
+		}
+		return nil
+	case "swap/depth5":
+		err := json.Unmarshal(data, &depthResp)
+		if err != nil {
+			return &common.ParseError{Raw: data, Err: err}
+		}
+		if len(depthResp) == 0 {
+			return nil
+		}
+		dep.Pair = ws.getCurrencyPair(depthResp[0].InstrumentId)
+		dep.ContractType = SWAP_CONTRACT
+		dep.UTime, _ = time.Parse(time.RFC3339, depthResp[0].Timestamp)
+		for _, itm := range depthResp[0].Asks {
+			dep.AskList = append(dep.AskList, DepthRecord{
+				Price:  ToFloat64(itm[0]),
+				Amount: ToFloat64(itm[1])})
+		}
+		for _, itm := range depthResp[0].Bids {
+			dep.BidList = append(dep.BidList, DepthRecord{
+				Price:  ToFloat64(itm[0]),
+				Amount: ToFloat64(itm[1])})
+		}
+		sort.Sort(sort.Reverse(dep.AskList))
+		common.TruncateDepth(&dep, ws.v3Ws.depthSize(ch+":"+depthResp[0].InstrumentId))
+		ws.onDepth(raw, &dep)
+		return nil
+	case "swap/trade":
+		err := json.Unmarshal(data, &tradeResponse)
+		if err != nil {
+			return &common.ParseError{Raw: data, Err: err}
+		}
+
+		for _, resp := range tradeResponse {
+			tradeSide := SELL
+			switch resp.Side {
+			case "buy":
+				tradeSide = BUY
+			}
+
+			t, _ := time.Parse(time.RFC3339, resp.Timestamp)
+			ws.onTrade(raw, &Trade{
+				Tid:    resp.TradeId,
+				Type:   tradeSide,
+				Amount: resp.Size,
+				Price:  resp.Price,
+				Date:   t.Unix(),
+				Pair:   ws.getCurrencyPair(resp.InstrumentId),
+			})
+		}
+		return nil
+	case "swap/funding_rate":
+		err := json.Unmarshal(data, &fundingRateResponse)
+		if err != nil {
+			return &common.ParseError{Raw: data, Err: err}
+		}
+
+		for _, resp := range fundingRateResponse {
+			fundingTime, _ := time.Parse(time.RFC3339, resp.FundingTime)
+			settlementTime, _ := time.Parse(time.RFC3339, resp.SettlementTime)
+			ws.onFundingRate(raw, &FundingRate{
+				Pair:           ws.getCurrencyPair(resp.InstrumentId),
+				InstrumentId:   resp.InstrumentId,
+				FundingRate:    resp.FundingRate,
+				EstimatedRate:  resp.EstimatedRate,
+				InterestRate:   resp.InterestRate,
+				FundingTime:    fundingTime,
+				SettlementTime: settlementTime,
+			})
+		}
+		return nil
+	case "swap/mark_price":
+		err := json.Unmarshal(data, &markPriceResponse)
+		if err != nil {
+			return &common.ParseError{Raw: data, Err: err}
+		}
+
+		for _, resp := range markPriceResponse {
+			ts, _ := time.Parse(time.RFC3339, resp.Timestamp)
+			ws.onMarkPrice(raw, &MarkPrice{
+				Pair:         ws.getCurrencyPair(resp.InstrumentId),
+				InstrumentId: resp.InstrumentId,
+				MarkPrice:    resp.MarkPrice,
+				Timestamp:    ts,
+			})
+		}
+		return nil
+	default:
+		if strings.HasPrefix(ch, "swap/candle") {
+			err := json.Unmarshal(data, &candleResponse)
+			if err != nil {
+				return &common.ParseError{Raw: data, Err: err}
+			}
+			periodMs := strings.TrimPrefix(ch, "swap/candle")
+			periodMs = strings.TrimSuffix(periodMs, "s")
+			for _, k := range candleResponse {
+				tm, _ := time.Parse(time.RFC3339, k.Candle[0])
+				ws.onKline(raw, &FutureKline{
+					Kline: &Kline{
+						Pair:      ws.getCurrencyPair(k.InstrumentId),
+						Timestamp: tm.Unix(),
+						Open:      ToFloat64(k.Candle[1]),
+						High:      ToFloat64(k.Candle[2]),
+						Low:       ToFloat64(k.Candle[3]),
+						Close:     ToFloat64(k.Candle[4]),
+						Vol:       ToFloat64(k.Candle[5]),
+					},
+					Vol2: ToFloat64(k.Candle[6]),
+				}, ToInt(periodMs))
+			}
+			return nil
+		}
+	}
+
+	return &common.UnknownChannelError{Channel: ch, Raw: data}
+}
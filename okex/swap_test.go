@@ -1 +1,50 @@
 package okex
+
+import (
+	"github.com/nntaoli-project/goex"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewSwapWs(t *testing.T) {
+	os.Setenv("HTTPS_PROXY", "socks5://127.0.0.1:1080")
+	swapWs := NewSwapWs()
+	swapWs.TickerCallback(func(ticker *goex.FutureTicker) {
+		t.Log(ticker.Ticker)
+	})
+	swapWs.DepthCallback(func(depth *goex.Depth) {
+		t.Log(depth)
+	})
+	swapWs.TradeCallback(func(trade *goex.Trade, contract string) {
+		t.Log(contract, trade)
+	})
+	swapWs.KlineCallback(func(kline *goex.FutureKline, period int, contract string) {
+		t.Log(period, kline.Kline, kline.Vol2)
+	})
+	swapWs.FundingRateCallback(func(fundingRate *FundingRate) {
+		t.Log(fundingRate)
+	})
+	swapWs.MarkPriceCallback(func(markPrice *MarkPrice) {
+		t.Log(markPrice)
+	})
+	swapWs.SubscribeTicker(goex.BTC_USD, goex.SWAP_CONTRACT)
+	swapWs.SubscribeDepth(goex.BTC_USDT, 5, goex.SWAP_CONTRACT)
+	swapWs.SubscribeFundingRate(goex.BTC_USD)
+	swapWs.SubscribeMarkPrice(goex.BTC_USD)
+	time.Sleep(time.Minute)
+}
+
+func TestSwapWs_Contract(t *testing.T) {
+	ws := NewSwapWs()
+	defer ws.Close()
+	ws.DepthCallback(func(depth *goex.Depth) {})
+	for _, contract := range []string{goex.THIS_WEEK_CONTRACT, goex.QUARTER_CONTRACT} {
+		if err := ws.SubscribeDepth(goex.BTC_USD, 5, contract); err == nil {
+			t.Errorf("%s subscribed as a swap", contract)
+		}
+		if err := ws.UnsubscribeDepth(goex.BTC_USD, contract); err == nil {
+			t.Errorf("%s unsubscribed as a swap", contract)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package goexws
+
+import (
+	"testing"
+
+	"github.com/goex-top/goexws/binance"
+	"github.com/goex-top/goexws/common"
+	"github.com/goex-top/goexws/huobi"
+	"github.com/goex-top/goexws/internal/wstest"
+	"github.com/goex-top/goexws/okex"
+	"github.com/nntaoli-project/goex"
+)
+
+// TestSpotWs_Panic makes the ticker callback of every spot adapter panic,
+// the panic has to be reported with what the adapter was handling.
+func TestSpotWs_Panic(t *testing.T) {
+	for _, tc := range []struct {
+		ex     string
+		setURL func(api SpotWsApi, url string)
+		frames [2]string
+		// raw is the part of the first frame the adapter parsed
+		raw string
+	}{
+		{
+			ex: Spot_Binance,
+			setURL: func(api SpotWsApi, url string) {
+				api.(*binance.SpotWs).SetCombinedBaseURL(url + "/stream?streams=")
+			},
+			frames: [2]string{
+				`{"stream":"btcusdt@ticker","data":{"e":"24hrTicker","c":"1"}}`,
+				`{"stream":"btcusdt@ticker","data":{"e":"24hrTicker","c":"2"}}`,
+			},
+			raw: `{"e":"24hrTicker","c":"1"}`,
+		},
+		{
+			ex:     Spot_Huobi,
+			setURL: func(api SpotWsApi, url string) { api.(*huobi.SpotWs).SetBaseUrl(url) },
+			frames: [2]string{
+				`{"ch":"market.btcusdt.detail","ts":1,"tick":{"close":1}}`,
+				`{"ch":"market.btcusdt.detail","ts":2,"tick":{"close":2}}`,
+			},
+			raw: `{"ch":"market.btcusdt.detail","ts":1,"tick":{"close":1}}`,
+		},
+		{
+			ex:     Spot_OKEx,
+			setURL: func(api SpotWsApi, url string) { api.(*okex.SpotWs).SetBaseUrl(url) },
+			frames: [2]string{
+				`{"table":"spot/ticker","data":[{"instrument_id":"BTC-USDT","last":"1"}]}`,
+				`{"table":"spot/ticker","data":[{"instrument_id":"BTC-USDT","last":"2"}]}`,
+			},
+			raw: `{"table":"spot/ticker","data":[{"instrument_id":"BTC-USDT","last":"1"}]}`,
+		},
+	} {
+		t.Run(tc.ex, func(t *testing.T) {
+			srv := wstest.NewServer(func(c *wstest.Conn, msg string) {})
+			defer srv.Close()
+
+			api, err := SpotBuild(tc.ex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.setURL(api, srv.URL)
+			defer api.Close()
+			p := wstest.Panic{
+				Subscribe: func() error { return api.SubscribeTicker(goex.BTC_USDT) },
+				Frames:    tc.frames,
+				Calls:     make(chan struct{}, 4),
+				Errors:    make(chan error, 4),
+			}
+			api.(interface{ ErrorCallback(func(error)) }).ErrorCallback(func(err error) { p.Errors <- err })
+			api.TickerCallback(func(ticker *goex.Ticker) {
+				if ticker.Last == 1 {
+					panic("boom")
+				}
+				p.Calls <- struct{}{}
+			})
+
+			err = wstest.CheckPanic(t, srv, p)
+			e, ok := err.(*common.PanicError)
+			if !ok || e.Value != "boom" || len(e.Stack) == 0 {
+				t.Fatalf("got %#v", err)
+			}
+			if ticker, ok := e.Data.(*goex.Ticker); !ok || ticker.Last != 1 {
+				t.Fatalf("panic reported with %#v, want the ticker", e.Data)
+			}
+			if string(e.Raw) != tc.raw {
+				t.Fatalf("panic reported with %s, want %s", e.Raw, tc.raw)
+			}
+		})
+	}
+}